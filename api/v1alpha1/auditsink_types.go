@@ -0,0 +1,122 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AuditSinkConditionReady reports whether the sink is created and receiving events.
+const AuditSinkConditionReady = "Ready"
+
+// AuditSinkCredentialsLabel, set to "true", marks the credentials Secrets of AuditSinks, the only Secrets the operator
+// reads. AuditSinks referencing a Secret without it are invalid.
+const AuditSinkCredentialsLabel = "audit.my.domain/credentials"
+
+// SecretKeyRef references the credentials of a sink in a Secret of the namespace of the AuditSink.
+type SecretKeyRef struct {
+	// Name of the Secret
+	Name string `json:"name"`
+
+	// Keys maps credential names to the Secret keys holding them, when they differ. e.g (password: es-password)
+	// Credentials are username, password, token, hmacSecret, webhookURL, accessKeyID, secretAccessKey, sessionToken,
+	// caCert, tlsCert, tlsKey and natsCredentials
+	Keys map[string]string `json:"keys,omitempty"`
+}
+
+// AuditSinkBatching defines how events are batched by the sinks sending them in batches.
+type AuditSinkBatching struct {
+	// BatchSize is the maximum number of events sent at once
+	// +kubebuilder:validation:Minimum=1
+	BatchSize int `json:"batchSize,omitempty"`
+
+	// FlushInterval is the maximum time an event waits for its batch to fill up. e.g (5s)
+	FlushInterval *metav1.Duration `json:"flushInterval,omitempty"`
+
+	// QueueSize is the number of events buffered while waiting to be sent
+	// +kubebuilder:validation:Minimum=1
+	QueueSize int `json:"queueSize,omitempty"`
+}
+
+// EventFilter matches audit events. An event matches if it matches every non empty list.
+type EventFilter struct {
+	// Kinds of the resources matched. e.g (Deployment, Service)
+	Kinds []string `json:"kinds,omitempty"`
+
+	// Actions matched. e.g (Create, Update, Delete)
+	Actions []string `json:"actions,omitempty"`
+
+	// Namespaces of the resources matched
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// ChangedPaths matches the events changing a field at or under one of the paths, [*] matching any index.
+	// e.g (.spec.replicas, .spec.template.spec.containers[*].image)
+	ChangedPaths []string `json:"changedPaths,omitempty"`
+
+	// Actors matched, the field managers of the changes. e.g (kubectl-edit, helm)
+	Actors []string `json:"actors,omitempty"`
+
+	// MinSeverity matches the events at least as severe. e.g (critical)
+	// +kubebuilder:validation:Enum=info;low;medium;high;critical
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// AuditSinkSpec defines the desired state of AuditSink.
+type AuditSinkSpec struct {
+	// Type of the sink
+	// +kubebuilder:validation:Enum=console;http;chat;kafka;nats;opensearch;loki;otlp;s3;file;syslog
+	Type string `json:"type"`
+
+	// Endpoint the events are sent to, a URL, address or comma separated brokers depending on the type. The path of a
+	// file sink is relative to the AuditSinks directory of the manager.
+	// e.g (https://audit.example.com/events, kafka-0.kafka:9092)
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// CredentialsSecretRef references the Secret holding the credentials of the sink, labelled
+	// audit.my.domain/credentials: "true"
+	CredentialsSecretRef *SecretKeyRef `json:"credentialsSecretRef,omitempty"`
+
+	Batching *AuditSinkBatching `json:"batching,omitempty"`
+
+	// Filter restricts the events sent to the sink
+	Filter *EventFilter `json:"filter,omitempty"`
+
+	// Config is the configuration of the type, as in the sinks config file. Endpoint, credentials and batching take
+	// precedence over it. Local paths are relative to the AuditSinks directory of the manager, and TLS and NATS
+	// credentials files are not allowed, being read from the credentials instead
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	Config *runtime.RawExtension `json:"config,omitempty"`
+}
+
+// AuditSinkStatus defines the observed state of AuditSink.
+type AuditSinkStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// AuditSink is the Schema for the auditsinks API. Watches of its namespace send their events to it by name.
+type AuditSink struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AuditSinkSpec   `json:"spec,omitempty"`
+	Status AuditSinkStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AuditSinkList contains a list of AuditSink.
+type AuditSinkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AuditSink `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AuditSink{}, &AuditSinkList{})
+}
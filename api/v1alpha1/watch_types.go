@@ -1,6 +1,8 @@
 package v1alpha1
 
 import (
+	"slices"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -13,14 +15,200 @@ type WatchSelector struct {
 	Kinds []string `json:"kinds,omitempty"`
 }
 
+// RedactionPolicy defines the fields replaced with their hash before an audit event reaches any sink.
+// It is applied in addition to the operator wide policy.
+type RedactionPolicy struct {
+	// Paths is the list of JSONPath expressions of fields to redact. e.g (.spec.template.spec.containers[*].args)
+	Paths []string `json:"paths,omitempty"`
+
+	// KeyPatterns is the list of regular expressions matched against field keys and env var names. e.g ((?i)password)
+	KeyPatterns []string `json:"keyPatterns,omitempty"`
+}
+
 // WatchSpec defines the desired state of Watch.
 type WatchSpec struct {
 	Selectors []WatchSelector `json:"selectors"`
+
+	// Redaction defines the sensitive fields of watched resources to redact
+	Redaction *RedactionPolicy `json:"redaction,omitempty"`
+
+	// Sinks are the names of the AuditSinks of the namespace of the watch its events are sent to, instead of the
+	// sinks of the manager. e.g (team-a-siem)
+	Sinks []string `json:"sinks,omitempty"`
+
+	// Routes send the events matching them to AuditSinks, in addition to Sinks. Events are sent to the sinks of every
+	// route they match; the events matching none only go to Sinks, or to the sinks of the manager if Sinks is empty
+	Routes []WatchRoute `json:"routes,omitempty"`
+
+	// Condition is a CEL expression deciding whether an event is emitted, evaluated against old, new, changes and actor.
+	// old is null for creations and new for deletions. Events are emitted if it fails to evaluate.
+	// e.g (new.spec.replicas < old.spec.replicas)
+	Condition string `json:"condition,omitempty"`
+
+	// Enrichments are CEL expressions computing string fields attached to the events, by field name, evaluated as
+	// Condition. e.g (team: new.metadata.labels.team)
+	Enrichments map[string]string `json:"enrichments,omitempty"`
+
+	// SeverityRules classify the events of the watch in addition to the built-in rules. An event gets the highest
+	// severity of the rules it matches
+	SeverityRules []SeverityRule `json:"severityRules,omitempty"`
+
+	// Baseline pins the desired state of the selected resources, reporting and optionally reverting their drift
+	Baseline *Baseline `json:"baseline,omitempty"`
+
+	// FreezeWindows are the periods changes to the selected resources are flagged or rejected during
+	FreezeWindows []FreezeWindow `json:"freezeWindows,omitempty"`
+
+	// ChangeTicket requires updates of the selected resources to reference a change ticket
+	ChangeTicket *ChangeTicket `json:"changeTicket,omitempty"`
+
+	// Related audits the changes of the objects related to the selected resources, e.g the HPA of a Deployment
+	Related *RelatedAudit `json:"related,omitempty"`
+}
+
+// RelatedAudit selects the related objects audited along with the resources of a watch. Services selected by the
+// watch are audited as such and not as related objects.
+type RelatedAudit struct {
+	// Kinds of related objects audited, all of them if empty: Service, HorizontalPodAutoscaler, PodDisruptionBudget or
+	// Ingress. e.g (HorizontalPodAutoscaler)
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+// ChangeTicket requires the spec updates of the selected resources to carry a ticket annotation matching Pattern. The
+// ticket is recorded in the audit events of every change.
+type ChangeTicket struct {
+	// Annotation holding the ticket. e.g (change.my.domain/ticket)
+	// +kubebuilder:default="change.my.domain/ticket"
+	Annotation string `json:"annotation,omitempty"`
+
+	// Pattern is the regular expression the ticket must match, any ticket if empty. e.g (^CHG-[0-9]+$)
+	Pattern string `json:"pattern,omitempty"`
+
+	// Kinds requiring a ticket, every kind selected by the watch if empty. e.g (Deployment)
+	Kinds []string `json:"kinds,omitempty"`
+
+	// ExemptUsers may update resources without a ticket. e.g (system:serviceaccount:argocd:argocd-application-controller)
+	ExemptUsers []string `json:"exemptUsers,omitempty"`
+
+	// ExemptGroups may update resources without a ticket. e.g (sre-oncall)
+	ExemptGroups []string `json:"exemptGroups,omitempty"`
+}
+
+// Freeze window modes.
+const (
+	FreezeModeAudit   = "Audit"
+	FreezeModeEnforce = "Enforce"
+)
+
+// FreezeWindow is a recurring or absolute period changes are frozen during. A recurring window starts on Schedule
+// and lasts Duration, an absolute one lasts from Start to End.
+type FreezeWindow struct {
+	// Name of the window, reported with the changes it flags or rejects. e.g (end-of-year)
+	Name string `json:"name"`
+
+	// Schedule is a cron expression of the starts of a recurring window. e.g (0 18 * * 5)
+	Schedule string `json:"schedule,omitempty"`
+
+	// Duration of a recurring window. e.g (62h)
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// Start of an absolute window, RFC 3339 or local to TimeZone. e.g (2026-12-20T00:00:00)
+	Start string `json:"start,omitempty"`
+
+	// End of an absolute window, RFC 3339 or local to TimeZone. e.g (2027-01-04T09:00:00)
+	End string `json:"end,omitempty"`
+
+	// TimeZone of Schedule, and of Start and End without an offset. UTC if empty. e.g (Europe/Berlin)
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Mode is Audit to tag the events of changes with FreezeViolation, Enforce to reject the changes
+	// +kubebuilder:validation:Enum=Audit;Enforce
+	// +kubebuilder:default=Audit
+	Mode string `json:"mode,omitempty"`
+
+	// Kinds frozen, every kind selected by the watch if empty. e.g (Deployment)
+	Kinds []string `json:"kinds,omitempty"`
+
+	// ExemptUsers may change resources during the window, in Enforce mode only as the requesting user of a change is
+	// unknown once it is applied. e.g (system:serviceaccount:argocd:argocd-application-controller)
+	ExemptUsers []string `json:"exemptUsers,omitempty"`
+
+	// ExemptGroups may change resources during the window, in Enforce mode only. e.g (sre-oncall)
+	ExemptGroups []string `json:"exemptGroups,omitempty"`
+}
+
+// Baseline modes.
+const (
+	BaselineModeDetect = "Detect"
+	BaselineModeRevert = "Revert"
+)
+
+// WatchConditionDrifted reports whether selected resources drifted from the baseline of a watch.
+const WatchConditionDrifted = "Drifted"
+
+// Baseline is the desired state of the resources selected by a watch. Without a ConfigMap, the fields are snapshotted
+// from each resource when first seen, kept in the <watch>-baseline ConfigMap; deleting it pins the live state again.
+type Baseline struct {
+	// Fields are the paths of the fields pinned, [*] matching any index. Required without a ConfigMap, defaults to the
+	// spec of the manifests otherwise. e.g (.spec.replicas, .spec.template.spec.containers[*].image)
+	Fields []string `json:"fields,omitempty"`
+
+	// ConfigMapName is the name of a ConfigMap of the namespace of the watch holding the desired manifests, one or
+	// more YAML documents per key. Resources without a manifest are not checked
+	ConfigMapName string `json:"configMapName,omitempty"`
+
+	// Mode is Detect to report drift only, Revert to also patch the drifted fields back
+	// +kubebuilder:validation:Enum=Detect;Revert
+	// +kubebuilder:default=Detect
+	Mode string `json:"mode,omitempty"`
+}
+
+// SeverityRule classifies the events matching its condition.
+type SeverityRule struct {
+	// Name identifies the rule in the events it matches. e.g (prod-scale-down)
+	Name string `json:"name"`
+
+	// Severity of the events matched
+	// +kubebuilder:validation:Enum=info;low;medium;high;critical
+	Severity string `json:"severity"`
+
+	// Condition is a CEL expression matching events, evaluated as the condition of the watch.
+	// e.g (new.spec.replicas < old.spec.replicas / 2)
+	Condition string `json:"condition"`
+}
+
+// RiskyEvent is a recent high or critical event of a watch.
+type RiskyEvent struct {
+	Time      metav1.Time `json:"time"`
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Action    string      `json:"action"`
+	Actor     string      `json:"actor,omitempty"`
+	Severity  string      `json:"severity"`
+	// Rules are the names of the severity rules matched
+	Rules []string `json:"rules,omitempty"`
+}
+
+// WatchRoute sends the events of a watch matching it to AuditSinks.
+type WatchRoute struct {
+	// Match restricts the events sent. Every event matches if empty
+	Match *EventFilter `json:"match,omitempty"`
+
+	// Sinks are the names of the AuditSinks of the namespace of the watch the events are sent to
+	// +kubebuilder:validation:MinItems=1
+	Sinks []string `json:"sinks"`
 }
 
 // WatchStatus defines the observed state of Watch.
 type WatchStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// RiskyEvents are the most recent high and critical events, the latest first
+	RiskyEvents []RiskyEvent `json:"riskyEvents,omitempty"`
+
+	// DriftedResources are the resources diverging from the baseline. e.g (Deployment/default/app)
+	DriftedResources []string `json:"driftedResources,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -35,6 +223,16 @@ type Watch struct {
 	Status WatchStatus `json:"status,omitempty"`
 }
 
+// Selects reports whether a selector of the watch selects kind in namespace.
+func (w *Watch) Selects(namespace, kind string) bool {
+	for _, selector := range w.Spec.Selectors {
+		if selector.Namespace == namespace && slices.Contains(selector.Kinds, kind) {
+			return true
+		}
+	}
+	return false
+}
+
 // +kubebuilder:object:root=true
 
 // WatchList contains a list of Watch.
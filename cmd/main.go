@@ -1,26 +1,36 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
 
 	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/redaction"
+	"github.com/vandathron/watchman/internal/utils"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
 	"github.com/vandathron/watchman/internal/controller"
+	webhookfreeze "github.com/vandathron/watchman/internal/webhook/freeze"
+	webhookticket "github.com/vandathron/watchman/internal/webhook/ticket"
 	webhookauditv1alpha1 "github.com/vandathron/watchman/internal/webhook/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
@@ -43,6 +53,14 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var patchSizeLimit int
+	var redactPaths, redactKeyPatterns stringList
+	var redactionKeyPath string
+	var enableHashChain bool
+	var chainSigningKeyPath string
+	var chainCheckpointInterval int
+	var sinksConfigPath string
+	var auditSinksDir string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -54,6 +72,28 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.IntVar(&patchSizeLimit, "audit-patch-size-limit", utils.DefaultPatchSizeLimit,
+		"The maximum size in bytes of each JSON Patch and JSON Merge Patch attached to an update event. "+
+			"Larger patches are left out and marked as truncated. Use 0 for no limit.")
+	flag.Var(&redactPaths, "redact-path", "JSONPath of a field to redact from every audited resource "+
+		"e.g .spec.template.spec.containers[*].args. Can be repeated.")
+	flag.Var(&redactKeyPatterns, "redact-key-pattern", "Regular expression matched against field keys and env var names "+
+		"to redact from every audited resource. Can be repeated. Defaults to common credential names such as *PASSWORD* or *TOKEN*.")
+	flag.StringVar(&redactionKeyPath, "redaction-key", "",
+		"Path of the key, e.g mounted from a Secret, redacted values are hashed with using HMAC-SHA256. At least 32 bytes. "+
+			"A random key is generated on start if empty, so hashes of the same value differ across restarts.")
+	flag.BoolVar(&enableHashChain, "audit-hash-chain", false,
+		"If set, every audit event carries a sequence number and a SHA-256 hash chained to the previous event.")
+	flag.StringVar(&chainSigningKeyPath, "audit-chain-signing-key", "",
+		"Path of the PEM encoded ed25519 private key, e.g mounted from a Secret, used to sign chain checkpoints. "+
+			"No checkpoints are emitted if empty.")
+	flag.IntVar(&chainCheckpointInterval, "audit-chain-checkpoint-interval", 100,
+		"The number of chained audit events between two signed checkpoints.")
+	flag.StringVar(&sinksConfigPath, "sinks-config", "",
+		"Path of the YAML file configuring the sinks audit events are sent to. Events are logged to the console if empty.")
+	flag.StringVar(&auditSinksDir, "auditsink-dir", "",
+		"The directory AuditSinks write their local files under, e.g file sink paths or dead letter files, in a directory "+
+			"per namespace. AuditSinks writing local files are rejected if empty.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -85,6 +125,71 @@ func main() {
 		metricsServerOptions.FilterProvider = filters.WithAuthenticationAndAuthorization
 	}
 
+	if len(redactKeyPatterns) == 0 {
+		redactKeyPatterns = redaction.DefaultKeyPatterns
+	}
+	redactionPolicy, err := redaction.NewPolicy(redactPaths, redactKeyPatterns)
+	if err != nil {
+		setupLog.Error(err, "invalid redaction policy")
+		os.Exit(1)
+	}
+	if redactionKeyPath != "" {
+		key, err := os.ReadFile(redactionKeyPath)
+		if err != nil {
+			setupLog.Error(err, "unable to read redaction key")
+			os.Exit(1)
+		}
+		if redactionPolicy, err = redactionPolicy.WithKey(key); err != nil {
+			setupLog.Error(err, "invalid redaction key")
+			os.Exit(1)
+		}
+	}
+
+	// Every sink gets a hash chain of its own past its filter, so each receives a chain without gaps
+	var chain func(next loghandler.Provider) (loghandler.Provider, error)
+	if enableHashChain {
+		var signingKey ed25519.PrivateKey
+		if chainSigningKeyPath != "" {
+			data, err := os.ReadFile(chainSigningKeyPath)
+			if err != nil {
+				setupLog.Error(err, "unable to read chain signing key")
+				os.Exit(1)
+			}
+			if signingKey, err = loghandler.ParseSigningKey(data); err != nil {
+				setupLog.Error(err, "invalid chain signing key")
+				os.Exit(1)
+			}
+		}
+
+		chain = func(next loghandler.Provider) (loghandler.Provider, error) {
+			return loghandler.NewHashChain(next, signingKey, chainCheckpointInterval)
+		}
+	}
+
+	sinksConfig := &loghandler.Config{Sinks: []loghandler.SinkConfig{{Name: "console", Type: loghandler.SinkTypeConsole}}}
+	if sinksConfigPath != "" {
+		if sinksConfig, err = loghandler.LoadConfig(sinksConfigPath); err != nil {
+			setupLog.Error(err, "unable to load sinks config")
+			os.Exit(1)
+		}
+	}
+
+	defaults := make([]loghandler.Provider, 0, len(sinksConfig.Sinks))
+	var sinkRunnables []manager.Runnable
+	for _, sinkConfig := range sinksConfig.Sinks {
+		sink, err := loghandler.NewSink(sinkConfig, chain)
+		if err != nil {
+			setupLog.Error(err, "unable to create sink", "sink", sinkConfig.Name)
+			os.Exit(1)
+		}
+		if runnable, ok := sink.(manager.Runnable); ok {
+			sinkRunnables = append(sinkRunnables, runnable)
+		}
+		defaults = append(defaults, sink)
+	}
+
+	fanout := loghandler.NewFanout(defaults...)
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsServerOptions,
@@ -92,26 +197,62 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "8a74011d.my.domain",
+		Cache: cache.Options{
+			// Only the credentials Secrets of AuditSinks are cached, not every Secret of the cluster
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Secret{}: {Label: controller.CredentialsSecrets()},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	for _, runnable := range sinkRunnables {
+		if err = mgr.Add(runnable); err != nil {
+			setupLog.Error(err, "unable to add sink to manager")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controller.WatchReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
-		Audit:  loghandler.NewConsole(),
+		Audit:  fanout,
+
+		PatchSizeLimit: patchSizeLimit,
+		Redaction:      redactionPolicy,
+		APIReader:      mgr.GetAPIReader(),
+		Fanout:         fanout,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Watch")
 		os.Exit(1)
 	}
+	if err = (&controller.AuditSinkReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Fanout:   fanout,
+		SinksDir: auditSinksDir,
+		Chain:    chain,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AuditSink")
+		os.Exit(1)
+	}
 	// nolint:goconst
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
 		if err = webhookauditv1alpha1.SetupWatchWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Watch")
 			os.Exit(1)
 		}
+		if err = webhookfreeze.SetupFreezeWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Freeze")
+			os.Exit(1)
+		}
+		if err = webhookticket.SetupTicketWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Ticket")
+			os.Exit(1)
+		}
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -130,3 +271,15 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
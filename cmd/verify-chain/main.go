@@ -0,0 +1,93 @@
+// Command verify-chain walks a stored log of hash chained audit events, one JSON event per line, and reports
+// gaps, broken links, altered events and invalid checkpoint signatures. Gzip compressed logs, e.g the backups rotated
+// by the file sink, are decompressed.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+func main() {
+	var logPath string
+	var publicKeyPath string
+	var allowPartial bool
+	flag.StringVar(&logPath, "log", "-", "Path of the stored audit log to verify, gzip compressed or not. Use - to read from stdin.")
+	flag.StringVar(&publicKeyPath, "public-key", "",
+		"Path of the PEM encoded ed25519 public key checkpoints are verified with. Signatures are not checked if empty.")
+	flag.BoolVar(&allowPartial, "allow-partial", false,
+		"Tolerate chains not starting at their first event, e.g in files rotated by the file sink. Their head being removed is not detected.")
+	flag.Parse()
+
+	var key ed25519.PublicKey
+	if publicKeyPath != "" {
+		data, err := os.ReadFile(publicKeyPath)
+		if err != nil {
+			exitf("failed to read public key: %v", err)
+		}
+		if key, err = loghandler.ParseVerifyingKey(data); err != nil {
+			exitf("invalid public key: %v", err)
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if logPath != "-" {
+		f, err := os.Open(logPath)
+		if err != nil {
+			exitf("failed to open log: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	in, err := decompress(in)
+	if err != nil {
+		exitf("failed to read log: %v", err)
+	}
+
+	report, err := loghandler.VerifyChain(in, key, allowPartial)
+	if err != nil {
+		exitf("failed to verify log: %v", err)
+	}
+
+	fmt.Printf("%d chained events in %d chain(s), %d other lines skipped\n", report.Events, report.Chains, report.Skipped)
+	for _, partial := range report.Partial {
+		fmt.Printf("NOTE: %s\n", partial)
+	}
+	for _, unsigned := range report.Unsigned {
+		fmt.Printf("WARNING: %s\n", unsigned)
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("FAIL: %s\n", problem)
+	}
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+// decompress returns the decompressed content of in if it is gzip compressed, in as is otherwise.
+func decompress(in io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(in)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return buffered, nil
+	}
+	return gzip.NewReader(buffered)
+}
+
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(2)
+}
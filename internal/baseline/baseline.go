@@ -0,0 +1,237 @@
+// Package baseline compares resources against a pinned desired state. Objects are flattened to their leaf values by
+// JSON pointer, so a baseline can pin a few fields or a whole manifest and drift is reverted with a JSON patch.
+package baseline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/redaction"
+)
+
+// Actions of the events emitted for baselines.
+const (
+	// ActionDrift is emitted once a resource diverges from its baseline
+	ActionDrift = "Drift"
+	// ActionConverge is emitted once a drifted resource matches its baseline again
+	ActionConverge = "Converge"
+	// ActionRevert is emitted once the drifted fields of a resource are patched back
+	ActionRevert = "Revert"
+)
+
+// State is the leaf values of an object by JSON pointer. e.g (/spec/replicas: 3)
+type State map[string]interface{}
+
+// Drift is a leaf diverging from the baseline.
+type Drift struct {
+	Pointer string
+	// Desired is the baseline value, nil if the leaf is not part of it
+	Desired interface{}
+	// Live is the current value, nil if the leaf is missing
+	Live interface{}
+	// InDesired and InLive report whether the leaf is part of the baseline and of the live object
+	InDesired, InLive bool
+}
+
+// Path returns the path of the drifted field. e.g (.spec.template.spec.containers[0].image)
+func (d Drift) Path() string {
+	return Path(d.Pointer)
+}
+
+// Redacted reports whether the baseline or live value of the drifted leaf is redacted, their hashes only being
+// comparable when hashed with the same key.
+func (d Drift) Redacted() bool {
+	return redacted(d.Desired) || redacted(d.Live)
+}
+
+func redacted(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, redaction.HashPrefix)
+}
+
+// Flatten returns the leaf values of obj. Empty maps and lists are leaves.
+func Flatten(obj map[string]interface{}) State {
+	state := State{}
+	flatten("", obj, state)
+	return state
+}
+
+func flatten(pointer string, value interface{}, state State) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && pointer != "" {
+			state[pointer] = v
+		}
+		for k, child := range v {
+			flatten(pointer+"/"+escape(k), child, state)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			state[pointer] = v
+		}
+		for i, child := range v {
+			flatten(pointer+"/"+strconv.Itoa(i), child, state)
+		}
+	default:
+		state[pointer] = v
+	}
+}
+
+// Select returns the leaves of s at or under one of paths, [*] matching any index.
+// e.g (.spec.template.spec.containers[*].image)
+func (s State) Select(paths []string) State {
+	selected := State{}
+	for pointer, value := range s {
+		for _, path := range paths {
+			if loghandler.MatchPath(path, Path(pointer)) {
+				selected[pointer] = value
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// Diff returns the leaves of desired diverging in live, sorted by pointer. With strict, the leaves of live selected by
+// paths and missing from desired are drifts as well, e.g a container added.
+func Diff(desired, live State, strict bool, paths []string) []Drift {
+	var drifts []Drift
+	for pointer, value := range desired {
+		liveValue, ok := live[pointer]
+		if !ok || !equal(value, liveValue) {
+			drifts = append(drifts, Drift{Pointer: pointer, Desired: value, Live: liveValue, InDesired: true, InLive: ok})
+		}
+	}
+
+	if strict {
+		for pointer, value := range live.Select(paths) {
+			if _, ok := desired[pointer]; !ok {
+				drifts = append(drifts, Drift{Pointer: pointer, Live: value, InLive: true})
+			}
+		}
+	}
+
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Pointer < drifts[j].Pointer })
+	return drifts
+}
+
+// RevertPatch returns the JSON patch setting the drifted leaves of desired back to their baseline values and removing
+// the extra ones selected by paths. Leaves whose baseline value is redacted can not be reverted and are returned in
+// skipped.
+func RevertPatch(desired State, drifts []Drift, paths []string) (patch []byte, skipped []Drift, err error) {
+	type operation struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+
+	var ops, removals []operation
+	removed := map[string]bool{}
+	for _, drift := range drifts {
+		if redacted(drift.Desired) {
+			skipped = append(skipped, drift)
+			continue
+		}
+
+		if !drift.InDesired {
+			pointer := extraRoot(desired, drift.Pointer, paths)
+			if !removed[pointer] {
+				removed[pointer] = true
+				removals = append(removals, operation{Op: "remove", Path: pointer})
+			}
+			continue
+		}
+
+		value, err := json.Marshal(drift.Desired)
+		if err != nil {
+			return nil, nil, err
+		}
+		op := "add"
+		if drift.InLive {
+			op = "replace"
+		}
+		ops = append(ops, operation{Op: op, Path: drift.Pointer, Value: value})
+	}
+
+	// Removing list items from the last keeps the indexes of the others valid
+	sort.Slice(removals, func(i, j int) bool { return comparePointers(removals[i].Path, removals[j].Path) > 0 })
+	ops = append(ops, removals...)
+	if len(ops) == 0 {
+		return nil, skipped, nil
+	}
+
+	patch, err = json.Marshal(ops)
+	return patch, skipped, err
+}
+
+// Path converts pointer to the path format of audit event changes. e.g (/spec/ports/0/port to .spec.ports[0].port)
+func Path(pointer string) string {
+	var path strings.Builder
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		if _, err := strconv.Atoi(token); err == nil {
+			fmt.Fprintf(&path, "[%s]", token)
+			continue
+		}
+		path.WriteString("." + unescape(token))
+	}
+	return path.String()
+}
+
+// extraRoot returns the shallowest parent of pointer selected by paths and not part of desired, e.g the list item of
+// an extra leaf, so it is removed as a whole.
+func extraRoot(desired State, pointer string, paths []string) string {
+	parents := map[string]bool{}
+	for p := range desired {
+		for i := strings.LastIndexByte(p, '/'); i > 0; i = strings.LastIndexByte(p[:i], '/') {
+			parents[p[:i]] = true
+		}
+	}
+
+	for i := 1; i < len(pointer); i++ {
+		if pointer[i] != '/' || parents[pointer[:i]] {
+			continue
+		}
+		for _, path := range paths {
+			if loghandler.MatchPath(path, Path(pointer[:i])) {
+				return pointer[:i]
+			}
+		}
+	}
+	return pointer
+}
+
+func equal(a, b interface{}) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+// comparePointers orders pointers by token, numerically for indexes.
+func comparePointers(a, b string) int {
+	tokensA, tokensB := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(tokensA) && i < len(tokensB); i++ {
+		if tokensA[i] == tokensB[i] {
+			continue
+		}
+		indexA, errA := strconv.Atoi(tokensA[i])
+		indexB, errB := strconv.Atoi(tokensB[i])
+		if errA == nil && errB == nil {
+			return indexA - indexB
+		}
+		return strings.Compare(tokensA[i], tokensB[i])
+	}
+	return len(tokensA) - len(tokensB)
+}
+
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func unescape(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
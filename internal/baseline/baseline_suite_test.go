@@ -0,0 +1,14 @@
+package baseline
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBaseline(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Baseline Suite")
+}
@@ -0,0 +1,99 @@
+package baseline
+
+import (
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vandathron/watchman/internal/redaction"
+)
+
+var _ = Describe("Baseline", func() {
+	deployment := func(replicas int64, images ...string) map[string]interface{} {
+		containers := make([]interface{}, 0, len(images))
+		for _, image := range images {
+			containers = append(containers, map[string]interface{}{"name": image, "image": image})
+		}
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "app", "labels": map[string]interface{}{"app.kubernetes.io/name": "app"}},
+			"spec": map[string]interface{}{
+				"replicas": replicas,
+				"template": map[string]interface{}{"spec": map[string]interface{}{"containers": containers}},
+			},
+		}
+	}
+	paths := []string{".spec.replicas", ".spec.template.spec.containers[*]"}
+
+	It("Should flatten objects by JSON pointer and select fields by path", func() {
+		state := Flatten(deployment(3, "app:1"))
+		Expect(state).To(HaveKeyWithValue("/metadata/labels/app.kubernetes.io~1name", "app"))
+		Expect(Path("/metadata/labels/app.kubernetes.io~1name")).To(Equal(".metadata.labels.app.kubernetes.io/name"))
+
+		Expect(state.Select(paths)).To(Equal(State{
+			"/spec/replicas":                         int64(3),
+			"/spec/template/spec/containers/0/name":  "app:1",
+			"/spec/template/spec/containers/0/image": "app:1",
+		}))
+	})
+
+	It("Should compare a snapshot to live values", func() {
+		raw, err := json.Marshal(Flatten(deployment(3, "app:1")).Select(paths))
+		Expect(err).NotTo(HaveOccurred())
+		var snapshot State
+		Expect(json.Unmarshal(raw, &snapshot)).To(Succeed())
+
+		Expect(Diff(snapshot, Flatten(deployment(3, "app:1")), true, paths)).To(BeEmpty())
+
+		drifts := Diff(snapshot, Flatten(deployment(5, "app:1", "sidecar")), true, paths)
+		Expect(drifts).To(HaveLen(3))
+		Expect(drifts[0].Path()).To(Equal(".spec.replicas"))
+		Expect(drifts[0].Live).To(Equal(int64(5)))
+		Expect(drifts[1].Path()).To(Equal(".spec.template.spec.containers[1].image"))
+		Expect(drifts[1].InDesired).To(BeFalse())
+
+		Expect(Diff(snapshot, Flatten(deployment(3, "app:1", "sidecar")), false, paths)).To(BeEmpty())
+	})
+
+	It("Should revert drift with a JSON patch", func() {
+		desired := Flatten(deployment(3, "app:1")).Select(paths)
+		live := deployment(5, "app:2", "sidecar", "proxy")
+		drifts := Diff(desired, Flatten(live), true, paths)
+
+		patch, skipped, err := RevertPatch(desired, drifts, paths)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(skipped).To(BeEmpty())
+
+		decoded, err := jsonpatch.DecodePatch(patch)
+		Expect(err).NotTo(HaveOccurred())
+		raw, err := json.Marshal(live)
+		Expect(err).NotTo(HaveOccurred())
+		reverted, err := decoded.Apply(raw)
+		Expect(err).NotTo(HaveOccurred())
+
+		var obj map[string]interface{}
+		Expect(json.Unmarshal(reverted, &obj)).To(Succeed())
+		Expect(Diff(desired, Flatten(obj), true, paths)).To(BeEmpty())
+	})
+
+	It("Should not revert redacted values", func() {
+		desired := State{"/spec/replicas": int64(3), "/spec/token": redaction.Hash([]byte("key"), "secret")}
+		live := State{"/spec/replicas": int64(3), "/spec/token": redaction.Hash([]byte("key"), "changed")}
+
+		patch, skipped, err := RevertPatch(desired, Diff(desired, live, false, nil), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch).To(BeNil())
+		Expect(skipped).To(HaveLen(1))
+		Expect(skipped[0].Redacted()).To(BeTrue())
+	})
+
+	It("Should never remove more than the fields selected", func() {
+		paths := []string{".spec.template.spec.containers[*].env"}
+		live := State{"/spec/template/spec/containers/0/env/0/name": "DEBUG"}
+
+		drifts := Diff(State{}, live, true, paths)
+		patch, _, err := RevertPatch(State{}, drifts, paths)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(patch)).To(Equal(`[{"op":"remove","path":"/spec/template/spec/containers/0/env"}]`))
+	})
+})
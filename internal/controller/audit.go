@@ -0,0 +1,408 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/expression"
+	"github.com/vandathron/watchman/internal/freeze"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/redaction"
+	"github.com/vandathron/watchman/internal/severity"
+	"github.com/vandathron/watchman/internal/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxRiskyEvents is the number of recent high and critical events kept in the status of a watch.
+const maxRiskyEvents = 10
+
+// riskyEventsFlushInterval is how often the risky events emitted are recorded in the status of their watch.
+const riskyEventsFlushInterval = 5 * time.Second
+
+// audit logs an event for action performed on obj once for every watch selecting it and returns the events emitted.
+// old is only expected for updates. Sensitive values are redacted before changes and patches are computed so no
+// provider ever sees them.
+func (r *WatchReconciler) audit(ctx context.Context, action, kind string, old, obj client.Object) []loghandler.AuditEvent {
+	log := log.FromContext(ctx)
+
+	watches, err := r.watchesFor(ctx, obj.GetNamespace(), kind)
+	if err != nil {
+		log.Error(err, "Failed to list watches", "Namespace", obj.GetNamespace(), "Kind", kind)
+	}
+
+	// A later write than the last revert of obj is no longer the operator's
+	if rv, ok := r.reverts.Load(obj.GetUID()); ok && (rv != obj.GetResourceVersion() || action == utils.WatchActionTypeDelete) {
+		r.reverts.Delete(obj.GetUID())
+	}
+
+	if len(watches) == 0 { // resource still annotated but no longer selected by any watch. Audit with operator wide policy only
+		watches = append(watches, auditv1alpha1.Watch{})
+	}
+
+	var events []loghandler.AuditEvent
+	for _, watch := range watches {
+		if event, ok := r.auditFor(ctx, &watch, action, kind, old, obj, false); ok {
+			events = append(events, event)
+		}
+		r.checkBaseline(ctx, &watch, action, kind, obj)
+	}
+	return events
+}
+
+// auditFor logs the event of action performed on obj for watch, unless its condition filters it out, and reports
+// whether it did. The changes of the labels and annotations of obj are recorded too if it is related to the resources
+// of watch rather than selected.
+func (r *WatchReconciler) auditFor(ctx context.Context, watch *auditv1alpha1.Watch, action, kind string, old, obj client.Object, related bool) (loghandler.AuditEvent, bool) {
+	log := log.FromContext(ctx)
+
+	policy, err := r.redactionPolicyFor(watch)
+	if err != nil {
+		log.Error(err, "Invalid redaction policy, using operator wide policy", "Watch", watch.Name, "Namespace", watch.Namespace)
+	}
+
+	event, err := r.buildEvent(action, kind, old, obj, policy, related)
+	if err != nil {
+		log.Error(err, "Failed to build audit event", "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+		return loghandler.AuditEvent{}, false
+	}
+
+	event.Actor = r.actorOf(obj)
+	if watch.Name != "" {
+		event.Watch = fmt.Sprintf("%s/%s", watch.Namespace, watch.Name)
+	}
+	if t := watch.Spec.ChangeTicket; t != nil {
+		event.Ticket = obj.GetAnnotations()[t.Annotation]
+	}
+	if event.Related, err = r.related(ctx, obj); err != nil {
+		log.Error(err, "Failed to resolve related objects", "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+	}
+
+	compiled, err := r.compiledFor(watch)
+	if err != nil {
+		log.Error(err, "Invalid watch expressions, using built-in severity rules only", "Watch", watch.Name, "Namespace", watch.Namespace)
+	}
+	tagFreezeViolation(&event, compiled.freezeWindows)
+
+	in, err := expressionInput(&event, old, obj, policy)
+	if err != nil {
+		log.Error(err, "Failed to evaluate watch expressions", "Watch", watch.Name, "Namespace", watch.Namespace)
+		r.emit(ctx, watch, event)
+		return event, true
+	}
+	if kind == utils.SupportedKindService {
+		r.describeService(ctx, &event, in, old, obj)
+	}
+
+	emit, fields, err := compiled.program.Eval(in)
+	if err != nil {
+		log.Error(err, "Failed to evaluate watch expressions", "Watch", watch.Name, "Namespace", watch.Namespace)
+	}
+	if !emit {
+		return loghandler.AuditEvent{}, false
+	}
+	for name, value := range fields {
+		event.SetField(name, value)
+	}
+
+	event.Severity, event.SeverityRules, err = compiled.classifier.Classify(in)
+	if err != nil {
+		log.Error(err, "Failed to evaluate severity rules", "Watch", watch.Name, "Namespace", watch.Namespace)
+	}
+	r.emit(ctx, watch, event)
+	return event, true
+}
+
+// emit logs event and tracks it, queuing it to be recorded in the status of watch if it is risky.
+func (r *WatchReconciler) emit(ctx context.Context, watch *auditv1alpha1.Watch, event loghandler.AuditEvent) {
+	r.Audit.Log(event)
+	trackEvent(event)
+
+	if watch.Name != "" && loghandler.SeverityRank(event.Severity) >= loghandler.SeverityRank(loghandler.SeverityHigh) {
+		r.queueRiskyEvent(types.NamespacedName{Namespace: watch.Namespace, Name: watch.Name}, event)
+	}
+}
+
+// queueRiskyEvent queues event to be recorded in the status of watch by flushRiskyEvents, keeping the most recent.
+func (r *WatchReconciler) queueRiskyEvent(watch types.NamespacedName, event loghandler.AuditEvent) {
+	risky := auditv1alpha1.RiskyEvent{
+		Time:      metav1.NewTime(event.Timestamp),
+		Kind:      event.Kind,
+		Namespace: event.Namespace,
+		Name:      event.Name,
+		Action:    event.Action,
+		Actor:     event.Actor,
+		Severity:  event.Severity,
+		Rules:     event.SeverityRules,
+	}
+
+	r.riskyMu.Lock()
+	defer r.riskyMu.Unlock()
+	if r.risky == nil {
+		r.risky = map[types.NamespacedName][]auditv1alpha1.RiskyEvent{}
+	}
+	queued := append([]auditv1alpha1.RiskyEvent{risky}, r.risky[watch]...)
+	if len(queued) > maxRiskyEvents {
+		queued = queued[:maxRiskyEvents]
+	}
+	r.risky[watch] = queued
+}
+
+// flushRiskyEvents records the queued risky events in the status of their watch every riskyEventsFlushInterval, with
+// a single update per watch, until ctx is done.
+func (r *WatchReconciler) flushRiskyEvents(ctx context.Context) error {
+	ticker := time.NewTicker(riskyEventsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.recordRiskyEvents(ctx)
+		case <-ctx.Done():
+			r.recordRiskyEvents(context.Background())
+			return nil
+		}
+	}
+}
+
+// recordRiskyEvents adds the queued risky events to the most recent risky events in the status of their watch.
+func (r *WatchReconciler) recordRiskyEvents(ctx context.Context) {
+	r.riskyMu.Lock()
+	queued := r.risky
+	r.risky = nil
+	r.riskyMu.Unlock()
+
+	for watch, events := range queued {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest := &auditv1alpha1.Watch{}
+			if err := r.Get(ctx, watch, latest); err != nil {
+				return err
+			}
+			latest.Status.RiskyEvents = append(slices.Clone(events), latest.Status.RiskyEvents...)
+			if len(latest.Status.RiskyEvents) > maxRiskyEvents {
+				latest.Status.RiskyEvents = latest.Status.RiskyEvents[:maxRiskyEvents]
+			}
+			return r.Status().Update(ctx, latest)
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "Failed to record risky events", "Watch", watch.Name, "Namespace", watch.Namespace)
+		}
+	}
+}
+
+// expressionInput returns what the expressions and severity rules of a watch are evaluated against for event. They
+// see the redacted objects.
+func expressionInput(event *loghandler.AuditEvent, old, obj client.Object, policy *redaction.Policy) (expression.Input, error) {
+	in := expression.Input{Kind: event.Kind, Action: event.Action, Changes: event.Changes, Actor: event.Actor}
+	content, err := redactedContent(event.Kind, obj, policy)
+	if err != nil {
+		return in, err
+	}
+
+	switch {
+	case event.Action == utils.WatchActionTypeDelete:
+		in.Old = content
+	case old != nil:
+		in.New = content
+		in.Old, err = redactedContent(event.Kind, old, policy)
+	default:
+		in.New = content
+	}
+	return in, err
+}
+
+// tagFreezeViolation tags event with freeze.ViolationTag if it happened during one of the Audit windows, naming the
+// windows in the freezeWindow field. Exemptions do not apply as the requesting user of a change is unknown once it is
+// applied, the actor being a field manager any client can set.
+func tagFreezeViolation(event *loghandler.AuditEvent, windows []*freeze.Window) {
+	var violated []string
+	for _, window := range windows {
+		if window.Mode != auditv1alpha1.FreezeModeAudit || !window.Selects(event.Kind) {
+			continue
+		}
+		if active, _ := window.Active(event.Timestamp); active {
+			violated = append(violated, window.Name)
+		}
+	}
+	if len(violated) == 0 {
+		return
+	}
+
+	event.Tags = append(event.Tags, freeze.ViolationTag)
+	event.SetField("freezeWindow", strings.Join(violated, ","))
+}
+
+// compiledWatch holds the compiled expressions, severity rules and freeze windows of a watch at generation.
+type compiledWatch struct {
+	generation    int64
+	program       *expression.Program
+	classifier    *severity.Classifier
+	freezeWindows []*freeze.Window
+}
+
+// compiledFor returns the compiled expressions, severity rules and freeze windows of watch, compiled once per generation. The built-in
+// rules only are applied if they do not compile.
+func (r *WatchReconciler) compiledFor(watch *auditv1alpha1.Watch) (compiledWatch, error) {
+	key := fmt.Sprintf("%s/%s", watch.Namespace, watch.Name)
+	if cached, ok := r.compiled.Load(key); ok && cached.(compiledWatch).generation == watch.Generation {
+		return cached.(compiledWatch), nil
+	}
+
+	compiled, err := compileWatch(watch)
+	if err != nil {
+		return compiledWatch{classifier: severity.NewClassifier()}, err
+	}
+	if watch.Name != "" {
+		r.compiled.Store(key, compiled)
+	}
+	return compiled, nil
+}
+
+func compileWatch(watch *auditv1alpha1.Watch) (compiledWatch, error) {
+	compiled := compiledWatch{generation: watch.Generation}
+
+	var err error
+	if watch.Spec.Condition != "" || len(watch.Spec.Enrichments) > 0 {
+		if compiled.program, err = expression.NewProgram(watch.Spec.Condition, watch.Spec.Enrichments); err != nil {
+			return compiledWatch{}, err
+		}
+	}
+
+	rules := make([]severity.Rule, 0, len(watch.Spec.SeverityRules))
+	for _, rule := range watch.Spec.SeverityRules {
+		compiledRule, err := severity.NewRule(rule.Name, rule.Severity, rule.Condition)
+		if err != nil {
+			return compiledWatch{}, err
+		}
+		rules = append(rules, compiledRule)
+	}
+	compiled.classifier = severity.NewClassifier(rules...)
+
+	if compiled.freezeWindows, err = freeze.NewWindows(watch.Spec.FreezeWindows); err != nil {
+		return compiledWatch{}, err
+	}
+	return compiled, nil
+}
+
+func (r *WatchReconciler) buildEvent(action, kind string, old, obj client.Object, policy *redaction.Policy, related bool) (loghandler.AuditEvent, error) {
+	data := loghandler.NewData(kind)
+	if old == nil {
+		return loghandler.NewAuditEvent(action, obj, data), nil
+	}
+
+	oldContent, err := redactedContent(kind, old, policy)
+	if err != nil {
+		return loghandler.AuditEvent{}, err
+	}
+
+	newContent, err := redactedContent(kind, obj, policy)
+	if err != nil {
+		return loghandler.AuditEvent{}, err
+	}
+
+	if err = utils.RecordChanges(oldContent["spec"], newContent["spec"], ".spec", data); err != nil {
+		return loghandler.AuditEvent{}, err
+	}
+	if related {
+		if err = utils.RecordChanges(relatedMetadata(oldContent), relatedMetadata(newContent), ".metadata", data); err != nil {
+			return loghandler.AuditEvent{}, err
+		}
+	}
+
+	event := loghandler.NewAuditEvent(action, obj, data)
+	event.Patch, err = utils.ComputePatches(&unstructured.Unstructured{Object: oldContent}, &unstructured.Unstructured{Object: newContent}, r.PatchSizeLimit)
+	if err != nil {
+		return loghandler.AuditEvent{}, err
+	}
+
+	return event, nil
+}
+
+// redactionPolicyFor returns the operator wide redaction policy merged with the policy of watch, compiled once per
+// generation. The operator wide policy only if the policy of watch does not compile.
+func (r *WatchReconciler) redactionPolicyFor(watch *auditv1alpha1.Watch) (*redaction.Policy, error) {
+	if watch.Spec.Redaction == nil {
+		return r.Redaction, nil
+	}
+
+	key := fmt.Sprintf("%s/%s", watch.Namespace, watch.Name)
+	if cached, ok := r.policies.Load(key); ok && cached.(watchPolicy).generation == watch.Generation {
+		return cached.(watchPolicy).policy, cached.(watchPolicy).err
+	}
+
+	cached := watchPolicy{generation: watch.Generation, policy: r.Redaction}
+	policy, err := redaction.NewPolicy(watch.Spec.Redaction.Paths, watch.Spec.Redaction.KeyPatterns)
+	if err != nil {
+		cached.err = err
+	} else {
+		cached.policy = r.Redaction.Merge(policy)
+	}
+	if watch.Name != "" {
+		r.policies.Store(key, cached)
+	}
+	return cached.policy, cached.err
+}
+
+// watchPolicy is the redaction policy of a watch at a generation, or the error compiling it.
+type watchPolicy struct {
+	generation int64
+	policy     *redaction.Policy
+	err        error
+}
+
+// watchesFor returns the watches with a selector on kind in namespace.
+func (r *WatchReconciler) watchesFor(ctx context.Context, namespace, kind string) ([]auditv1alpha1.Watch, error) {
+	watchList := &auditv1alpha1.WatchList{}
+	if err := r.List(ctx, watchList); err != nil {
+		return nil, err
+	}
+
+	var watches []auditv1alpha1.Watch
+	for _, watch := range watchList.Items {
+		if watch.Selects(namespace, kind) {
+			watches = append(watches, watch)
+		}
+	}
+	return watches, nil
+}
+
+func redactedContent(kind string, obj client.Object, policy *redaction.Policy) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Redact(kind, content)
+	return content, nil
+}
+
+// actorOf returns the actor of the last write to obj, the operator for a baseline revert.
+func (r *WatchReconciler) actorOf(obj client.Object) string {
+	if rv, ok := r.reverts.Load(obj.GetUID()); ok && rv == obj.GetResourceVersion() {
+		return utils.WatchManFieldManager
+	}
+	return lastManager(obj)
+}
+
+// lastManager returns the field manager of the most recent write to obj other than watchman's own.
+func lastManager(obj client.Object) string {
+	var manager string
+	var last time.Time
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == utils.WatchManFieldManager || entry.Subresource != "" || entry.Time == nil {
+			continue
+		}
+		if !entry.Time.Time.Before(last) {
+			manager, last = entry.Manager, entry.Time.Time
+		}
+	}
+	return manager
+}
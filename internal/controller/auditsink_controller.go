@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/loghandler"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// AuditSinkReconciler reconciles an AuditSink object, running a sink in Fanout for each of them. A sink is replaced
+// whenever its spec or credentials, in a Secret labelled with AuditSinkCredentialsLabel, change. Secrets without the
+// label are never read, so an AuditSink can not send any other Secret of its namespace to its endpoint.
+type AuditSinkReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Fanout *loghandler.Fanout
+
+	// SinksDir is the directory the sinks write their local files under, in a directory per namespace, the paths of
+	// AuditSinks being relative to it. AuditSinks writing local files are rejected if empty
+	SinksDir string
+
+	// Chain, if set, puts every sink behind a hash chain of its own past its filter, e.g loghandler.NewHashChain, so
+	// each sink receives a chain without gaps
+	Chain func(next loghandler.Provider) (loghandler.Provider, error)
+
+	mu      sync.Mutex
+	running map[string]*runningSink
+	ctx     context.Context // parent of the contexts sinks are started with, done once the manager stops
+}
+
+// runningSink is a sink added to the fanout by the reconciler.
+type runningSink struct {
+	// hash of the configuration the sink was created with
+	hash   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// +kubebuilder:rbac:groups=audit.my.domain,resources=auditsinks,verbs=get;list;watch
+// +kubebuilder:rbac:groups=audit.my.domain,resources=auditsinks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=list;watch
+
+func (r *AuditSinkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	name := req.NamespacedName.String()
+
+	auditSink := &auditv1alpha1.AuditSink{}
+	err := r.Get(ctx, req.NamespacedName, auditSink)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("AuditSink resource deleted", "Namespace", req.Namespace, "Name", req.Name)
+		r.stop(name)
+		return ctrl.Result{}, nil
+	} else if err != nil {
+		log.Error(err, "Fails to get resource", "Namespace", req.Namespace, "Name", req.Name)
+		return ctrl.Result{}, err
+	}
+
+	sinkConfig, err := r.sinkConfig(ctx, auditSink)
+	if err != nil {
+		log.Error(err, "Invalid AuditSink", "Namespace", req.Namespace, "Name", req.Name)
+		// Not retried, the AuditSink or its Secret changing triggers a new reconciliation
+		return ctrl.Result{}, r.setReady(ctx, auditSink, metav1.ConditionFalse, "InvalidConfig", err.Error())
+	}
+
+	hash, err := configHash(sinkConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !r.isRunning(name, hash) {
+		sink, err := loghandler.NewSink(sinkConfig, r.Chain)
+		if err != nil {
+			log.Error(err, "Failed to create sink", "Namespace", req.Namespace, "Name", req.Name)
+			if statusErr := r.setReady(ctx, auditSink, metav1.ConditionFalse, "SinkFailed", err.Error()); statusErr != nil {
+				return ctrl.Result{}, statusErr
+			}
+			return ctrl.Result{}, err
+		}
+
+		r.start(name, hash, sink)
+		log.Info("AuditSink started", "Namespace", req.Namespace, "Name", req.Name, "Type", auditSink.Spec.Type)
+	}
+
+	return ctrl.Result{}, r.setReady(ctx, auditSink, metav1.ConditionTrue, "Running", "Sink is receiving events")
+}
+
+// sinkConfig builds the configuration of the sink of auditSink, reading its credentials from their Secret. The sink is
+// confined to the directory of its namespace under SinksDir, and to the files it is given as credentials.
+func (r *AuditSinkReconciler) sinkConfig(ctx context.Context, auditSink *auditv1alpha1.AuditSink) (loghandler.SinkConfig, error) {
+	var raw []byte
+	if auditSink.Spec.Config != nil {
+		raw = auditSink.Spec.Config.Raw
+	}
+
+	name := types.NamespacedName{Namespace: auditSink.Namespace, Name: auditSink.Name}.String()
+	sinkConfig, err := loghandler.NewSinkConfig(name, auditSink.Spec.Type, raw)
+	if err != nil {
+		return loghandler.SinkConfig{}, err
+	}
+
+	settings := loghandler.SinkSettings{Endpoint: auditSink.Spec.Endpoint}
+	if batching := auditSink.Spec.Batching; batching != nil {
+		settings.BatchSize, settings.QueueSize = batching.BatchSize, batching.QueueSize
+		if batching.FlushInterval != nil {
+			settings.FlushInterval = batching.FlushInterval.Duration
+		}
+	}
+
+	if ref := auditSink.Spec.CredentialsSecretRef; ref != nil {
+		// Only the labelled Secrets are cached by the manager, the label is checked for clients caching them all
+		secret := &v1.Secret{}
+		if err = r.Get(ctx, types.NamespacedName{Namespace: auditSink.Namespace, Name: ref.Name}, secret); err != nil {
+			return loghandler.SinkConfig{}, fmt.Errorf("credentials secret %s: %w", ref.Name, err)
+		}
+		if !CredentialsSecrets().Matches(labels.Set(secret.Labels)) {
+			return loghandler.SinkConfig{}, fmt.Errorf("credentials secret %s is not labelled %s: \"true\"", ref.Name, auditv1alpha1.AuditSinkCredentialsLabel)
+		}
+
+		settings.Credentials = map[string]string{}
+		for _, credential := range loghandler.CredentialKeys {
+			key := credential
+			if k, ok := ref.Keys[credential]; ok {
+				key = k
+			}
+			if value, ok := secret.Data[key]; ok {
+				settings.Credentials[credential] = string(value)
+			}
+		}
+	}
+
+	if err = sinkConfig.Apply(settings); err != nil {
+		return loghandler.SinkConfig{}, err
+	}
+
+	var dir string
+	if r.SinksDir != "" {
+		dir = filepath.Join(r.SinksDir, auditSink.Namespace)
+	}
+	if err = sinkConfig.Confine(dir); err != nil {
+		return loghandler.SinkConfig{}, err
+	}
+
+	sinkConfig.Filter = filterConfig(auditSink.Spec.Filter)
+	return sinkConfig, nil
+}
+
+// filterConfig converts filter to its loghandler configuration. Nil if filter is nil.
+func filterConfig(filter *auditv1alpha1.EventFilter) *loghandler.FilterConfig {
+	if filter == nil {
+		return nil
+	}
+	return &loghandler.FilterConfig{
+		Kinds:        filter.Kinds,
+		Actions:      filter.Actions,
+		Namespaces:   filter.Namespaces,
+		ChangedPaths: filter.ChangedPaths,
+		Actors:       filter.Actors,
+		MinSeverity:  filter.MinSeverity,
+	}
+}
+
+func (r *AuditSinkReconciler) setReady(ctx context.Context, auditSink *auditv1alpha1.AuditSink, status metav1.ConditionStatus, reason, message string) error {
+	changed := meta.SetStatusCondition(&auditSink.Status.Conditions, metav1.Condition{
+		Type:               auditv1alpha1.AuditSinkConditionReady,
+		Status:             status,
+		ObservedGeneration: auditSink.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	if !changed {
+		return nil
+	}
+
+	if err := r.Status().Update(ctx, auditSink); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update AuditSink status", "Namespace", auditSink.Namespace, "Name", auditSink.Name)
+		return err
+	}
+	return nil
+}
+
+func (r *AuditSinkReconciler) isRunning(name, hash string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	running, ok := r.running[name]
+	return ok && running.hash == hash
+}
+
+// start adds sink to the fanout under name, starting it if it is a manager.Runnable. The sink it replaces no longer
+// receives events and is stopped right away, flushing the events it holds.
+func (r *AuditSinkReconciler) start(name, hash string, sink loghandler.Provider) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	running := &runningSink{hash: hash, cancel: cancel, done: make(chan struct{})}
+
+	if runnable, ok := sink.(manager.Runnable); ok {
+		go func() {
+			defer close(running.done)
+			if err := runnable.Start(ctx); err != nil {
+				log.FromContext(r.ctx).Error(err, "AuditSink stopped", "Name", name)
+			}
+		}()
+	} else {
+		close(running.done)
+	}
+
+	r.Fanout.SetSink(name, sink)
+
+	r.mu.Lock()
+	previous := r.running[name]
+	r.running[name] = running
+	r.mu.Unlock()
+
+	if previous != nil {
+		previous.cancel()
+	}
+}
+
+// stop removes the sink name from the fanout and stops it.
+func (r *AuditSinkReconciler) stop(name string) {
+	r.Fanout.RemoveSink(name)
+
+	r.mu.Lock()
+	running := r.running[name]
+	delete(r.running, name)
+	r.mu.Unlock()
+
+	if running != nil {
+		running.cancel()
+	}
+}
+
+// stopAll waits for every sink to stop, flushing their events, once ctx is done.
+func (r *AuditSinkReconciler) stopAll(ctx context.Context) error {
+	<-ctx.Done()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, running := range r.running {
+		r.Fanout.RemoveSink(name)
+		running.cancel()
+	}
+	for _, running := range r.running {
+		<-running.done
+	}
+	r.running = map[string]*runningSink{}
+	return nil
+}
+
+// CredentialsSecrets selects the Secrets cached by the manager, those labelled with AuditSinkCredentialsLabel.
+func CredentialsSecrets() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{auditv1alpha1.AuditSinkCredentialsLabel: "true"})
+}
+
+// auditSinksForSecret returns the AuditSinks reading their credentials from secret.
+func (r *AuditSinkReconciler) auditSinksForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	auditSinks := &auditv1alpha1.AuditSinkList{}
+	if err := r.List(ctx, auditSinks, client.InNamespace(secret.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list AuditSinks", "Namespace", secret.GetNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, auditSink := range auditSinks.Items {
+		if ref := auditSink.Spec.CredentialsSecretRef; ref != nil && ref.Name == secret.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: auditSink.Namespace, Name: auditSink.Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager. Sinks are stopped along with the manager.
+func (r *AuditSinkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Fanout == nil {
+		return fmt.Errorf("AuditSink controller requires a fanout")
+	}
+
+	var cancel context.CancelFunc
+	r.ctx, cancel = context.WithCancel(context.Background())
+	r.running = map[string]*runningSink{}
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		defer cancel()
+		return r.stopAll(ctx)
+	})); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&auditv1alpha1.AuditSink{}).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.auditSinksForSecret),
+			builder.WithPredicates(predicate.NewPredicateFuncs(func(secret client.Object) bool {
+				return CredentialsSecrets().Matches(labels.Set(secret.GetLabels()))
+			}))).
+		Named("auditsink").
+		Complete(r)
+}
+
+// configHash returns the hash of cfg, telling when a sink has to be replaced.
+func configHash(cfg loghandler.SinkConfig) (string, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/loghandler"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("AuditSink Controller", Ordered, func() {
+	ctx := context.Background()
+	sinkName := types.NamespacedName{Name: "siem", Namespace: "default"}
+
+	var (
+		server      *httptest.Server
+		mu          sync.Mutex
+		authorized  []string
+		fanout      *loghandler.Fanout
+		r           *AuditSinkReconciler
+		stopSinks   context.CancelFunc
+		readyStatus = func() *metav1.Condition {
+			auditSink := &auditv1alpha1.AuditSink{}
+			Expect(k8sClient.Get(ctx, sinkName, auditSink)).To(Succeed())
+			return meta.FindStatusCondition(auditSink.Status.Conditions, auditv1alpha1.AuditSinkConditionReady)
+		}
+		received = func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, authorized...)
+		}
+	)
+
+	BeforeAll(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			authorized = append(authorized, req.Header.Get("Authorization"))
+			mu.Unlock()
+		}))
+
+		fanout = loghandler.NewFanout(&loghandler.Console{})
+		fanout.Route("default/prod", []loghandler.Route{{Sinks: []string{sinkName.String()}}})
+
+		var sinksCtx context.Context
+		sinksCtx, stopSinks = context.WithCancel(ctx)
+		r = &AuditSinkReconciler{
+			Client:  k8sClient,
+			Scheme:  k8sClient.Scheme(),
+			Fanout:  fanout,
+			ctx:     sinksCtx,
+			running: map[string]*runningSink{},
+		}
+
+		Expect(k8sClient.Create(ctx, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "siem-credentials", Namespace: "default",
+				Labels: map[string]string{auditv1alpha1.AuditSinkCredentialsLabel: "true"},
+			},
+			Data: map[string][]byte{"api-token": []byte("first")},
+		})).To(Succeed())
+	})
+
+	AfterAll(func() {
+		stopSinks()
+		server.Close()
+	})
+
+	It("Should start the sink with the credentials of its Secret", func() {
+		Expect(k8sClient.Create(ctx, &auditv1alpha1.AuditSink{
+			ObjectMeta: metav1.ObjectMeta{Name: sinkName.Name, Namespace: sinkName.Namespace},
+			Spec: auditv1alpha1.AuditSinkSpec{
+				Type:                 loghandler.SinkTypeHTTP,
+				Endpoint:             server.URL,
+				CredentialsSecretRef: &auditv1alpha1.SecretKeyRef{Name: "siem-credentials", Keys: map[string]string{"token": "api-token"}},
+				Batching:             &auditv1alpha1.AuditSinkBatching{BatchSize: 1},
+				Config:               &runtime.RawExtension{Raw: []byte(`{"maxRetries": 0}`)},
+			},
+		})).To(Succeed())
+
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: sinkName})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readyStatus().Status).To(Equal(metav1.ConditionTrue))
+
+		fanout.Log(loghandler.AuditEvent{Kind: "Deployment", Name: "app-1", Namespace: "default", Action: "Update", Watch: "default/prod"})
+		Eventually(received, timeout, interval).Should(Equal([]string{"Bearer first"}))
+	})
+
+	It("Should replace the sink once its Secret changes", func() {
+		secret := &v1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "siem-credentials", Namespace: "default"}, secret)).To(Succeed())
+		secret.Data["api-token"] = []byte("second")
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+		Expect(r.auditSinksForSecret(ctx, secret)).To(ConsistOf(reconcile.Request{NamespacedName: sinkName}))
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: sinkName})
+		Expect(err).NotTo(HaveOccurred())
+
+		fanout.Log(loghandler.AuditEvent{Kind: "Deployment", Name: "app-1", Namespace: "default", Action: "Delete", Watch: "default/prod"})
+		Eventually(received, timeout, interval).Should(Equal([]string{"Bearer first", "Bearer second"}))
+	})
+
+	It("Should report an invalid configuration", func() {
+		auditSink := &auditv1alpha1.AuditSink{}
+		Expect(k8sClient.Get(ctx, sinkName, auditSink)).To(Succeed())
+		auditSink.Spec.Config = &runtime.RawExtension{Raw: []byte(`{"retries": 0}`)}
+		Expect(k8sClient.Update(ctx, auditSink)).To(Succeed())
+
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: sinkName})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readyStatus().Status).To(Equal(metav1.ConditionFalse))
+		Expect(readyStatus().Reason).To(Equal("InvalidConfig"))
+	})
+
+	It("Should reject local files outside the sinks directory", func() {
+		for config, message := range map[string]string{
+			`{"deadLetterPath": "/etc/watchman/dead-letter.ndjson"}`:                      "deadLetterPath is not allowed",
+			`{"tls": {"caFile": "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"}}`: "tls files are not allowed",
+		} {
+			auditSink := &auditv1alpha1.AuditSink{}
+			Expect(k8sClient.Get(ctx, sinkName, auditSink)).To(Succeed())
+			auditSink.Spec.Config = &runtime.RawExtension{Raw: []byte(config)}
+			Expect(k8sClient.Update(ctx, auditSink)).To(Succeed())
+
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: sinkName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readyStatus().Status).To(Equal(metav1.ConditionFalse))
+			Expect(readyStatus().Reason).To(Equal("InvalidConfig"))
+			Expect(readyStatus().Message).To(ContainSubstring(message))
+		}
+	})
+
+	It("Should not read Secrets without the credentials label", func() {
+		Expect(k8sClient.Create(ctx, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cloud-credentials", Namespace: "default"},
+			Data:       map[string][]byte{"token": []byte("cloud")},
+		})).To(Succeed())
+
+		auditSink := &auditv1alpha1.AuditSink{}
+		Expect(k8sClient.Get(ctx, sinkName, auditSink)).To(Succeed())
+		auditSink.Spec.Config = nil
+		auditSink.Spec.CredentialsSecretRef = &auditv1alpha1.SecretKeyRef{Name: "cloud-credentials"}
+		Expect(k8sClient.Update(ctx, auditSink)).To(Succeed())
+
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: sinkName})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readyStatus().Status).To(Equal(metav1.ConditionFalse))
+		Expect(readyStatus().Message).To(ContainSubstring("is not labelled " + auditv1alpha1.AuditSinkCredentialsLabel))
+	})
+
+	It("Should remove the sink once deleted", func() {
+		Expect(k8sClient.Delete(ctx, &auditv1alpha1.AuditSink{
+			ObjectMeta: metav1.ObjectMeta{Name: sinkName.Name, Namespace: sinkName.Namespace},
+		})).To(Succeed())
+
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: sinkName})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.running).NotTo(HaveKey(sinkName.String()))
+		Expect(fanout.RemoveSink(sinkName.String())).To(BeNil())
+	})
+})
@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/baseline"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/redaction"
+	"github.com/vandathron/watchman/internal/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+// baselineFieldsAnnotation records the fields the snapshots of a baseline ConfigMap were taken of, so they are taken
+// again once the fields change.
+const baselineFieldsAnnotation = "audit.my.domain/baseline-fields"
+
+// defaultManifestPaths are compared when a baseline of manifests pins no fields.
+var defaultManifestPaths = []string{".spec"}
+
+// baselineConfigMapName returns the name of the ConfigMap holding the snapshots of the baseline of watch.
+func baselineConfigMapName(watch *auditv1alpha1.Watch) string {
+	return watch.Name + "-baseline"
+}
+
+// reconcileBaseline checks every resource selected by watch against its baseline, or clears the drift status of
+// watch if it has none.
+func (r *WatchReconciler) reconcileBaseline(ctx context.Context, watch *auditv1alpha1.Watch) error {
+	if watch.Spec.Baseline == nil {
+		return r.clearDrift(ctx, watch)
+	}
+
+	for _, selector := range watch.Spec.Selectors {
+		for _, kind := range selector.Kinds {
+			objects, err := r.listKind(ctx, selector.Namespace, kind)
+			if err != nil {
+				return err
+			}
+			for _, obj := range objects {
+				if err = r.checkDrift(ctx, watch, kind, obj); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err := r.setDrifted(ctx, watch, "", false)
+	return err
+}
+
+// checkBaseline checks obj against the baseline of watch once action is performed on it.
+func (r *WatchReconciler) checkBaseline(ctx context.Context, watch *auditv1alpha1.Watch, action, kind string, obj client.Object) {
+	if watch.Name == "" || watch.Spec.Baseline == nil {
+		return
+	}
+
+	var err error
+	if action == utils.WatchActionTypeDelete {
+		_, err = r.setDrifted(ctx, watch, driftID(kind, obj), false)
+	} else {
+		err = r.checkDrift(ctx, watch, kind, obj)
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to check baseline", "Watch", watch.Name, "Namespace", watch.Namespace, "Name", obj.GetName())
+	}
+}
+
+// checkDrift compares obj to the baseline of watch, emitting an event whenever it drifts or converges and reverting
+// its drift in Revert mode.
+func (r *WatchReconciler) checkDrift(ctx context.Context, watch *auditv1alpha1.Watch, kind string, obj client.Object) error {
+	// Baselines hold redacted values only, hashes being stable drift is still detected
+	policy, _ := r.redactionPolicyFor(watch)
+	content, err := redactedContent(kind, obj, policy)
+	if err != nil {
+		return err
+	}
+	live := baseline.Flatten(content)
+
+	spec := watch.Spec.Baseline
+	var desired baseline.State
+	paths, strict := spec.Fields, true
+	if spec.ConfigMapName != "" {
+		manifest, err := r.manifestFor(ctx, watch, kind, obj, policy)
+		if err != nil || manifest == nil {
+			return err
+		}
+		if len(paths) == 0 {
+			paths = defaultManifestPaths
+		}
+		desired, strict = baseline.Flatten(manifest).Select(paths), false
+	} else if desired, err = r.snapshotFor(ctx, watch, kind, obj, live); err != nil {
+		return err
+	}
+
+	drifts := baseline.Diff(desired, live, strict, paths)
+	if spec.ConfigMapName == "" && !policy.Keyed() {
+		// Without a --redaction-key, snapshots taken before a restart hold hashes of another key. Redacted fields are
+		// only compared with a stable key, their removal or addition still being a drift
+		drifts = slices.DeleteFunc(drifts, func(drift baseline.Drift) bool {
+			return drift.InDesired && drift.InLive && drift.Redacted()
+		})
+	}
+	drifted := len(drifts) > 0
+	changed, err := r.setDrifted(ctx, watch, driftID(kind, obj), drifted)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case drifted && changed:
+		r.emitBaselineEvent(ctx, watch, kind, obj, baseline.ActionDrift, drifts, false)
+	case !drifted && changed:
+		r.emitBaselineEvent(ctx, watch, kind, obj, baseline.ActionConverge, nil, false)
+	}
+
+	if drifted && spec.Mode == auditv1alpha1.BaselineModeRevert {
+		return r.revert(ctx, watch, kind, obj, desired, drifts, paths)
+	}
+	return nil
+}
+
+// revert patches the drifted fields of obj back to desired. The update it causes is audited as the operator's.
+func (r *WatchReconciler) revert(ctx context.Context, watch *auditv1alpha1.Watch, kind string, obj client.Object, desired baseline.State, drifts []baseline.Drift, paths []string) error {
+	log := log.FromContext(ctx)
+
+	patch, skipped, err := baseline.RevertPatch(desired, drifts, paths)
+	if err != nil {
+		return err
+	}
+	for _, drift := range skipped {
+		log.Info("Redacted field not reverted", "Watch", watch.Name, "Name", obj.GetName(), "Namespace", obj.GetNamespace(), "Path", drift.Path())
+		drifts = slices.DeleteFunc(drifts, func(d baseline.Drift) bool { return d.Pointer == drift.Pointer })
+	}
+	if patch == nil {
+		return nil
+	}
+
+	if err = r.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch), client.FieldOwner(utils.WatchManFieldManager)); err != nil {
+		log.Error(err, "Failed to revert drift", "Watch", watch.Name, "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+		return err
+	}
+	r.reverts.Store(obj.GetUID(), obj.GetResourceVersion())
+
+	r.emitBaselineEvent(ctx, watch, kind, obj, baseline.ActionRevert, drifts, true)
+	return nil
+}
+
+// emitBaselineEvent emits a baseline event for obj, with changes from the desired to the live values of drifts or,
+// once reverted, back.
+func (r *WatchReconciler) emitBaselineEvent(ctx context.Context, watch *auditv1alpha1.Watch, kind string, obj client.Object, action string, drifts []baseline.Drift, reverted bool) {
+	data := loghandler.NewData(kind)
+	for _, drift := range drifts {
+		from, to := drift.Desired, drift.Live
+		if reverted {
+			from, to = to, from
+		}
+		if err := data.AddChange(drift.Path(), from, to); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to record drift", "Path", drift.Path())
+		}
+	}
+
+	event := loghandler.NewAuditEvent(action, obj, data)
+	event.Watch = fmt.Sprintf("%s/%s", watch.Namespace, watch.Name)
+	switch action {
+	case baseline.ActionDrift:
+		event.Severity = loghandler.SeverityHigh
+	case baseline.ActionRevert:
+		event.Severity = loghandler.SeverityMedium
+	default:
+		event.Severity = loghandler.SeverityInfo
+	}
+	r.emit(ctx, watch, event)
+}
+
+// snapshotFor returns the snapshot of obj in the baseline of watch, taking it from live if there is none. Snapshots
+// are only kept in a ConfigMap controlled by watch.
+func (r *WatchReconciler) snapshotFor(ctx context.Context, watch *auditv1alpha1.Watch, kind string, obj client.Object, live baseline.State) (baseline.State, error) {
+	fields, err := json.Marshal(watch.Spec.Baseline.Fields)
+	if err != nil {
+		return nil, err
+	}
+	key := snapshotKey(kind, obj)
+
+	var snapshot baseline.State
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm := &v1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: watch.Namespace, Name: baselineConfigMapName(watch)}, cm)
+		if errors.IsNotFound(err) {
+			cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: baselineConfigMapName(watch), Namespace: watch.Namespace}}
+			if err = ctrl.SetControllerReference(watch, cm, r.Scheme); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !metav1.IsControlledBy(cm, watch) { // never overwrite a ConfigMap of the user or of another watch
+			return fmt.Errorf("ConfigMap %s/%s is not owned by the watch, its baseline snapshots are not taken", cm.Namespace, cm.Name)
+		}
+
+		if cm.Annotations[baselineFieldsAnnotation] != string(fields) { // fields changed, snapshots are taken again
+			cm.Data = nil
+		}
+		if raw, ok := cm.Data[key]; ok {
+			return json.Unmarshal([]byte(raw), &snapshot)
+		}
+
+		snapshot = live.Select(watch.Spec.Baseline.Fields)
+		raw, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(raw)
+		metav1.SetMetaDataAnnotation(&cm.ObjectMeta, baselineFieldsAnnotation, string(fields))
+
+		if cm.ResourceVersion == "" {
+			return r.Create(ctx, cm)
+		}
+		return r.Update(ctx, cm)
+	})
+	return snapshot, err
+}
+
+// manifestFor returns the redacted manifest of obj in the baseline ConfigMap of watch, nil if there is none.
+func (r *WatchReconciler) manifestFor(ctx context.Context, watch *auditv1alpha1.Watch, kind string, obj client.Object, policy *redaction.Policy) (map[string]interface{}, error) {
+	cm := &v1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: watch.Namespace, Name: watch.Spec.Baseline.ConfigMapName}, cm); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(cm.Data))
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		for _, doc := range bytes.Split([]byte(cm.Data[key]), []byte("\n---")) {
+			var manifest map[string]interface{}
+			if err := yaml.Unmarshal(doc, &manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest in key %s of ConfigMap %s: %w", key, cm.Name, err)
+			}
+			metadata, _ := manifest["metadata"].(map[string]interface{})
+			namespace, _ := metadata["namespace"].(string)
+			if manifest["kind"] == kind && metadata["name"] == obj.GetName() && (namespace == "" || namespace == obj.GetNamespace()) {
+				policy.Redact(kind, manifest)
+				return manifest, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// setDrifted records whether the resource id drifted in the status of watch, updating its Drifted condition, and
+// reports whether it changed. An empty id only makes sure the condition is set.
+func (r *WatchReconciler) setDrifted(ctx context.Context, watch *auditv1alpha1.Watch, id string, drifted bool) (bool, error) {
+	var changed bool
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &auditv1alpha1.Watch{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: watch.Namespace, Name: watch.Name}, latest); err != nil {
+			return err
+		}
+		changed = id != "" && drifted != slices.Contains(latest.Status.DriftedResources, id)
+
+		resources := slices.DeleteFunc(slices.Clone(latest.Status.DriftedResources), func(resource string) bool { return resource == id })
+		if drifted {
+			resources = append(resources, id)
+			slices.Sort(resources)
+		}
+
+		condition := metav1.Condition{
+			Type:               auditv1alpha1.WatchConditionDrifted,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: latest.Generation,
+			Reason:             "InSync",
+			Message:            "Resources match the baseline",
+		}
+		if len(resources) > 0 {
+			condition.Status, condition.Reason = metav1.ConditionTrue, "Drifted"
+			condition.Message = fmt.Sprintf("%d resource(s) drifted from the baseline: %s", len(resources), strings.Join(resources, ", "))
+		}
+
+		if !meta.SetStatusCondition(&latest.Status.Conditions, condition) && !changed {
+			return nil
+		}
+		latest.Status.DriftedResources = resources
+		return r.Status().Update(ctx, latest)
+	})
+	return changed, err
+}
+
+// clearDrift removes the drift status of watch once its baseline is removed.
+func (r *WatchReconciler) clearDrift(ctx context.Context, watch *auditv1alpha1.Watch) error {
+	if len(watch.Status.DriftedResources) == 0 && meta.FindStatusCondition(watch.Status.Conditions, auditv1alpha1.WatchConditionDrifted) == nil {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &auditv1alpha1.Watch{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: watch.Namespace, Name: watch.Name}, latest); err != nil {
+			return err
+		}
+		latest.Status.DriftedResources = nil
+		meta.RemoveStatusCondition(&latest.Status.Conditions, auditv1alpha1.WatchConditionDrifted)
+		return r.Status().Update(ctx, latest)
+	})
+}
+
+// listKind returns the resources of kind in namespace.
+func (r *WatchReconciler) listKind(ctx context.Context, namespace, kind string) ([]client.Object, error) {
+	var objects []client.Object
+	switch kind {
+	case utils.SupportedKindDeployment:
+		deployments := &appsv1.DeploymentList{}
+		if err := r.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		for i := range deployments.Items {
+			objects = append(objects, &deployments.Items[i])
+		}
+	case utils.SupportedKindService:
+		services := &v1.ServiceList{}
+		if err := r.List(ctx, services, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		for i := range services.Items {
+			objects = append(objects, &services.Items[i])
+		}
+	}
+	return objects, nil
+}
+
+// watchesForConfigMap returns the watches whose baseline is held by cm.
+func (r *WatchReconciler) watchesForConfigMap(ctx context.Context, cm client.Object) []reconcile.Request {
+	watches := &auditv1alpha1.WatchList{}
+	if err := r.List(ctx, watches, client.InNamespace(cm.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list watches", "Namespace", cm.GetNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, watch := range watches.Items {
+		if b := watch.Spec.Baseline; b != nil && (b.ConfigMapName == cm.GetName() || baselineConfigMapName(&watch) == cm.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: watch.Namespace, Name: watch.Name}})
+		}
+	}
+	return requests
+}
+
+// driftID identifies obj in the drifted resources of a watch. e.g (Deployment/default/app)
+func driftID(kind string, obj client.Object) string {
+	return fmt.Sprintf("%s/%s/%s", kind, obj.GetNamespace(), obj.GetName())
+}
+
+// snapshotKey is the key of the snapshot of obj in a baseline ConfigMap. e.g (deployment.default.app)
+func snapshotKey(kind string, obj client.Object) string {
+	return fmt.Sprintf("%s.%s.%s", strings.ToLower(kind), obj.GetNamespace(), obj.GetName())
+}
@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/baseline"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// recordingProvider keeps the events logged.
+type recordingProvider struct {
+	mu     sync.Mutex
+	events []loghandler.AuditEvent
+}
+
+func (p *recordingProvider) Log(event loghandler.AuditEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+}
+
+func (p *recordingProvider) Actions() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	actions := make([]string, 0, len(p.events))
+	for _, event := range p.events {
+		actions = append(actions, event.Action)
+	}
+	return actions
+}
+
+func (p *recordingProvider) Events() []loghandler.AuditEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]loghandler.AuditEvent(nil), p.events...)
+}
+
+var _ = Describe("Baseline", Ordered, func() {
+	ns := "ns-baseline"
+	var r *WatchReconciler
+	var audit *recordingProvider
+
+	BeforeAll(func() {
+		testCreateNamespaces(makeNamespace(ns))
+	})
+
+	BeforeEach(func() {
+		audit = &recordingProvider{}
+		r = &WatchReconciler{
+			Client: k8sClient,
+			Scheme: k8sClient.Scheme(),
+			Audit:  audit,
+		}
+	})
+
+	createWatch := func(name string, spec *auditv1alpha1.Baseline) *auditv1alpha1.Watch {
+		watch := &auditv1alpha1.Watch{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Spec: auditv1alpha1.WatchSpec{
+				Selectors: []auditv1alpha1.WatchSelector{{Namespace: ns, Kinds: []string{utils.SupportedKindDeployment}}},
+				Baseline:  spec,
+			},
+		}
+		Expect(k8sClient.Create(ctx, watch)).To(Succeed())
+		return watch
+	}
+
+	scale := func(deployment *appsv1.Deployment, replicas int32) {
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+		deployment.Spec.Replicas = ptr.To(replicas)
+		Expect(k8sClient.Update(ctx, deployment)).To(Succeed())
+	}
+
+	driftedResources := func(watch *auditv1alpha1.Watch) []string {
+		latest := &auditv1alpha1.Watch{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(watch), latest)).To(Succeed())
+		return latest.Status.DriftedResources
+	}
+
+	It("Should take a snapshot once and again when the pinned fields change", func() {
+		watch := createWatch("snapshot", &auditv1alpha1.Baseline{Fields: []string{".spec.replicas"}, Mode: auditv1alpha1.BaselineModeDetect})
+		deployment := makeDeploymentSpec("snapshot", ns)
+		testCreateDeployments(deployment)
+
+		snapshot, err := r.snapshotFor(ctx, watch, utils.SupportedKindDeployment, deployment, baseline.State{"/spec/replicas": int64(1)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot).To(HaveKeyWithValue("/spec/replicas", BeEquivalentTo(1)))
+
+		cm := &v1.ConfigMap{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: ns, Name: "snapshot-baseline"}, cm)).To(Succeed())
+		Expect(cm.Data).To(HaveKey("deployment.ns-baseline.snapshot"))
+		Expect(cm.Annotations).To(HaveKeyWithValue(baselineFieldsAnnotation, `[".spec.replicas"]`))
+		Expect(metav1.IsControlledBy(cm, watch)).To(BeTrue())
+
+		By("Keeping the snapshot taken")
+		snapshot, err = r.snapshotFor(ctx, watch, utils.SupportedKindDeployment, deployment, baseline.State{"/spec/replicas": int64(3)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot).To(HaveKeyWithValue("/spec/replicas", BeEquivalentTo(1)))
+
+		By("Taking it again once the fields change")
+		watch.Spec.Baseline.Fields = []string{".spec.replicas", ".spec.paused"}
+		snapshot, err = r.snapshotFor(ctx, watch, utils.SupportedKindDeployment, deployment, baseline.State{"/spec/replicas": int64(3)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshot).To(HaveKeyWithValue("/spec/replicas", BeEquivalentTo(3)))
+	})
+
+	It("Should emit Drift and Converge once as a resource drifts from its snapshot and back", func() {
+		watch := createWatch("detect", &auditv1alpha1.Baseline{Fields: []string{".spec.replicas"}, Mode: auditv1alpha1.BaselineModeDetect})
+		deployment := makeDeploymentSpec("detect", ns)
+		testCreateDeployments(deployment)
+
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		Expect(audit.Actions()).To(BeEmpty())
+
+		scale(deployment, 3)
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		Expect(audit.Actions()).To(Equal([]string{baseline.ActionDrift}))
+		Expect(driftedResources(watch)).To(Equal([]string{"Deployment/ns-baseline/detect"}))
+
+		drift := audit.Events()[0]
+		Expect(drift.Watch).To(Equal("ns-baseline/detect"))
+		Expect(drift.Severity).To(Equal(loghandler.SeverityHigh))
+		Expect(drift.Changes).To(HaveLen(1))
+		Expect(drift.Changes[0].Path()).To(Equal(".spec.replicas"))
+		Expect(string(drift.Changes[0].OldValue())).To(Equal("1"))
+		Expect(string(drift.Changes[0].NewValue())).To(Equal("3"))
+
+		scale(deployment, 1)
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		Expect(audit.Actions()).To(Equal([]string{baseline.ActionDrift, baseline.ActionConverge}))
+		Expect(driftedResources(watch)).To(BeEmpty())
+	})
+
+	It("Should give distinct IDs to the events of baseline edits on the same resourceVersion", func() {
+		manifest := func(replicas int) map[string]string {
+			return map[string]string{"app.yaml": fmt.Sprintf("kind: Deployment\nmetadata:\n  name: manifest\nspec:\n  replicas: %d\n", replicas)}
+		}
+		cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "manifests", Namespace: ns}, Data: manifest(2)}
+		Expect(k8sClient.Create(ctx, cm)).To(Succeed())
+		watch := createWatch("manifest", &auditv1alpha1.Baseline{ConfigMapName: "manifests", Fields: []string{".spec.replicas"}, Mode: auditv1alpha1.BaselineModeDetect})
+		deployment := makeDeploymentSpec("manifest", ns)
+		testCreateDeployments(deployment)
+
+		for _, replicas := range []int{2, 1, 2} {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), cm)).To(Succeed())
+			cm.Data = manifest(replicas)
+			Expect(k8sClient.Update(ctx, cm)).To(Succeed())
+			Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		}
+
+		Expect(audit.Actions()).To(Equal([]string{baseline.ActionDrift, baseline.ActionConverge, baseline.ActionDrift}))
+		events := audit.Events()
+		Expect(events[0].ResourceVersion).To(Equal(events[2].ResourceVersion))
+		Expect(events[0].ID()).NotTo(Equal(events[2].ID()))
+	})
+
+	It("Should revert drifted fields in Revert mode", func() {
+		watch := createWatch("revert", &auditv1alpha1.Baseline{Fields: []string{".spec.replicas"}, Mode: auditv1alpha1.BaselineModeRevert})
+		deployment := makeDeploymentSpec("revert", ns)
+		testCreateDeployments(deployment)
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+
+		scale(deployment, 3)
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		Expect(audit.Actions()).To(Equal([]string{baseline.ActionDrift, baseline.ActionRevert}))
+
+		reverted := audit.Events()[1]
+		Expect(reverted.Severity).To(Equal(loghandler.SeverityMedium))
+		Expect(reverted.Changes).To(HaveLen(1))
+		Expect(string(reverted.Changes[0].OldValue())).To(Equal("3"))
+		Expect(string(reverted.Changes[0].NewValue())).To(Equal("1"))
+
+		latest := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), latest)).To(Succeed())
+		Expect(latest.Spec.Replicas).To(Equal(ptr.To[int32](1)))
+
+		By("Converging once the reverted resource is checked again")
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, latest)).To(Succeed())
+		Expect(audit.Actions()).To(Equal([]string{baseline.ActionDrift, baseline.ActionRevert, baseline.ActionConverge}))
+	})
+
+	It("Should audit the update of a revert as the operator's", func() {
+		watch := createWatch("revert-actor", &auditv1alpha1.Baseline{Fields: []string{".spec.replicas"}, Mode: auditv1alpha1.BaselineModeRevert})
+		deployment := makeDeploymentSpec("revert-actor", ns)
+		testCreateDeployments(deployment)
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+
+		scale(deployment, 3)
+		scaled := deployment.DeepCopy()
+		Expect(r.checkDrift(ctx, watch, utils.SupportedKindDeployment, deployment)).To(Succeed())
+		Expect(audit.Actions()).To(Equal([]string{baseline.ActionDrift, baseline.ActionRevert}))
+
+		reverted := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), reverted)).To(Succeed())
+		Expect(lastManager(reverted)).NotTo(BeEmpty())
+		events := r.audit(ctx, utils.WatchActionTypeUpdate, utils.SupportedKindDeployment, scaled, reverted)
+		Expect(events).NotTo(BeEmpty())
+		for _, event := range events {
+			Expect(event.Actor).To(Equal(utils.WatchManFieldManager))
+		}
+
+		By("Crediting the following update to its author again")
+		scale(reverted, 2)
+		events = r.audit(ctx, utils.WatchActionTypeUpdate, utils.SupportedKindDeployment, scaled, reverted)
+		Expect(events).NotTo(BeEmpty())
+		for _, event := range events {
+			Expect(event.Actor).To(Equal(lastManager(reverted)))
+		}
+	})
+
+	It("Should not take snapshots in a ConfigMap the watch does not own", func() {
+		cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "taken-baseline", Namespace: ns}, Data: map[string]string{"app.yaml": "kind: Deployment"}}
+		Expect(k8sClient.Create(ctx, cm)).To(Succeed())
+		watch := createWatch("taken", &auditv1alpha1.Baseline{Fields: []string{".spec.replicas"}, Mode: auditv1alpha1.BaselineModeDetect})
+		deployment := makeDeploymentSpec("taken", ns)
+		testCreateDeployments(deployment)
+
+		_, err := r.snapshotFor(ctx, watch, utils.SupportedKindDeployment, deployment, baseline.State{"/spec/replicas": int64(1)})
+		Expect(err).To(MatchError(ContainSubstring("not owned by the watch")))
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), cm)).To(Succeed())
+		Expect(cm.Data).To(Equal(map[string]string{"app.yaml": "kind: Deployment"}))
+	})
+})
@@ -3,7 +3,6 @@ package controller
 import (
 	"context"
 	"fmt"
-	"github.com/vandathron/watchman/internal/loghandler"
 	"github.com/vandathron/watchman/internal/utils"
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -12,7 +11,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
-	"strconv"
 )
 
 var (
@@ -34,12 +32,9 @@ func (r *WatchReconciler) handleDeployment(ctx context.Context, object client.Ob
 		return nil
 	}
 
-	data := &loghandler.Data{}
-	data.AddField("Kind", "Deployment")
-
 	switch action {
 	case utils.WatchActionTypeCreate:
-		r.Audit.Log("deployment", utils.WatchActionTypeCreate, deployment.Namespace, *data)
+		r.audit(ctx, utils.WatchActionTypeCreate, utils.SupportedKindDeployment, nil, deployment)
 
 	case utils.WatchActionTypeUpdate:
 		if utils.HasWatchManAnnotation(deployment.Annotations, utils.WatchUpdateStateKey, utils.WatchUpdateStateOld) {
@@ -56,15 +51,16 @@ func (r *WatchReconciler) handleDeployment(ctx context.Context, object client.Ob
 				return nil
 			}
 			delete(oldDeployments, oldDeployKey)
-			r.recordDeploymentDiff(ctx, oldDeployment, deployment, data)
-			r.Audit.Log(deployment.Name, utils.WatchActionTypeUpdate, deployment.Namespace, *data)
+			events := r.audit(ctx, utils.WatchActionTypeUpdate, utils.SupportedKindDeployment, oldDeployment, deployment)
+			r.startRollout(oldDeployment, deployment, events)
 		} else {
 			log.Error(fmt.Errorf("annotation not found"), fmt.Sprintf("%s not found", utils.WatchUpdateStateKey))
 			return nil
 		}
 
 	case utils.WatchActionTypeDelete:
-		r.Audit.Log(deployment.Name, utils.WatchActionTypeDelete, deployment.Namespace, *data)
+		r.rollouts.Delete(fmt.Sprintf("%s/%s", deployment.Namespace, deployment.Name))
+		r.audit(ctx, utils.WatchActionTypeDelete, utils.SupportedKindDeployment, nil, deployment)
 	default:
 		log.Error(fmt.Errorf("invalid action type"), "Unsupported action type", "Type", action)
 	}
@@ -133,36 +129,6 @@ func (r *WatchReconciler) unWatchDeployment(ctx context.Context, deployment *app
 	}
 }
 
-func (r *WatchReconciler) recordDeploymentDiff(ctx context.Context, old, new *appsv1.Deployment, data *loghandler.Data) {
-	// Compare replicas
-	log := log.FromContext(ctx)
-	if old.Spec.Replicas != new.Spec.Replicas {
-		data.AddField("replica", strconv.Itoa(int(*new.Spec.Replicas)))
-	}
-	if old.Spec.Paused != new.Spec.Paused {
-		data.AddField("Paused", fmt.Sprintf("%v", new.Spec.Replicas))
-	}
-	if old.Spec.MinReadySeconds != new.Spec.MinReadySeconds {
-		data.AddField("MinReadySeconds", strconv.Itoa(int(new.Spec.MinReadySeconds)))
-	}
-	if old.Spec.ProgressDeadlineSeconds != new.Spec.ProgressDeadlineSeconds {
-		data.AddField("progressDeadlineSeconds", strconv.Itoa(int(*new.Spec.ProgressDeadlineSeconds)))
-	}
-	if old.Spec.RevisionHistoryLimit != new.Spec.RevisionHistoryLimit {
-		data.AddField("revisionHistoryLimit", strconv.Itoa(int(*new.Spec.RevisionHistoryLimit)))
-	}
-
-	if err := utils.RecordChanges(*old.Spec.Selector, *new.Spec.Selector, ".spec.selector.", data); err != nil {
-		log.Error(err, "record change error")
-	}
-	if err := utils.RecordChanges(old.Spec.Template, new.Spec.Template, "spec.template.", data); err != nil {
-		log.Error(err, "record change error")
-	}
-	if err := utils.RecordChanges(old.Spec.Template.Spec, new.Spec.Template.Spec, "spec.template.spec.", data); err != nil {
-		log.Error(err, "record change error")
-	}
-}
-
 func (r *WatchReconciler) filterDeployments(e event.TypedUpdateEvent[client.Object]) bool {
 	if !utils.HasWatchManAnnotation(e.ObjectOld.GetAnnotations(), utils.WatchByAnnotationKey, utils.WatchByAnnotationKV) {
 		return false
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/vandathron/watchman/internal/exposure"
+	"github.com/vandathron/watchman/internal/expression"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/utils"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+)
+
+// describeService sets the fields of the event of a Service update describing what it exposes. Ports, type and
+// external addresses are compared on the redacted objects of in, the pods newly selected and deselected are counted
+// on the live selectors.
+func (r *WatchReconciler) describeService(ctx context.Context, event *loghandler.AuditEvent, in expression.Input, old, obj client.Object) {
+	if in.Old == nil || in.New == nil {
+		return
+	}
+	for name, value := range exposure.Diff(in.Old, in.New) {
+		event.SetField(name, value)
+	}
+
+	oldSvc, ok := old.(*v1.Service)
+	if !ok {
+		return
+	}
+	svc := obj.(*v1.Service)
+	if maps.Equal(oldSvc.Spec.Selector, svc.Spec.Selector) {
+		return
+	}
+
+	pods := &v1.PodList{}
+	if err := r.apiReader().List(ctx, pods, client.InNamespace(svc.Namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list pods", "Namespace", svc.Namespace)
+		return
+	}
+	selected, deselected := exposure.Reselected(oldSvc.Spec.Selector, svc.Spec.Selector, pods.Items)
+	event.SetField(exposure.FieldPodsSelected, strconv.Itoa(selected))
+	event.SetField(exposure.FieldPodsDeselected, strconv.Itoa(deselected))
+}
+
+// apiReader returns the reader of the objects not worth caching.
+func (r *WatchReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// handleEndpointSlice counts the ready endpoints of the watched Service of an EndpointSlice, emitting
+// EndpointsDrained once it has none left and EndpointsRestored once it has some again.
+func (r *WatchReconciler) handleEndpointSlice(ctx context.Context, object client.Object) []reconcile.Request {
+	log := log.FromContext(ctx)
+
+	name := object.GetLabels()[discoveryv1.LabelServiceName]
+	if name == "" {
+		return nil
+	}
+
+	// Services deleted or no longer watched are forgotten, their slices going away after them
+	key := fmt.Sprintf("%s/%s", object.GetNamespace(), name)
+	svc := &v1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: object.GetNamespace(), Name: name}, svc); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to get service", "Name", name, "Namespace", object.GetNamespace())
+		} else {
+			r.endpoints.Delete(key)
+		}
+		return nil
+	}
+	if !utils.HasWatchManAnnotation(svc.Annotations, utils.WatchByAnnotationKey, utils.WatchByAnnotationKV) {
+		r.endpoints.Delete(key)
+		return nil
+	}
+
+	endpointSlices := &discoveryv1.EndpointSliceList{}
+	if err := r.List(ctx, endpointSlices, client.InNamespace(svc.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: name}); err != nil {
+		log.Error(err, "Failed to list endpoint slices", "Name", name, "Namespace", svc.Namespace)
+		return nil
+	}
+
+	ready := exposure.ReadyEndpoints(endpointSlices.Items)
+	previous, seen := r.endpoints.Swap(key, ready)
+	if !seen {
+		return nil
+	}
+
+	var action string
+	switch {
+	case ready == 0 && previous.(int) > 0:
+		action = exposure.ActionEndpointsDrained
+	case ready > 0 && previous.(int) == 0:
+		action = exposure.ActionEndpointsRestored
+	default:
+		return nil
+	}
+	r.emitEndpointsEvent(ctx, svc, action, previous.(int), ready)
+	return nil
+}
+
+// emitEndpointsEvent emits action for every watch selecting svc.
+func (r *WatchReconciler) emitEndpointsEvent(ctx context.Context, svc *v1.Service, action string, previous, ready int) {
+	watches, err := r.watchesFor(ctx, svc.Namespace, utils.SupportedKindService)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list watches", "Namespace", svc.Namespace, "Kind", utils.SupportedKindService)
+	}
+	if len(watches) == 0 {
+		watches = append(watches, auditv1alpha1.Watch{})
+	}
+
+	for _, watch := range watches {
+		event := loghandler.NewAuditEvent(action, svc, loghandler.NewData(utils.SupportedKindService))
+		if watch.Name != "" {
+			event.Watch = fmt.Sprintf("%s/%s", watch.Namespace, watch.Name)
+		}
+		event.SetField(exposure.FieldReadyEndpoints, strconv.Itoa(ready))
+		event.SetField(exposure.FieldPreviousReadyEndpoints, strconv.Itoa(previous))
+		event.Severity = loghandler.SeverityInfo
+		if action == exposure.ActionEndpointsDrained {
+			event.Severity = loghandler.SeverityHigh
+		}
+		r.emit(ctx, &watch, event)
+	}
+}
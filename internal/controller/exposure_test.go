@@ -0,0 +1,68 @@
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vandathron/watchman/internal/exposure"
+	"github.com/vandathron/watchman/internal/utils"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Exposure", Ordered, func() {
+	ns := "ns-exposure"
+
+	BeforeAll(func() {
+		testCreateNamespaces(makeNamespace(ns))
+	})
+
+	It("Should emit every drain and restore of a Service and forget it once deleted", func() {
+		audit := &recordingProvider{}
+		r := &WatchReconciler{Client: k8sClient, Scheme: k8sClient.Scheme(), Audit: audit}
+
+		svc := makeSvcSpec("web", ns)
+		svc.Annotations = map[string]string{utils.WatchByAnnotationKey: utils.WatchByAnnotationKV}
+		testCreateServices(svc)
+
+		slice := &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-1",
+				Namespace: ns,
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{{
+				Addresses:  []string{"10.0.0.1"},
+				Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)},
+			}},
+		}
+		Expect(k8sClient.Create(ctx, slice)).To(Succeed())
+		r.handleEndpointSlice(ctx, slice)
+
+		for _, ready := range []bool{false, true, false} {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(slice), slice)).To(Succeed())
+			slice.Endpoints[0].Conditions.Ready = ptr.To(ready)
+			Expect(k8sClient.Update(ctx, slice)).To(Succeed())
+			r.handleEndpointSlice(ctx, slice)
+		}
+
+		Expect(audit.Actions()).To(Equal([]string{
+			exposure.ActionEndpointsDrained, exposure.ActionEndpointsRestored, exposure.ActionEndpointsDrained,
+		}))
+		events := audit.Events()
+		Expect(events[0].ResourceVersion).To(Equal(events[2].ResourceVersion))
+		Expect(events[0].ID()).NotTo(Equal(events[2].ID()))
+
+		By("Forgetting the Service once deleted")
+		Expect(k8sClient.Delete(ctx, svc)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(svc), &v1.Service{})).NotTo(Succeed())
+		}, timeout, interval).Should(Succeed())
+		r.handleEndpointSlice(ctx, slice)
+		_, ok := r.endpoints.Load(ns + "/web")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var auditEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "watchman_audit_events_total",
+	Help: "Number of audit events emitted, by kind, action and severity",
+}, []string{"kind", "action", "severity"})
+
+func init() {
+	metrics.Registry.MustRegister(auditEvents)
+}
+
+// trackEvent records an emitted event. e.g (Deployment, Update, critical)
+func trackEvent(event loghandler.AuditEvent) {
+	auditEvents.WithLabelValues(event.Kind, event.Action, event.Severity).Inc()
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/relations"
+	"github.com/vandathron/watchman/internal/utils"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+)
+
+// related returns the objects related to obj.
+func (r *WatchReconciler) related(ctx context.Context, obj client.Object) ([]loghandler.RelatedObject, error) {
+	resolver := &relations.Resolver{Reader: r.Client}
+	return resolver.Resolve(ctx, obj)
+}
+
+// relatedHandler audits the changes of objects of kind for the watches opting in to audit them as related objects.
+// Objects existing before the operator started are not audited as created.
+func (r *WatchReconciler) relatedHandler(kind string) handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			if e.Object.GetCreationTimestamp().Time.Before(r.started) {
+				return
+			}
+			r.auditRelated(ctx, utils.WatchActionTypeCreate, kind, nil, e.Object)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			r.auditRelated(ctx, utils.WatchActionTypeUpdate, kind, e.ObjectOld, e.ObjectNew)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, _ workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			r.auditRelated(ctx, utils.WatchActionTypeDelete, kind, nil, e.Object)
+		},
+	}
+}
+
+// auditRelated audits action performed on obj, of kind, for every watch opting in to audit it as an object related to
+// the resources it selects. Updates are only audited for a watch if they change the spec, labels or annotations of obj
+// once redacted by its policy.
+func (r *WatchReconciler) auditRelated(ctx context.Context, action, kind string, old, obj client.Object) {
+	log := log.FromContext(ctx)
+
+	watchList := &auditv1alpha1.WatchList{}
+	if err := r.List(ctx, watchList, client.MatchingFields{relatedNamespaceField: obj.GetNamespace()}); err != nil {
+		log.Error(err, "Failed to list watches", "Namespace", obj.GetNamespace())
+		return
+	}
+
+	var watches []auditv1alpha1.Watch
+	for _, watch := range watchList.Items {
+		if auditsRelated(watch, obj.GetNamespace(), kind) && (old == nil || r.relatedChanged(&watch, kind, old, obj)) {
+			watches = append(watches, watch)
+		}
+	}
+	if len(watches) == 0 {
+		return
+	}
+
+	related, err := r.related(ctx, obj)
+	if err != nil {
+		log.Error(err, "Failed to resolve related objects", "Kind", kind, "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+		return
+	}
+
+	for _, watch := range watches {
+		if relatesToSelected(watch, obj.GetNamespace(), related) {
+			r.auditFor(ctx, &watch, action, kind, old, obj, true)
+		}
+	}
+}
+
+// auditsRelated reports whether watch audits the objects of kind in namespace related to the resources it selects.
+func auditsRelated(watch auditv1alpha1.Watch, namespace, kind string) bool {
+	if watch.Spec.Related == nil || (len(watch.Spec.Related.Kinds) > 0 && !slices.Contains(watch.Spec.Related.Kinds, kind)) {
+		return false
+	}
+	if kind == utils.SupportedKindService && watch.Selects(namespace, kind) { // audited as a selected resource
+		return false
+	}
+	return watch.Selects(namespace, utils.SupportedKindDeployment) || watch.Selects(namespace, utils.SupportedKindService)
+}
+
+// relatesToSelected reports whether one of related, the objects related to an object of namespace, is selected by watch.
+func relatesToSelected(watch auditv1alpha1.Watch, namespace string, related []loghandler.RelatedObject) bool {
+	for _, object := range related {
+		if object.Relation != relations.RelationOwner && watch.Selects(namespace, object.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// relatedChanged reports whether the spec, labels or annotations of old and new, objects of kind related to the
+// resources of watch, differ once redacted by its policy.
+func (r *WatchReconciler) relatedChanged(watch *auditv1alpha1.Watch, kind string, old, new client.Object) bool {
+	policy, _ := r.redactionPolicyFor(watch) // the operator wide policy if it does not compile
+	oldContent, err := redactedContent(kind, old, policy)
+	if err != nil {
+		return true
+	}
+	newContent, err := redactedContent(kind, new, policy)
+	if err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(oldContent["spec"], newContent["spec"]) ||
+		!reflect.DeepEqual(relatedMetadata(oldContent), relatedMetadata(newContent))
+}
+
+// ignoredAnnotations are kept up to date by kubectl apply and watchman themselves, alongside the changes audited.
+var ignoredAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	utils.WatchByAnnotationKey,
+	utils.WatchActionTypeAnnotationKey,
+	utils.WatchUpdateStateKey,
+}
+
+// relatedMetadata returns the labels and annotations audited of content, the redacted content of a related object.
+func relatedMetadata(content map[string]interface{}) map[string]interface{} {
+	metadata, _ := content["metadata"].(map[string]interface{})
+	labels, ok := metadata["labels"].(map[string]interface{})
+	if !ok {
+		labels = map[string]interface{}{}
+	}
+	annotations := map[string]interface{}{}
+	all, _ := metadata["annotations"].(map[string]interface{})
+	for key, value := range all {
+		if !slices.Contains(ignoredAnnotations, key) {
+			annotations[key] = value
+		}
+	}
+	return map[string]interface{}{"labels": labels, "annotations": annotations}
+}
+
+// relatedNamespaceField indexes watches auditing related objects by the namespaces of the resources they select.
+const relatedNamespaceField = ".spec.related.namespaces"
+
+func relatedNamespaces(obj client.Object) []string {
+	watch := obj.(*auditv1alpha1.Watch)
+	if watch.Spec.Related == nil {
+		return nil
+	}
+
+	var namespaces []string
+	for _, selector := range watch.Spec.Selectors {
+		selectsResources := slices.Contains(selector.Kinds, utils.SupportedKindDeployment) || slices.Contains(selector.Kinds, utils.SupportedKindService)
+		if selectsResources && !slices.Contains(namespaces, selector.Namespace) {
+			namespaces = append(namespaces, selector.Namespace)
+		}
+	}
+	return namespaces
+}
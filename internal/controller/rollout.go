@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/rollout"
+	"github.com/vandathron/watchman/internal/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+)
+
+// trackedRollout is the rollout of a Deployment template change, followed until it ends.
+type trackedRollout struct {
+	// generation of the Deployment the template change made
+	generation int64
+	// causes are the IDs of the events of the template change by watch namespace/name, empty for the operator wide
+	// policy
+	causes map[string]string
+}
+
+// startRollout follows the rollout of the template change of old to deployment, linking the event ending it to
+// events, those emitted for the change. A rollout in progress is superseded.
+func (r *WatchReconciler) startRollout(old, deployment *appsv1.Deployment, events []loghandler.AuditEvent) {
+	if !rollout.TemplateChanged(old, deployment) || len(events) == 0 {
+		return
+	}
+
+	tracked := &trackedRollout{generation: deployment.Generation, causes: map[string]string{}}
+	for _, event := range events {
+		tracked.causes[event.Watch] = event.ID()
+	}
+	r.rollouts.Store(fmt.Sprintf("%s/%s", deployment.Namespace, deployment.Name), tracked)
+}
+
+// trackRollout checks whether the rollout of the Deployment ended on a status update, emitting RolloutSucceeded,
+// RolloutStalled or RolledBack if so.
+func (r *WatchReconciler) trackRollout(ctx context.Context, object client.Object) []reconcile.Request {
+	deployment, ok := object.(*appsv1.Deployment)
+	if !ok {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s/%s", deployment.Namespace, deployment.Name)
+	value, ok := r.rollouts.Load(key)
+	if !ok {
+		return nil
+	}
+	tracked := value.(*trackedRollout)
+
+	action := rollout.Outcome(deployment, tracked.generation)
+	if action == "" || !r.rollouts.CompareAndDelete(key, value) {
+		return nil
+	}
+
+	newReplicaSet, err := r.newReplicaSet(ctx, deployment)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to find new replica set", "Name", deployment.Name, "Namespace", deployment.Namespace)
+	}
+	if action == rollout.ActionSucceeded && rollout.RolledBack(newReplicaSet) {
+		action = rollout.ActionRolledBack
+	}
+
+	r.emitRolloutEvents(ctx, deployment, tracked, action, newReplicaSet)
+	return nil
+}
+
+func (r *WatchReconciler) newReplicaSet(ctx context.Context, deployment *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := r.List(ctx, replicaSets, client.InNamespace(deployment.Namespace)); err != nil {
+		return nil, err
+	}
+	return rollout.NewReplicaSet(deployment, replicaSets.Items), nil
+}
+
+// emitRolloutEvents emits the event ending the rollout for every watch the template change was audited for.
+func (r *WatchReconciler) emitRolloutEvents(ctx context.Context, deployment *appsv1.Deployment, tracked *trackedRollout, action string, newReplicaSet *appsv1.ReplicaSet) {
+	watches := map[string]auditv1alpha1.Watch{"": {}}
+	selecting, err := r.watchesFor(ctx, deployment.Namespace, utils.SupportedKindDeployment)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list watches", "Namespace", deployment.Namespace, "Kind", utils.SupportedKindDeployment)
+	}
+	for _, watch := range selecting {
+		watches[fmt.Sprintf("%s/%s", watch.Namespace, watch.Name)] = watch
+	}
+
+	for key, cause := range tracked.causes {
+		watch, ok := watches[key]
+		if !ok { // no longer selecting the deployment
+			continue
+		}
+
+		event := loghandler.NewAuditEvent(action, deployment, loghandler.NewData(utils.SupportedKindDeployment))
+		event.Watch = key
+		event.CausedBy = cause
+		if newReplicaSet != nil {
+			event.SetField("newReplicaSet", newReplicaSet.Name)
+			event.SetField("revision", newReplicaSet.Annotations[rollout.RevisionAnnotation])
+		}
+		switch action {
+		case rollout.ActionStalled:
+			event.Severity = loghandler.SeverityHigh
+		case rollout.ActionRolledBack:
+			event.Severity = loghandler.SeverityMedium
+		default:
+			event.Severity = loghandler.SeverityInfo
+		}
+		r.emit(ctx, &watch, event)
+	}
+}
+
+// filterRollouts passes the status updates of watched Deployments with a rollout being tracked.
+func (r *WatchReconciler) filterRollouts(e event.TypedUpdateEvent[client.Object]) bool {
+	if !utils.HasWatchManAnnotation(e.ObjectNew.GetAnnotations(), utils.WatchByAnnotationKey, utils.WatchByAnnotationKV) {
+		return false
+	}
+	if _, ok := r.rollouts.Load(fmt.Sprintf("%s/%s", e.ObjectNew.GetNamespace(), e.ObjectNew.GetName())); !ok {
+		return false
+	}
+
+	oldDeployment, ok := e.ObjectOld.(*appsv1.Deployment)
+	if !ok {
+		return false
+	}
+	return !reflect.DeepEqual(oldDeployment.Status, e.ObjectNew.(*appsv1.Deployment).Status)
+}
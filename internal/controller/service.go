@@ -3,7 +3,6 @@ package controller
 import (
 	"context"
 	"fmt"
-	"github.com/vandathron/watchman/internal/loghandler"
 	"github.com/vandathron/watchman/internal/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -33,12 +32,9 @@ func (r *WatchReconciler) handleService(ctx context.Context, object client.Objec
 		return nil
 	}
 
-	data := &loghandler.Data{}
-	data.AddField("Kind", "Service")
-
 	switch action {
 	case utils.WatchActionTypeCreate:
-		r.Audit.Log(svc.Name, utils.WatchActionTypeCreate, svc.Namespace, *data)
+		r.audit(ctx, utils.WatchActionTypeCreate, utils.SupportedKindService, nil, svc)
 
 	case utils.WatchActionTypeUpdate:
 		if utils.HasWatchManAnnotation(svc.Annotations, utils.WatchUpdateStateKey, utils.WatchUpdateStateOld) {
@@ -56,15 +52,15 @@ func (r *WatchReconciler) handleService(ctx context.Context, object client.Objec
 				return nil
 			}
 			delete(oldSvcs, oldSvcKey)
-			r.recordSvcDiff(ctx, oldSvc, svc, data)
-			r.Audit.Log(svc.Name, utils.WatchActionTypeUpdate, svc.Namespace, *data)
+			r.audit(ctx, utils.WatchActionTypeUpdate, utils.SupportedKindService, oldSvc, svc)
 		} else {
 			log.Error(fmt.Errorf("annotation not found"), fmt.Sprintf("%s not found", utils.WatchUpdateStateKey))
 			return nil
 		}
 
 	case utils.WatchActionTypeDelete:
-		r.Audit.Log(svc.Name, utils.WatchActionTypeDelete, svc.Namespace, *data)
+		r.endpoints.Delete(fmt.Sprintf("%s/%s", svc.Namespace, svc.Name))
+		r.audit(ctx, utils.WatchActionTypeDelete, utils.SupportedKindService, nil, svc)
 
 	default:
 		log.Error(fmt.Errorf("invalid action type"), "Unsupported action type", "Type", action)
@@ -133,15 +129,6 @@ func (r *WatchReconciler) unWatchService(ctx context.Context, svc *v1.Service) {
 	}
 }
 
-func (r *WatchReconciler) recordSvcDiff(ctx context.Context, old, new *v1.Service, data *loghandler.Data) {
-	// Compare replicas
-	log := log.FromContext(ctx)
-
-	if err := utils.RecordChanges(old.Spec, new.Spec, ".spec.selector.", data); err != nil {
-		log.Error(err, "record change error")
-	}
-}
-
 func (r *WatchReconciler) filterServices(e event.TypedUpdateEvent[client.Object]) bool {
 	if !utils.HasWatchManAnnotation(e.ObjectOld.GetAnnotations(), utils.WatchByAnnotationKey, utils.WatchByAnnotationKV) {
 		return false
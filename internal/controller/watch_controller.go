@@ -5,9 +5,15 @@ import (
 	"fmt"
 	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
 	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/redaction"
+	"github.com/vandathron/watchman/internal/relations"
 	"github.com/vandathron/watchman/internal/utils"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -19,8 +25,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"strings"
+	"sync"
+	"time"
 )
 
 // WatchReconciler reconciles a Watch object
@@ -28,14 +37,55 @@ type WatchReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Audit  loghandler.Provider
+
+	// PatchSizeLimit is the maximum size in bytes of each patch attached to an update event. No limit if <= 0
+	PatchSizeLimit int
+
+	// Redaction is the operator wide redaction policy, applied along with the policy of each watch
+	Redaction *redaction.Policy
+
+	// APIReader reads the objects not worth caching, e.g pods. The client is used if nil
+	APIReader client.Reader
+
+	// Fanout routes the events of watches to the AuditSinks they reference. Events go to Audit sinks if nil
+	Fanout *loghandler.Fanout
+
+	// compiled caches the compiled expressions and severity rules of watches by namespace/name
+	compiled sync.Map
+
+	// policies caches the redaction policies of watches by namespace/name
+	policies sync.Map
+
+	// rollouts are the Deployment rollouts being followed by namespace/name
+	rollouts sync.Map
+
+	// reverts are the resourceVersions written by baseline reverts by object UID, their updates being audited as the
+	// operator's
+	reverts sync.Map
+
+	// endpoints are the ready endpoint counts of watched Services by namespace/name
+	endpoints sync.Map
+
+	// started is when the controller was set up, objects created before are not audited as related objects
+	started time.Time
+
+	// risky are the risky events waiting to be recorded in the status of their watch, most recent first
+	risky   map[types.NamespacedName][]auditv1alpha1.RiskyEvent
+	riskyMu sync.Mutex
 }
 
 // +kubebuilder:rbac:groups=audit.my.domain,resources=watches,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=audit.my.domain,resources=watches/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=audit.my.domain,resources=watches/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;update;patch;watch
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
-// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;update;create
 
 func (r *WatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -45,12 +95,17 @@ func (r *WatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 
 	if err != nil && errors.IsNotFound(err) {
 		log.Info("Watch resource deleted", "Namespace", req.Namespace, "Name", req.Name)
+		r.route(req.NamespacedName, auditv1alpha1.WatchSpec{})
+		r.compiled.Delete(req.NamespacedName.String())
+		r.policies.Delete(req.NamespacedName.String())
 		return ctrl.Result{}, r.cleanUp(ctx)
 	} else if err != nil {
 		log.Error(err, "Fails to get resource", "Namespace", req.Namespace, "Name", req.Name)
 		return ctrl.Result{}, err
 	}
 
+	r.route(req.NamespacedName, watch.Spec)
+
 	cm := &v1.ConfigMap{}
 	err = r.Get(ctx, types.NamespacedName{Name: watch.Name, Namespace: watch.Namespace}, cm)
 
@@ -85,6 +140,11 @@ func (r *WatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{}, err
 	}
 
+	if err = r.reconcileBaseline(ctx, watch); err != nil {
+		log.Error(err, "Failed to check baseline", "Name", watch.Name, "Namespace", watch.Namespace)
+		return ctrl.Result{}, err
+	}
+
 	log.Info("reconciliation succeeded")
 	return ctrl.Result{}, nil
 }
@@ -202,6 +262,31 @@ func (r *WatchReconciler) reconcileWatchManResource(ctx context.Context, watch *
 	}
 }
 
+// route sends the events of the watch to the AuditSinks of its namespace named in its sinks and routes, or the default
+// sinks if there are none.
+func (r *WatchReconciler) route(watch types.NamespacedName, spec auditv1alpha1.WatchSpec) {
+	if r.Fanout == nil {
+		return
+	}
+
+	sinkNames := func(sinks []string) []string {
+		names := make([]string, 0, len(sinks))
+		for _, sink := range sinks {
+			names = append(names, types.NamespacedName{Namespace: watch.Namespace, Name: sink}.String())
+		}
+		return names
+	}
+
+	var routes []loghandler.Route
+	if len(spec.Sinks) > 0 {
+		routes = append(routes, loghandler.Route{Sinks: sinkNames(spec.Sinks)})
+	}
+	for _, route := range spec.Routes {
+		routes = append(routes, loghandler.Route{Match: filterConfig(route.Match), Sinks: sinkNames(route.Sinks)})
+	}
+	r.Fanout.Route(watch.String(), routes)
+}
+
 func (r *WatchReconciler) cleanUp(ctx context.Context) error {
 	// TODO: remove annotations from resources
 	return nil
@@ -224,7 +309,7 @@ func (r *WatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		annotations := e.Object.GetAnnotations()
 
 		if utils.HasWatchManAnnotation(annotations, utils.WatchByAnnotationKey, utils.WatchByAnnotationKV) {
-			annotations[utils.WatchActionTypeAnnotationKey] = utils.WatchActionTypeUpdate
+			annotations[utils.WatchActionTypeAnnotationKey] = utils.WatchActionTypeDelete
 			e.Object.SetAnnotations(annotations)
 			return true
 		}
@@ -238,7 +323,39 @@ func (r *WatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	bldr.Watches(&appsv1.Deployment{}, handler.EnqueueRequestsFromMapFunc(r.handleDeployment), builder.WithPredicates(deployPredicate))
 	bldr.Watches(&v1.Service{}, handler.EnqueueRequestsFromMapFunc(r.handleService), builder.WithPredicates(svcPredicate))
 
-	return bldr.For(&auditv1alpha1.Watch{}).
+	// Status updates of Deployments being rolled out end their rollout
+	rolloutPredicate := predicate.Funcs{
+		CreateFunc:  func(event.TypedCreateEvent[client.Object]) bool { return false },
+		DeleteFunc:  func(event.TypedDeleteEvent[client.Object]) bool { return false },
+		GenericFunc: func(event.TypedGenericEvent[client.Object]) bool { return false },
+		UpdateFunc:  r.filterRollouts,
+	}
+	bldr.Watches(&appsv1.Deployment{}, handler.EnqueueRequestsFromMapFunc(r.trackRollout), builder.WithPredicates(rolloutPredicate))
+
+	// EndpointSlices of watched Services populating or draining them
+	bldr.Watches(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(r.handleEndpointSlice))
+
+	// Objects related to the resources of watches opting in, only looked up in the watches indexed by the namespaces
+	// they audit related objects in
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &auditv1alpha1.Watch{}, relatedNamespaceField, relatedNamespaces); err != nil {
+		return err
+	}
+	r.started = time.Now()
+	bldr.Watches(&v1.Service{}, r.relatedHandler(utils.SupportedKindService))
+	bldr.Watches(&autoscalingv2.HorizontalPodAutoscaler{}, r.relatedHandler(relations.KindHorizontalPodAutoscaler))
+	bldr.Watches(&policyv1.PodDisruptionBudget{}, r.relatedHandler(relations.KindPodDisruptionBudget))
+	bldr.Watches(&networkingv1.Ingress{}, r.relatedHandler(relations.KindIngress))
+
+	// Baseline manifests and snapshots changing trigger a new check
+	bldr.Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.watchesForConfigMap))
+
+	// Risky events are recorded in the status of watches in batches, off the audit path
+	if err := mgr.Add(manager.RunnableFunc(r.flushRiskyEvents)); err != nil {
+		return err
+	}
+
+	// Status updates, e.g the risky events recorded, do not trigger a reconciliation
+	return bldr.For(&auditv1alpha1.Watch{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Named("watch").
 		Complete(r)
 }
@@ -0,0 +1,193 @@
+// Package exposure describes how a Service change affects what it exposes and to whom: its ports, type, external
+// addresses, the pods it selects and its ready endpoints.
+package exposure
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Actions of the events emitted for the endpoints of a Service.
+const (
+	// ActionEndpointsDrained is emitted once a Service has no ready endpoint left
+	ActionEndpointsDrained = "EndpointsDrained"
+	// ActionEndpointsRestored is emitted once a Service without ready endpoints has some again
+	ActionEndpointsRestored = "EndpointsRestored"
+)
+
+// Fields describing a Service change. Lists are comma separated.
+const (
+	FieldPortsAdded             = "portsAdded"
+	FieldPortsRemoved           = "portsRemoved"
+	FieldPortsChanged           = "portsChanged"
+	FieldTypeTransition         = "typeTransition"
+	FieldExternalIPsAdded       = "externalIPsAdded"
+	FieldExternalIPsRemoved     = "externalIPsRemoved"
+	FieldSourceRangesAdded      = "loadBalancerSourceRangesAdded"
+	FieldSourceRangesRemoved    = "loadBalancerSourceRangesRemoved"
+	FieldPodsSelected           = "podsSelected"
+	FieldPodsDeselected         = "podsDeselected"
+	FieldReadyEndpoints         = "readyEndpoints"
+	FieldPreviousReadyEndpoints = "previousReadyEndpoints"
+)
+
+// Diff describes the change of a Service from old to new, two objects as decoded from JSON, in fields. Ports are
+// matched by name, e.g (http:80/TCP).
+func Diff(old, new map[string]interface{}) map[string]string {
+	fields := map[string]string{}
+	set := func(name string, values []string) {
+		if len(values) > 0 {
+			fields[name] = strings.Join(values, ",")
+		}
+	}
+
+	oldSpec, _ := old["spec"].(map[string]interface{})
+	newSpec, _ := new["spec"].(map[string]interface{})
+
+	oldPorts, newPorts := ports(oldSpec), ports(newSpec)
+	var added, removed, changed []string
+	for _, name := range sortedKeys(newPorts) {
+		previous, ok := oldPorts[name]
+		switch {
+		case !ok:
+			added = append(added, newPorts[name])
+		case previous != newPorts[name]:
+			changed = append(changed, fmt.Sprintf("%s (%s -> %s)", name, previous, newPorts[name]))
+		}
+	}
+	for _, name := range sortedKeys(oldPorts) {
+		if _, ok := newPorts[name]; !ok {
+			removed = append(removed, oldPorts[name])
+		}
+	}
+	set(FieldPortsAdded, added)
+	set(FieldPortsRemoved, removed)
+	set(FieldPortsChanged, changed)
+
+	if oldType, newType := serviceType(oldSpec), serviceType(newSpec); oldType != newType {
+		fields[FieldTypeTransition] = oldType + "->" + newType
+	}
+
+	added, removed = listDiff(oldSpec, newSpec, "externalIPs")
+	set(FieldExternalIPsAdded, added)
+	set(FieldExternalIPsRemoved, removed)
+
+	added, removed = listDiff(oldSpec, newSpec, "loadBalancerSourceRanges")
+	set(FieldSourceRangesAdded, added)
+	set(FieldSourceRangesRemoved, removed)
+
+	return fields
+}
+
+// Reselected counts the pods newly selected and no longer selected when the selector of a Service changes from old
+// to new. An empty selector selects no pod.
+func Reselected(old, new map[string]string, pods []corev1.Pod) (selected, deselected int) {
+	for _, pod := range pods {
+		wasSelected, isSelected := selects(old, pod), selects(new, pod)
+		switch {
+		case isSelected && !wasSelected:
+			selected++
+		case wasSelected && !isSelected:
+			deselected++
+		}
+	}
+	return selected, deselected
+}
+
+// ReadyEndpoints counts the ready endpoints of the EndpointSlices of a Service. Endpoints of several address families
+// backed by the same pod count once.
+func ReadyEndpoints(endpointSlices []discoveryv1.EndpointSlice) int {
+	ready := map[string]bool{}
+	for _, slice := range endpointSlices {
+		for _, endpoint := range slice.Endpoints {
+			// An unknown readiness is ready, as for the EndpointSlice API
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			key := strings.Join(endpoint.Addresses, ",")
+			if endpoint.TargetRef != nil && endpoint.TargetRef.UID != "" {
+				key = string(endpoint.TargetRef.UID)
+			}
+			ready[key] = true
+		}
+	}
+	return len(ready)
+}
+
+func selects(selector map[string]string, pod corev1.Pod) bool {
+	return len(selector) > 0 && labels.SelectorFromSet(selector).Matches(labels.Set(pod.Labels))
+}
+
+// ports returns the ports of spec formatted by name. e.g (http: http:80/TCP)
+func ports(spec map[string]interface{}) map[string]string {
+	byName := map[string]string{}
+	list, _ := spec["ports"].([]interface{})
+	for _, item := range list {
+		port, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := port["name"].(string)
+		if name == "" {
+			name = "<unnamed>"
+		}
+		protocol, _ := port["protocol"].(string)
+		if protocol == "" {
+			protocol = "TCP"
+		}
+
+		formatted := fmt.Sprintf("%s:%v/%s", name, port["port"], protocol)
+		for _, key := range []string{"targetPort", "nodePort"} {
+			if value, ok := port[key]; ok {
+				formatted += fmt.Sprintf(" %s=%v", key, value)
+			}
+		}
+		byName[name] = formatted
+	}
+	return byName
+}
+
+func serviceType(spec map[string]interface{}) string {
+	if t, _ := spec["type"].(string); t != "" {
+		return t
+	}
+	return "ClusterIP"
+}
+
+func listDiff(old, new map[string]interface{}, key string) (added, removed []string) {
+	oldValues, newValues := stringList(old[key]), stringList(new[key])
+	for _, value := range newValues {
+		if !slices.Contains(oldValues, value) {
+			added = append(added, value)
+		}
+	}
+	for _, value := range oldValues {
+		if !slices.Contains(newValues, value) {
+			removed = append(removed, value)
+		}
+	}
+	return added, removed
+}
+
+func stringList(value interface{}) []string {
+	list, _ := value.([]interface{})
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		values = append(values, fmt.Sprint(item))
+	}
+	return values
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
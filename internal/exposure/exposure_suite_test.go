@@ -0,0 +1,14 @@
+package exposure
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestExposure(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Exposure Suite")
+}
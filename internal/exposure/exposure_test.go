@@ -0,0 +1,83 @@
+package exposure
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("Exposure", func() {
+	service := func(spec map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"spec": spec}
+	}
+	port := func(name string, number float64) map[string]interface{} {
+		return map[string]interface{}{"name": name, "port": number, "protocol": "TCP"}
+	}
+
+	It("Should describe ports by name", func() {
+		old := service(map[string]interface{}{"ports": []interface{}{port("http", 80), port("metrics", 9090)}})
+		new := service(map[string]interface{}{"ports": []interface{}{port("http", 8080), port("https", 443)}})
+
+		Expect(Diff(old, new)).To(Equal(map[string]string{
+			FieldPortsAdded:   "https:443/TCP",
+			FieldPortsRemoved: "metrics:9090/TCP",
+			FieldPortsChanged: "http (http:80/TCP -> http:8080/TCP)",
+		}))
+	})
+
+	It("Should describe type transitions and external addresses", func() {
+		old := service(map[string]interface{}{"externalIPs": []interface{}{"10.0.0.1"}})
+		new := service(map[string]interface{}{
+			"type":                     "LoadBalancer",
+			"externalIPs":              []interface{}{"10.0.0.2"},
+			"loadBalancerSourceRanges": []interface{}{"0.0.0.0/0"},
+		})
+
+		Expect(Diff(old, new)).To(Equal(map[string]string{
+			FieldTypeTransition:     "ClusterIP->LoadBalancer",
+			FieldExternalIPsAdded:   "10.0.0.2",
+			FieldExternalIPsRemoved: "10.0.0.1",
+			FieldSourceRangesAdded:  "0.0.0.0/0",
+		}))
+		Expect(Diff(new, new)).To(BeEmpty())
+	})
+
+	It("Should count pods newly selected and deselected", func() {
+		pod := func(app, tier string) corev1.Pod {
+			return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": app, "tier": tier}}}
+		}
+		pods := []corev1.Pod{pod("web", "v1"), pod("web", "v2"), pod("web", "v2"), pod("db", "v1")}
+
+		selected, deselected := Reselected(map[string]string{"app": "web", "tier": "v1"}, map[string]string{"app": "web", "tier": "v2"}, pods)
+		Expect(selected).To(Equal(2))
+		Expect(deselected).To(Equal(1))
+
+		selected, deselected = Reselected(nil, map[string]string{"app": "db"}, pods)
+		Expect(selected).To(Equal(1))
+		Expect(deselected).To(BeZero())
+	})
+
+	It("Should count ready endpoints once per pod", func() {
+		endpoint := func(uid, address string, ready *bool) discoveryv1.Endpoint {
+			return discoveryv1.Endpoint{
+				Addresses:  []string{address},
+				Conditions: discoveryv1.EndpointConditions{Ready: ready},
+				TargetRef:  &corev1.ObjectReference{UID: types.UID("pod-" + uid)},
+			}
+		}
+		endpointSlices := []discoveryv1.EndpointSlice{
+			{AddressType: discoveryv1.AddressTypeIPv4, Endpoints: []discoveryv1.Endpoint{
+				endpoint("a", "10.0.0.1", ptr.To(true)), endpoint("b", "10.0.0.2", nil), endpoint("c", "10.0.0.3", ptr.To(false)),
+			}},
+			{AddressType: discoveryv1.AddressTypeIPv6, Endpoints: []discoveryv1.Endpoint{
+				endpoint("a", "fd00::1", ptr.To(true)),
+			}},
+		}
+		Expect(ReadyEndpoints(endpointSlices)).To(Equal(2))
+		Expect(ReadyEndpoints(nil)).To(BeZero())
+	})
+})
@@ -0,0 +1,237 @@
+// Package expression evaluates the CEL expressions of watches deciding whether an event is emitted and computing
+// fields attached to it. Expressions see the redacted objects only.
+package expression
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+// costLimit bounds the work of a single evaluation so a watch can not stall the reconciler.
+const costLimit = 1000000
+
+// Variables available to expressions.
+const (
+	// VarOld is the object before the change, null for creations
+	VarOld = "old"
+	// VarNew is the object after the change, null for deletions
+	VarNew = "new"
+	// VarChanges is the list of changes, each a map of path, op, old and new
+	VarChanges = "changes"
+	// VarActor is the field manager of the change
+	VarActor = "actor"
+	// VarKind is the kind of the object. e.g (Deployment)
+	VarKind = "kind"
+	// VarAction is the action performed on the object. e.g (Update)
+	VarAction = "action"
+)
+
+// Input is what expressions are evaluated against.
+type Input struct {
+	Kind    string
+	Action  string
+	Old     map[string]interface{}
+	New     map[string]interface{}
+	Changes []loghandler.Change
+	Actor   string
+}
+
+// Program is a compiled condition and set of enrichments. The zero value and nil emit every event without fields.
+type Program struct {
+	condition   *Condition
+	enrichments map[string]cel.Program
+}
+
+// Condition is a compiled boolean expression.
+type Condition struct {
+	program cel.Program
+}
+
+// NewCondition compiles expr, a boolean expression. e.g (new.spec.replicas < old.spec.replicas)
+func NewCondition(expr string) (*Condition, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	program, err := compile(env, expr, cel.BoolType)
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{program: program}, nil
+}
+
+// Match reports whether in satisfies c.
+func (c *Condition) Match(in Input) (bool, error) {
+	vars, err := in.activation()
+	if err != nil {
+		return false, err
+	}
+	return c.eval(vars)
+}
+
+func (c *Condition) eval(vars map[string]interface{}) (bool, error) {
+	out, _, err := c.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("returned %s, expected bool", out.Type().TypeName())
+	}
+	return b, nil
+}
+
+// NewProgram compiles condition, a boolean expression, and enrichments, string expressions by field name.
+// e.g (new.spec.replicas < old.spec.replicas)
+func NewProgram(condition string, enrichments map[string]string) (*Program, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Program{enrichments: map[string]cel.Program{}}
+	if condition != "" {
+		program, err := compile(env, condition, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: %w", err)
+		}
+		p.condition = &Condition{program: program}
+	}
+
+	for field, expr := range enrichments {
+		if field == "" {
+			return nil, fmt.Errorf("enrichment name can not be empty")
+		}
+		if p.enrichments[field], err = compile(env, expr, cel.StringType); err != nil {
+			return nil, fmt.Errorf("invalid enrichment %s: %w", field, err)
+		}
+	}
+
+	return p, nil
+}
+
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable(VarKind, cel.StringType),
+		cel.Variable(VarAction, cel.StringType),
+		cel.Variable(VarOld, cel.DynType),
+		cel.Variable(VarNew, cel.DynType),
+		cel.Variable(VarChanges, cel.ListType(cel.MapType(cel.StringType, cel.DynType))),
+		cel.Variable(VarActor, cel.StringType),
+		ext.Strings(),
+	)
+}
+
+func compile(env *cel.Env, expr string, output *cel.Type) (cel.Program, error) {
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !ast.OutputType().IsExactType(output) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression returns %s, expected %s", ast.OutputType(), output)
+	}
+	return env.Program(ast, cel.CostLimit(costLimit))
+}
+
+// Eval reports whether the event of in is emitted and computes its fields. An event is emitted if the condition
+// fails to evaluate; enrichments failing to evaluate are left out and reported in err.
+func (p *Program) Eval(in Input) (emit bool, fields map[string]string, err error) {
+	if p == nil {
+		return true, nil, nil
+	}
+
+	vars, err := in.activation()
+	if err != nil {
+		return true, nil, err
+	}
+
+	if p.condition != nil {
+		emit, err := p.condition.eval(vars)
+		if err != nil {
+			return true, nil, fmt.Errorf("condition: %w", err)
+		}
+		if !emit {
+			return false, nil, nil
+		}
+	}
+
+	var errs []error
+	names := make([]string, 0, len(p.enrichments))
+	for name := range p.enrichments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		out, _, evalErr := p.enrichments[name].Eval(vars)
+		if evalErr != nil {
+			errs = append(errs, fmt.Errorf("enrichment %s: %w", name, evalErr))
+			continue
+		}
+		value, ok := out.Value().(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("enrichment %s returned %s, expected string", name, out.Type().TypeName()))
+			continue
+		}
+		if fields == nil {
+			fields = map[string]string{}
+		}
+		fields[name] = value
+	}
+
+	return true, fields, errors.Join(errs...)
+}
+
+func (in Input) activation() (map[string]interface{}, error) {
+	changes := make([]map[string]interface{}, 0, len(in.Changes))
+	for _, change := range in.Changes {
+		oldValue, err := decode(change.OldValue())
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := decode(change.NewValue())
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, map[string]interface{}{
+			"path": change.Path(),
+			"op":   string(change.Op()),
+			"old":  oldValue,
+			"new":  newValue,
+		})
+	}
+
+	// Typed nil maps would not compare equal to null
+	var oldObj, newObj interface{}
+	if in.Old != nil {
+		oldObj = in.Old
+	}
+	if in.New != nil {
+		newObj = in.New
+	}
+
+	return map[string]interface{}{
+		VarKind:    in.Kind,
+		VarAction:  in.Action,
+		VarOld:     oldObj,
+		VarNew:     newObj,
+		VarChanges: changes,
+		VarActor:   in.Actor,
+	}, nil
+}
+
+func decode(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
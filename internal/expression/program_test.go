@@ -0,0 +1,86 @@
+package expression
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+var _ = Describe("Program", func() {
+	deployment := func(replicas int64, team string) map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": team}},
+			"spec":     map[string]interface{}{"replicas": replicas},
+		}
+	}
+
+	It("Should emit the events matching the condition with their enrichments", func() {
+		program, err := NewProgram("new.spec.replicas < old.spec.replicas", map[string]string{
+			"team":  "new.metadata.labels.team",
+			"scale": "string(old.spec.replicas) + '->' + string(new.spec.replicas)",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		emit, fields, err := program.Eval(Input{Old: deployment(3, "payments"), New: deployment(1, "payments")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emit).To(BeTrue())
+		Expect(fields).To(Equal(map[string]string{"team": "payments", "scale": "3->1"}))
+
+		emit, fields, err = program.Eval(Input{Old: deployment(1, "payments"), New: deployment(3, "payments")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emit).To(BeFalse())
+		Expect(fields).To(BeNil())
+	})
+
+	It("Should evaluate changes and actor", func() {
+		data := loghandler.NewData("Deployment")
+		Expect(data.AddChange(".spec.replicas", 3, 1)).To(Succeed())
+
+		program, err := NewProgram("actor == 'helm' && changes.exists(c, c.path == '.spec.replicas' && c.new < c.old)", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		emit, _, err := program.Eval(Input{Changes: data.Changes(), Actor: "helm"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emit).To(BeTrue())
+
+		emit, _, err = program.Eval(Input{Changes: data.Changes(), Actor: "kubectl-edit"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emit).To(BeFalse())
+	})
+
+	It("Should emit events the condition fails to evaluate for", func() {
+		program, err := NewProgram("new.spec.replicas < old.spec.replicas", map[string]string{"team": "new.metadata.labels.team"})
+		Expect(err).NotTo(HaveOccurred())
+
+		emit, fields, err := program.Eval(Input{New: deployment(1, "payments")})
+		Expect(err).To(HaveOccurred())
+		Expect(emit).To(BeTrue())
+		Expect(fields).To(BeNil())
+
+		program, err = NewProgram("old == null", map[string]string{"team": "new.metadata.labels.team"})
+		Expect(err).NotTo(HaveOccurred())
+		emit, fields, err = program.Eval(Input{New: deployment(1, "payments")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emit).To(BeTrue())
+		Expect(fields).To(HaveKeyWithValue("team", "payments"))
+	})
+
+	It("Should reject expressions not returning the expected type", func() {
+		_, err := NewProgram("actor", nil)
+		Expect(err).To(MatchError(ContainSubstring("invalid condition")))
+
+		_, err = NewProgram("", map[string]string{"count": "size(changes)"})
+		Expect(err).To(MatchError(ContainSubstring("invalid enrichment count")))
+
+		_, err = NewProgram("unknown.spec == 1", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should emit every event without expressions", func() {
+		var program *Program
+		emit, fields, err := program.Eval(Input{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(emit).To(BeTrue())
+		Expect(fields).To(BeNil())
+	})
+})
@@ -0,0 +1,141 @@
+// Package freeze evaluates the freeze windows of watches, recurring on a cron schedule or spanning an absolute range,
+// in the time zone they are declared in.
+package freeze
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+)
+
+// ViolationTag tags the events of changes made during an Audit window.
+const ViolationTag = "FreezeViolation"
+
+// localLayout is the layout of Start and End without an offset, read in the time zone of the window.
+const localLayout = "2006-01-02T15:04:05"
+
+var parser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Window is a compiled freeze window.
+type Window struct {
+	Name string
+	Mode string
+
+	kinds        []string
+	exemptUsers  []string
+	exemptGroups []string
+
+	schedule   cron.Schedule
+	duration   time.Duration
+	location   *time.Location
+	start, end time.Time
+}
+
+// NewWindow compiles spec, which sets either Schedule and Duration or Start and End.
+func NewWindow(spec auditv1alpha1.FreezeWindow) (*Window, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("freeze window name can not be empty")
+	}
+
+	location, err := time.LoadLocation(spec.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("freeze window %s: invalid time zone: %w", spec.Name, err)
+	}
+
+	w := &Window{
+		Name:         spec.Name,
+		Mode:         spec.Mode,
+		kinds:        spec.Kinds,
+		exemptUsers:  spec.ExemptUsers,
+		exemptGroups: spec.ExemptGroups,
+		location:     location,
+	}
+	if w.Mode == "" {
+		w.Mode = auditv1alpha1.FreezeModeAudit
+	}
+
+	recurring := spec.Schedule != "" || spec.Duration != nil
+	absolute := spec.Start != "" || spec.End != ""
+	switch {
+	case recurring && absolute:
+		return nil, fmt.Errorf("freeze window %s: set either schedule and duration or start and end", spec.Name)
+	case recurring:
+		if spec.Schedule == "" || spec.Duration == nil || spec.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("freeze window %s: a schedule needs a positive duration", spec.Name)
+		}
+		if w.schedule, err = parser.Parse(spec.Schedule); err != nil {
+			return nil, fmt.Errorf("freeze window %s: invalid schedule: %w", spec.Name, err)
+		}
+		w.duration = spec.Duration.Duration
+	case absolute:
+		if w.start, err = parseTime(spec.Start, location); err != nil {
+			return nil, fmt.Errorf("freeze window %s: invalid start: %w", spec.Name, err)
+		}
+		if w.end, err = parseTime(spec.End, location); err != nil {
+			return nil, fmt.Errorf("freeze window %s: invalid end: %w", spec.Name, err)
+		}
+		if !w.end.After(w.start) {
+			return nil, fmt.Errorf("freeze window %s: end must be after start", spec.Name)
+		}
+	default:
+		return nil, fmt.Errorf("freeze window %s: set either schedule and duration or start and end", spec.Name)
+	}
+
+	return w, nil
+}
+
+// NewWindows compiles specs.
+func NewWindows(specs []auditv1alpha1.FreezeWindow) ([]*Window, error) {
+	windows := make([]*Window, 0, len(specs))
+	for _, spec := range specs {
+		w, err := NewWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseTime(value string, location *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(localLayout, value, location)
+}
+
+// Active reports whether t falls in w and, if so, when the window ends.
+func (w *Window) Active(t time.Time) (active bool, until time.Time) {
+	if w.schedule == nil {
+		return !t.Before(w.start) && t.Before(w.end), w.end
+	}
+
+	// The last start before t is the first one after t minus the duration, if any
+	start := w.schedule.Next(t.In(w.location).Add(-w.duration))
+	if start.IsZero() || start.After(t) {
+		return false, time.Time{}
+	}
+	return true, start.Add(w.duration)
+}
+
+// Selects reports whether w freezes kind.
+func (w *Window) Selects(kind string) bool {
+	return len(w.kinds) == 0 || slices.Contains(w.kinds, kind)
+}
+
+// Exempts reports whether user or one of groups may change resources during w.
+func (w *Window) Exempts(user string, groups []string) bool {
+	if slices.Contains(w.exemptUsers, user) {
+		return true
+	}
+	for _, group := range groups {
+		if slices.Contains(w.exemptGroups, group) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package freeze
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+)
+
+var _ = Describe("Window", func() {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	It("Should be active during a recurring window in its time zone", func() {
+		// Friday 18:00 to Monday 08:00, Berlin time
+		w, err := NewWindow(auditv1alpha1.FreezeWindow{
+			Name:     "weekend",
+			Schedule: "0 18 * * 5",
+			Duration: &metav1.Duration{Duration: 62 * time.Hour},
+			TimeZone: "Europe/Berlin",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Mode).To(Equal(auditv1alpha1.FreezeModeAudit))
+
+		active, until := w.Active(time.Date(2026, 10, 17, 12, 0, 0, 0, berlin))
+		Expect(active).To(BeTrue())
+		Expect(until.Equal(time.Date(2026, 10, 19, 8, 0, 0, 0, berlin))).To(BeTrue())
+
+		active, _ = w.Active(time.Date(2026, 10, 16, 17, 59, 0, 0, berlin))
+		Expect(active).To(BeFalse())
+		active, _ = w.Active(time.Date(2026, 10, 16, 16, 30, 0, 0, time.UTC)) // 18:30 in Berlin
+		Expect(active).To(BeTrue())
+		active, _ = w.Active(time.Date(2026, 10, 19, 8, 0, 0, 0, berlin))
+		Expect(active).To(BeFalse())
+	})
+
+	It("Should be active during an absolute window", func() {
+		w, err := NewWindow(auditv1alpha1.FreezeWindow{
+			Name:     "end-of-year",
+			Start:    "2026-12-20T00:00:00",
+			End:      "2027-01-04T09:00:00Z",
+			TimeZone: "Europe/Berlin",
+			Mode:     auditv1alpha1.FreezeModeEnforce,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		active, _ := w.Active(time.Date(2026, 12, 19, 23, 30, 0, 0, time.UTC)) // 00:30 in Berlin
+		Expect(active).To(BeTrue())
+		active, _ = w.Active(time.Date(2026, 12, 19, 22, 30, 0, 0, time.UTC))
+		Expect(active).To(BeFalse())
+		active, until := w.Active(time.Date(2027, 1, 4, 8, 59, 0, 0, time.UTC))
+		Expect(active).To(BeTrue())
+		Expect(until.Equal(time.Date(2027, 1, 4, 9, 0, 0, 0, time.UTC))).To(BeTrue())
+	})
+
+	It("Should select kinds and exempt users and groups", func() {
+		w, err := NewWindow(auditv1alpha1.FreezeWindow{
+			Name:         "release",
+			Start:        "2026-10-01T00:00:00Z",
+			End:          "2026-10-02T00:00:00Z",
+			Kinds:        []string{"Deployment"},
+			ExemptUsers:  []string{"alice"},
+			ExemptGroups: []string{"sre"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(w.Selects("Deployment")).To(BeTrue())
+		Expect(w.Selects("Service")).To(BeFalse())
+		Expect(w.Exempts("alice", nil)).To(BeTrue())
+		Expect(w.Exempts("bob", []string{"dev", "sre"})).To(BeTrue())
+		Expect(w.Exempts("bob", []string{"dev"})).To(BeFalse())
+	})
+
+	DescribeTable("Should reject invalid windows",
+		func(spec auditv1alpha1.FreezeWindow) {
+			_, err := NewWindow(spec)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("without a name", auditv1alpha1.FreezeWindow{Start: "2026-10-01T00:00:00Z", End: "2026-10-02T00:00:00Z"}),
+		Entry("without a range", auditv1alpha1.FreezeWindow{Name: "w"}),
+		Entry("with a schedule and a range", auditv1alpha1.FreezeWindow{
+			Name: "w", Schedule: "@daily", Duration: &metav1.Duration{Duration: time.Hour},
+			Start: "2026-10-01T00:00:00Z", End: "2026-10-02T00:00:00Z",
+		}),
+		Entry("with a schedule but no duration", auditv1alpha1.FreezeWindow{Name: "w", Schedule: "@daily"}),
+		Entry("with an invalid schedule", auditv1alpha1.FreezeWindow{
+			Name: "w", Schedule: "every day", Duration: &metav1.Duration{Duration: time.Hour},
+		}),
+		Entry("with an end before the start", auditv1alpha1.FreezeWindow{
+			Name: "w", Start: "2026-10-02T00:00:00Z", End: "2026-10-01T00:00:00Z",
+		}),
+		Entry("with an unknown time zone", auditv1alpha1.FreezeWindow{
+			Name: "w", Start: "2026-10-01T00:00:00", End: "2026-10-02T00:00:00", TimeZone: "Mars/Olympus",
+		}),
+	)
+})
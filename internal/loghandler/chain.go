@@ -0,0 +1,183 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ActionCheckpoint is the action of the signed checkpoint events emitted by HashChain.
+const ActionCheckpoint = "Checkpoint"
+
+// ChainLink links an event to the event emitted before it.
+type ChainLink struct {
+	// ID identifies the chain. A new chain is started every time the operator starts
+	ID string `json:"id"`
+	// Sequence is the position of the event in the chain, starting at 1
+	Sequence uint64 `json:"sequence"`
+	// PrevHash is the hash of the previous event in the chain. Empty for the first event
+	PrevHash string `json:"prevHash,omitempty"`
+	// Hash is the SHA-256 of the canonical JSON of the event without Hash and Signature
+	Hash string `json:"hash"`
+	// Signature is the ed25519 signature of Hash. Only set on checkpoint events
+	Signature string `json:"signature,omitempty"`
+}
+
+// HashChain is a Provider that gives every event a sequence number and a hash chained to the previous event before
+// passing it on to next. Every checkpointEvery events, a checkpoint event signed with key is emitted.
+type HashChain struct {
+	mu       sync.Mutex
+	next     Provider
+	id       string
+	sequence uint64
+	prevHash string
+
+	key                 ed25519.PrivateKey
+	checkpointEvery     int
+	sinceLastCheckpoint int
+}
+
+// NewHashChain creates a HashChain in front of next. No checkpoints are emitted if key is nil or checkpointEvery <= 0.
+func NewHashChain(next Provider, key ed25519.PrivateKey, checkpointEvery int) (*HashChain, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	c := &HashChain{next: next, id: hex.EncodeToString(id), key: key}
+	if key != nil && checkpointEvery > 0 {
+		c.checkpointEvery = checkpointEvery
+	}
+	return c, nil
+}
+
+// Start runs next if it is a manager.Runnable, otherwise waits for ctx to be done.
+func (c *HashChain) Start(ctx context.Context) error {
+	if runnable, ok := c.next.(manager.Runnable); ok {
+		return runnable.Start(ctx)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *HashChain) Log(event AuditEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.link(&event); err != nil {
+		loghandlerlog.Error(err, "Failed to chain audit event", "Name", event.Name, "Namespace", event.Namespace)
+		return
+	}
+	c.next.Log(event)
+
+	c.sinceLastCheckpoint++
+	if c.checkpointEvery == 0 || c.sinceLastCheckpoint < c.checkpointEvery {
+		return
+	}
+	c.sinceLastCheckpoint = 0
+
+	checkpoint := AuditEvent{Action: ActionCheckpoint, Timestamp: time.Now().UTC()}
+	if err := c.link(&checkpoint); err != nil {
+		loghandlerlog.Error(err, "Failed to chain checkpoint event")
+		return
+	}
+	checkpoint.Chain.Signature = hex.EncodeToString(ed25519.Sign(c.key, []byte(checkpoint.Chain.Hash)))
+	c.next.Log(checkpoint)
+}
+
+func (c *HashChain) link(event *AuditEvent) error {
+	event.Chain = &ChainLink{ID: c.id, Sequence: c.sequence + 1, PrevHash: c.prevHash}
+
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	hash, err := chainHash(raw)
+	if err != nil {
+		return err
+	}
+
+	event.Chain.Hash = hash
+	c.sequence++
+	c.prevHash = hash
+	return nil
+}
+
+// chainHash hashes the canonical form of a JSON encoded event; object keys sorted, numbers kept as written and
+// chain hash and signature left out. Working on the JSON rather than AuditEvent lets stored events be verified
+// regardless of the version of watchman reading them.
+func chainHash(raw []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var event map[string]interface{}
+	if err := decoder.Decode(&event); err != nil {
+		return "", err
+	}
+
+	chain, ok := event["chain"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("event has no chain link")
+	}
+	delete(chain, "hash")
+	delete(chain, "signature")
+
+	canonical, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseSigningKey parses a PEM encoded PKCS #8 ed25519 private key e.g as generated by
+// openssl genpkey -algorithm ed25519
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an ed25519 private key but got %T", key)
+	}
+	return edKey, nil
+}
+
+// ParseVerifyingKey parses a PEM encoded PKIX ed25519 public key e.g as generated by openssl pkey -pubout
+func ParseVerifyingKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an ed25519 public key but got %T", key)
+	}
+	return edKey, nil
+}
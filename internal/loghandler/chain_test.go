@@ -0,0 +1,122 @@
+package loghandler
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HashChain", func() {
+	var (
+		rec       *recorder
+		publicKey ed25519.PublicKey
+		lines     []string
+	)
+
+	BeforeEach(func() {
+		rec = &recorder{}
+		var privateKey ed25519.PrivateKey
+		var err error
+		publicKey, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		chain, err := NewHashChain(rec, privateKey, 2)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 4; i++ {
+			chain.Log(makeEvent(fmt.Sprintf("deploy-%d", i), "Update"))
+		}
+
+		lines = nil
+		for _, event := range rec.Events() {
+			raw, err := json.Marshal(event)
+			Expect(err).NotTo(HaveOccurred())
+			lines = append(lines, string(raw))
+		}
+	})
+
+	verify := func(lines []string, key ed25519.PublicKey) *ChainReport {
+		report, err := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), key, false)
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	It("Should chain every event and emit signed checkpoints", func() {
+		events := rec.Events()
+		Expect(events).To(HaveLen(6)) // 4 events and a checkpoint after every 2
+
+		for i, event := range events {
+			Expect(event.Chain).NotTo(BeNil())
+			Expect(event.Chain.Sequence).To(BeEquivalentTo(i + 1))
+			if i > 0 {
+				Expect(event.Chain.PrevHash).To(Equal(events[i-1].Chain.Hash))
+			}
+		}
+		Expect(events[2].Action).To(Equal(ActionCheckpoint))
+		Expect(events[5].Action).To(Equal(ActionCheckpoint))
+
+		report := verify(lines, publicKey)
+		Expect(report.OK()).To(BeTrue(), "%v", report.Problems)
+		Expect(report.Events).To(Equal(6))
+		Expect(report.Unsigned).To(BeEmpty())
+	})
+
+	It("Should report an altered event", func() {
+		lines[1] = strings.Replace(lines[1], "deploy-1", "deploy-x", 1)
+
+		report := verify(lines, publicKey)
+		Expect(report.Problems).To(ConsistOf(ContainSubstring("sequence 2 was altered")))
+	})
+
+	It("Should report a removed event", func() {
+		lines = append(lines[:1], lines[2:]...)
+
+		report := verify(lines, publicKey)
+		Expect(report.Problems).To(ConsistOf(ContainSubstring("gap, events 2 to 2 missing")))
+	})
+
+	It("Should report a log with its head removed", func() {
+		report := verify(lines[2:], publicKey)
+		Expect(report.OK()).To(BeFalse())
+		Expect(report.Problems).To(ConsistOf(ContainSubstring("starts at sequence 3, events 1 to 2 missing")))
+	})
+
+	It("Should verify a log starting in the middle of a chain when allowed", func() {
+		verifyPartial := func(lines []string) *ChainReport {
+			report, err := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), publicKey, true)
+			Expect(err).NotTo(HaveOccurred())
+			return report
+		}
+
+		report := verifyPartial(lines[3:])
+		Expect(report.OK()).To(BeTrue(), "%v", report.Problems)
+		Expect(report.Events).To(Equal(3))
+		Expect(report.Partial).To(ConsistOf(ContainSubstring("starts at sequence 4")))
+		Expect(report.Unsigned).To(BeEmpty())
+
+		By("Still reporting gaps after its first event")
+		report = verifyPartial(append(lines[3:4], lines[5:]...))
+		Expect(report.Problems).To(ConsistOf(ContainSubstring("gap, events 5 to 5 missing")))
+	})
+
+	It("Should report checkpoints not signed by the key", func() {
+		otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		report := verify(lines, otherKey)
+		Expect(report.Problems).To(HaveLen(2))
+		Expect(report.Problems[0]).To(ContainSubstring("invalid signature"))
+	})
+
+	It("Should skip lines that are not chained events", func() {
+		report, err := VerifyChain(bytes.NewBufferString("not json\n"+strings.Join(lines, "\n")), publicKey, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeTrue())
+		Expect(report.Skipped).To(Equal(1))
+	})
+})
@@ -0,0 +1,484 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ChatFormatSlack      = "slack"
+	ChatFormatTeams      = "teams"
+	ChatFormatMattermost = "mattermost"
+)
+
+// ChatConfig configures a ChatSink.
+type ChatConfig struct {
+	// Format of the messages. One of slack, teams, mattermost
+	Format string `json:"format"`
+	// WebhookURL is the incoming webhook messages are posted to unless a route says otherwise
+	WebhookURL string `json:"webhookURL"`
+	// Channel overrides the default channel of the webhook. Ignored by teams
+	Channel string `json:"channel,omitempty"`
+	// Routes send the events of specific watches to their own channel or webhook
+	Routes []ChatRoute `json:"routes,omitempty"`
+
+	// MaxFields is the number of changed fields shown in a message. Defaults to 5
+	MaxFields int `json:"maxFields,omitempty"`
+	// HistoryURL is a Go template of the link to the history of the resource. e.g
+	// (https://grafana.example.com/explore?namespace={{ .Namespace }}&name={{ .Name }})
+	HistoryURL string `json:"historyURL,omitempty"`
+
+	// GroupWindow is how long events of the same resource are collected into a single message. Defaults to 10s
+	GroupWindow metav1.Duration `json:"groupWindow,omitempty"`
+	// RateLimit is the maximum number of messages per second sent to a webhook. Defaults to 1
+	RateLimit float64 `json:"rateLimit,omitempty"`
+	// QueueSize is the number of events buffered while waiting to be grouped. Defaults to 1000
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout of a single request. Defaults to 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ChatRoute sends the events of a watch to a specific channel or webhook.
+type ChatRoute struct {
+	// Watch is the namespace/name of the watch
+	Watch string `json:"watch"`
+	// Channel the events are sent to. Ignored by teams
+	Channel string `json:"channel,omitempty"`
+	// WebhookURL the events are sent to. Defaults to the sink webhook
+	WebhookURL string `json:"webhookURL,omitempty"`
+}
+
+// ChatSink is a Provider that notifies chat channels of audit events. Bursts of events on the same resource are
+// grouped into a single message. Events are queued by Log and sent by Start, which must be running. The limiters are
+// only used by the goroutine of Start sending messages.
+type ChatSink struct {
+	cfg        ChatConfig
+	client     *http.Client
+	historyURL *template.Template
+	queue      chan AuditEvent
+	limiters   map[string]*rate.Limiter
+}
+
+// chatGroup collects the events of a resource headed to the same destination.
+type chatGroup struct {
+	destination ChatRoute
+	events      []AuditEvent
+	since       time.Time
+}
+
+func NewChatSink(cfg ChatConfig) (*ChatSink, error) {
+	switch cfg.Format {
+	case ChatFormatSlack, ChatFormatTeams, ChatFormatMattermost:
+	default:
+		return nil, fmt.Errorf("unsupported chat format %q", cfg.Format)
+	}
+	if cfg.WebhookURL == "" {
+		return nil, fmt.Errorf("chat sink webhookURL is required")
+	}
+	if cfg.MaxFields <= 0 {
+		cfg.MaxFields = 5
+	}
+	if cfg.GroupWindow.Duration <= 0 {
+		cfg.GroupWindow.Duration = 10 * time.Second
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+
+	sink := &ChatSink{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout.Duration},
+		queue:    make(chan AuditEvent, cfg.QueueSize),
+		limiters: map[string]*rate.Limiter{},
+	}
+
+	if cfg.HistoryURL != "" {
+		var err error
+		if sink.historyURL, err = template.New("history").Parse(cfg.HistoryURL); err != nil {
+			return nil, fmt.Errorf("invalid chat sink historyURL: %w", err)
+		}
+	}
+
+	return sink, nil
+}
+
+// Log queues event to be grouped and sent. Events are dropped if the queue is full.
+func (c *ChatSink) Log(event AuditEvent) {
+	if event.Action == ActionCheckpoint {
+		return
+	}
+
+	select {
+	case c.queue <- event:
+	default:
+		loghandlerlog.Info("Chat sink queue full, dropping event", "Name", event.Name, "Namespace", event.Namespace)
+	}
+}
+
+// Start groups queued events and hands a group to be sent once its window is over, until ctx is done, then sends every
+// group and queued event left. Messages are sent by a goroutine of their own so grouping keeps draining the queue
+// while a webhook is rate limited.
+func (c *ChatSink) Start(ctx context.Context) error {
+	groups := map[string]*chatGroup{}
+	var ready []*chatGroup
+	ticker := time.NewTicker(c.cfg.GroupWindow.Duration / 4)
+	defer ticker.Stop()
+
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	defer cancelSends()
+	sends := make(chan *chatGroup)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for group := range sends {
+			c.send(sendCtx, group)
+		}
+	}()
+
+	flush := func(all bool) {
+		for key, group := range groups {
+			if !all && time.Since(group.since) < c.cfg.GroupWindow.Duration {
+				continue
+			}
+			delete(groups, key)
+			ready = append(ready, group)
+		}
+	}
+
+	add := func(event AuditEvent) {
+		destination := c.route(event.Watch)
+		key := strings.Join([]string{destination.WebhookURL, destination.Channel, event.Kind, event.Namespace, event.Name}, "|")
+		group, ok := groups[key]
+		if !ok {
+			group = &chatGroup{destination: destination, since: time.Now()}
+			groups[key] = group
+		}
+		group.events = append(group.events, event)
+	}
+
+	for {
+		// only offer a group to the sender while one is ready
+		var next chan<- *chatGroup
+		var head *chatGroup
+		if len(ready) > 0 {
+			next, head = sends, ready[0]
+		}
+
+		select {
+		case event := <-c.queue:
+			add(event)
+
+		case next <- head:
+			ready = ready[1:]
+
+		case <-ticker.C:
+			flush(false)
+
+		case <-ctx.Done():
+			// the events still queued are sent as well
+			for len(c.queue) > 0 {
+				add(<-c.queue)
+			}
+			flush(true)
+			timeout := time.AfterFunc(c.cfg.Timeout.Duration, cancelSends)
+			defer timeout.Stop()
+			for _, group := range ready {
+				sends <- group
+			}
+			close(sends)
+			<-sent
+			return nil
+		}
+	}
+}
+
+func (c *ChatSink) route(watch string) ChatRoute {
+	destination := ChatRoute{Channel: c.cfg.Channel, WebhookURL: c.cfg.WebhookURL}
+	for _, route := range c.cfg.Routes {
+		if route.Watch != watch {
+			continue
+		}
+		if route.Channel != "" {
+			destination.Channel = route.Channel
+		}
+		if route.WebhookURL != "" {
+			destination.WebhookURL = route.WebhookURL
+		}
+		break
+	}
+	return destination
+}
+
+func (c *ChatSink) send(ctx context.Context, group *chatGroup) {
+	summary := c.summarize(group)
+
+	var message interface{}
+	switch c.cfg.Format {
+	case ChatFormatSlack:
+		message = slackMessage(summary, group.destination.Channel)
+	case ChatFormatMattermost:
+		message = mattermostMessage(summary, group.destination.Channel)
+	case ChatFormatTeams:
+		message = teamsMessage(summary)
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		loghandlerlog.Error(err, "Failed to render chat message")
+		return
+	}
+
+	limiter, ok := c.limiters[group.destination.WebhookURL]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(c.cfg.RateLimit), 1)
+		c.limiters[group.destination.WebhookURL] = limiter
+	}
+	if err = limiter.Wait(ctx); err != nil {
+		loghandlerlog.Error(err, "Chat message dropped while rate limited", "Resource", summary.resource())
+		return
+	}
+
+	if err = c.post(ctx, group.destination.WebhookURL, body); err != nil {
+		loghandlerlog.Error(err, "Failed to send chat message", "Resource", summary.resource())
+	}
+}
+
+func (c *ChatSink) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// chatSummary is what a message says about a group of events.
+type chatSummary struct {
+	Kind, Name, Namespace, Watch string
+	Actions, Actors              []string
+	Events                       int
+	Changes                      []Change
+	MoreChanges                  int
+	HistoryURL                   string
+}
+
+func (s chatSummary) resource() string {
+	return fmt.Sprintf("%s %s/%s", s.Kind, s.Namespace, s.Name)
+}
+
+func (s chatSummary) title() string {
+	title := fmt.Sprintf("%s %s", s.resource(), strings.Join(s.Actions, ", "))
+	if s.Events > 1 {
+		title = fmt.Sprintf("%s (%d events)", title, s.Events)
+	}
+	return title
+}
+
+func (s chatSummary) facts() [][2]string {
+	facts := [][2]string{{"Resource", s.Kind + "/" + s.Name}, {"Namespace", s.Namespace}}
+	if len(s.Actors) > 0 {
+		facts = append(facts, [2]string{"Actor", strings.Join(s.Actors, ", ")})
+	}
+	if s.Watch != "" {
+		facts = append(facts, [2]string{"Watch", s.Watch})
+	}
+	return facts
+}
+
+func (s chatSummary) changeLines(code string) []string {
+	lines := make([]string, 0, len(s.Changes)+1)
+	for _, change := range s.Changes {
+		switch change.Op() {
+		case OperationAdd:
+			lines = append(lines, fmt.Sprintf("%s%s%s added: %s%s%s", code, change.Path(), code, code, change.NewValue(), code))
+		case OperationRemove:
+			lines = append(lines, fmt.Sprintf("%s%s%s removed (was %s%s%s)", code, change.Path(), code, code, change.OldValue(), code))
+		default:
+			lines = append(lines, fmt.Sprintf("%s%s%s: %s%s%s → %s%s%s", code, change.Path(), code, code, change.OldValue(), code, code, change.NewValue(), code))
+		}
+	}
+	if s.MoreChanges > 0 {
+		lines = append(lines, fmt.Sprintf("and %d more field(s)", s.MoreChanges))
+	}
+	return lines
+}
+
+// summarize merges the events of group. A field changed by several events shows its first old and last new value.
+func (c *ChatSink) summarize(group *chatGroup) chatSummary {
+	first := group.events[0]
+	summary := chatSummary{Kind: first.Kind, Name: first.Name, Namespace: first.Namespace, Watch: first.Watch, Events: len(group.events)}
+
+	var changes []Change
+	byPath := map[string]int{}
+	for _, event := range group.events {
+		summary.Actions = appendUnique(summary.Actions, event.Action)
+		if event.Actor != "" {
+			summary.Actors = appendUnique(summary.Actors, event.Actor)
+		}
+
+		for _, change := range event.Changes {
+			i, ok := byPath[change.Path()]
+			if !ok {
+				byPath[change.Path()] = len(changes)
+				changes = append(changes, change)
+				continue
+			}
+			merged := changes[i]
+			merged.newValue = change.newValue
+			switch {
+			case merged.oldValue == nil:
+				merged.op = OperationAdd
+			case merged.newValue == nil:
+				merged.op = OperationRemove
+			default:
+				merged.op = OperationReplace
+			}
+			changes[i] = merged
+		}
+	}
+
+	// drop fields added then removed within the group
+	kept := changes[:0]
+	for _, change := range changes {
+		if change.oldValue != nil || change.newValue != nil {
+			kept = append(kept, change)
+		}
+	}
+	changes = kept
+
+	if len(changes) > c.cfg.MaxFields {
+		summary.MoreChanges = len(changes) - c.cfg.MaxFields
+		changes = changes[:c.cfg.MaxFields]
+	}
+	summary.Changes = changes
+	sort.Strings(summary.Actors)
+
+	if c.historyURL != nil {
+		buf := &bytes.Buffer{}
+		if err := c.historyURL.Execute(buf, first); err != nil {
+			loghandlerlog.Error(err, "Failed to render chat history url")
+		} else {
+			summary.HistoryURL = buf.String()
+		}
+	}
+
+	return summary
+}
+
+func slackMessage(s chatSummary, channel string) map[string]interface{} {
+	fields := []map[string]string{}
+	for _, fact := range s.facts() {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", fact[0], fact[1])})
+	}
+
+	blocks := []interface{}{
+		map[string]interface{}{"type": "header", "text": map[string]string{"type": "plain_text", "text": s.title()}},
+		map[string]interface{}{"type": "section", "fields": fields},
+	}
+	if lines := s.changeLines("`"); len(lines) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section", "text": map[string]string{"type": "mrkdwn", "text": "• " + strings.Join(lines, "\n• ")},
+		})
+	}
+	if s.HistoryURL != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": fmt.Sprintf("<%s|View history>", s.HistoryURL)}},
+		})
+	}
+
+	message := map[string]interface{}{"text": s.title(), "blocks": blocks}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	return message
+}
+
+func mattermostMessage(s chatSummary, channel string) map[string]interface{} {
+	fields := []map[string]interface{}{}
+	for _, fact := range s.facts() {
+		fields = append(fields, map[string]interface{}{"title": fact[0], "value": fact[1], "short": true})
+	}
+
+	attachment := map[string]interface{}{
+		"fallback": s.title(),
+		"title":    s.title(),
+		"fields":   fields,
+		"text":     strings.Join(s.changeLines("`"), "\n"),
+	}
+	if s.HistoryURL != "" {
+		attachment["title_link"] = s.HistoryURL
+	}
+
+	message := map[string]interface{}{"attachments": []interface{}{attachment}}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	return message
+}
+
+func teamsMessage(s chatSummary) map[string]interface{} {
+	facts := []map[string]string{}
+	for _, fact := range s.facts() {
+		facts = append(facts, map[string]string{"title": fact[0], "value": fact[1]})
+	}
+
+	body := []interface{}{
+		map[string]interface{}{"type": "TextBlock", "text": s.title(), "weight": "Bolder", "size": "Medium", "wrap": true},
+		map[string]interface{}{"type": "FactSet", "facts": facts},
+	}
+	if lines := s.changeLines("`"); len(lines) > 0 {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": "- " + strings.Join(lines, "\n- "), "wrap": true})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if s.HistoryURL != "" {
+		card["actions"] = []interface{}{map[string]string{"type": "Action.OpenUrl", "title": "View history", "url": s.HistoryURL}}
+	}
+
+	return map[string]interface{}{
+		"type":        "message",
+		"attachments": []interface{}{map[string]interface{}{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
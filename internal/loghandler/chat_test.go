@@ -0,0 +1,140 @@
+package loghandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ChatSink", func() {
+	var (
+		server   *httptest.Server
+		mu       sync.Mutex
+		messages []map[string]interface{}
+		cancel   context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		messages = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			message := map[string]interface{}{}
+			Expect(json.Unmarshal(body, &message)).To(Succeed())
+			mu.Lock()
+			messages = append(messages, message)
+			mu.Unlock()
+		}))
+	})
+
+	AfterEach(func() {
+		cancel()
+		server.Close()
+	})
+
+	start := func(cfg ChatConfig) *ChatSink {
+		cfg.WebhookURL = server.URL
+		cfg.GroupWindow = metav1.Duration{Duration: 200 * time.Millisecond}
+		if cfg.RateLimit == 0 {
+			cfg.RateLimit = 100
+		}
+		sink, err := NewChatSink(cfg)
+		Expect(err).NotTo(HaveOccurred())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+		}()
+		return sink
+	}
+
+	received := func() []map[string]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]interface{}{}, messages...)
+	}
+
+	It("Should group a burst of events on a resource into a single Slack message routed by watch", func() {
+		sink := start(ChatConfig{
+			Format:     ChatFormatSlack,
+			Channel:    "#audit",
+			Routes:     []ChatRoute{{Watch: "default/prod", Channel: "#prod-changes"}},
+			MaxFields:  1,
+			HistoryURL: "https://history.example.com/{{ .Namespace }}/{{ .Name }}",
+		})
+
+		first := makeEvent("deploy-1", "Update")
+		first.Watch, first.Actor = "default/prod", "kubectl-edit"
+		second := makeEvent("deploy-1", "Update")
+		second.Watch, second.Actor = "default/prod", "helm"
+		data := NewData("Deployment")
+		Expect(data.AddChange(".spec.replicas", 3, 5)).To(Succeed())
+		Expect(data.AddChange(".spec.paused", nil, true)).To(Succeed())
+		second.Changes = data.Changes()
+
+		sink.Log(first)
+		sink.Log(second)
+
+		Eventually(received).Should(HaveLen(1))
+		Consistently(received, 300*time.Millisecond).Should(HaveLen(1))
+
+		raw, err := json.Marshal(received()[0])
+		Expect(err).NotTo(HaveOccurred())
+		message := string(raw)
+		Expect(message).To(ContainSubstring(`"channel":"#prod-changes"`))
+		Expect(message).To(ContainSubstring("Deployment ns-1/deploy-1 Update (2 events)"))
+		Expect(message).To(ContainSubstring("helm, kubectl-edit"))
+		Expect(message).To(ContainSubstring("`.spec.replicas`: `1` → `5`"))
+		Expect(message).To(ContainSubstring("and 1 more field(s)"))
+		Expect(message).To(ContainSubstring("https://history.example.com/ns-1/deploy-1|View history"))
+	})
+
+	It("Should keep grouping a burst of events while messages are rate limited", func() {
+		sink := start(ChatConfig{Format: ChatFormatSlack, RateLimit: 10, QueueSize: 5})
+
+		// each batch fits the queue, but the messages of all of them take seconds to be sent
+		for batch := 0; batch < 6; batch++ {
+			for i := 0; i < 5; i++ {
+				sink.Log(makeEvent(fmt.Sprintf("deploy-%d-%d", batch, i), "Update"))
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		Eventually(received, 10*time.Second).Should(HaveLen(30))
+	})
+
+	It("Should send the events still queued on shutdown", func() {
+		sink, err := NewChatSink(ChatConfig{Format: ChatFormatSlack, WebhookURL: server.URL, RateLimit: 100})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Log(makeEvent("deploy-1", "Update"))
+		sink.Log(makeEvent("deploy-2", "Update"))
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		Expect(sink.Start(ctx)).To(Succeed())
+		Expect(received()).To(HaveLen(2))
+	})
+
+	It("Should send Teams messages as Adaptive Cards", func() {
+		sink := start(ChatConfig{Format: ChatFormatTeams})
+		sink.Log(makeEvent("deploy-1", "Update"))
+
+		Eventually(received).Should(HaveLen(1))
+		attachments := received()[0]["attachments"].([]interface{})
+		Expect(attachments).To(HaveLen(1))
+		attachment := attachments[0].(map[string]interface{})
+		Expect(attachment["contentType"]).To(Equal("application/vnd.microsoft.card.adaptive"))
+		Expect(attachment["content"]).To(HaveKeyWithValue("type", "AdaptiveCard"))
+	})
+})
@@ -0,0 +1,153 @@
+package loghandler
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+)
+
+const (
+	// CloudEventsModeStructured carries the whole CloudEvent, attributes and data, as the JSON message body
+	CloudEventsModeStructured = "structured"
+	// CloudEventsModeBinary carries the attributes as message headers and the AuditEvent as the message body
+	CloudEventsModeBinary = "binary"
+
+	CloudEventsContentType      = "application/cloudevents+json"
+	CloudEventsBatchContentType = "application/cloudevents-batch+json"
+)
+
+// cloudEventsResources are the API paths of the kinds subjects are built for. Other kinds get their lower cased plural.
+var cloudEventsResources = map[string]string{
+	"Pod":                     "api/v1/pods",
+	"Service":                 "api/v1/services",
+	"ConfigMap":               "api/v1/configmaps",
+	"Secret":                  "api/v1/secrets",
+	"Deployment":              "apis/apps/v1/deployments",
+	"ReplicaSet":              "apis/apps/v1/replicasets",
+	"StatefulSet":             "apis/apps/v1/statefulsets",
+	"DaemonSet":               "apis/apps/v1/daemonsets",
+	"HorizontalPodAutoscaler": "apis/autoscaling/v2/horizontalpodautoscalers",
+	"PodDisruptionBudget":     "apis/policy/v1/poddisruptionbudgets",
+	"Ingress":                 "apis/networking.k8s.io/v1/ingresses",
+}
+
+// CloudEventsConfig encodes the events of a sink as CloudEvents v1.0.
+type CloudEventsConfig struct {
+	// Mode is one of structured, binary. Defaults to structured
+	Mode string `json:"mode,omitempty"`
+}
+
+// validate defaults and checks c. Nil if c is nil, as CloudEvents are disabled.
+func (c *CloudEventsConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Mode {
+	case "":
+		c.Mode = CloudEventsModeStructured
+	case CloudEventsModeStructured, CloudEventsModeBinary:
+	default:
+		return fmt.Errorf("unsupported cloudevents mode %q", c.Mode)
+	}
+	return nil
+}
+
+// Binary reports whether c enables CloudEvents in binary mode.
+func (c *CloudEventsConfig) Binary() bool {
+	return c != nil && c.Mode == CloudEventsModeBinary
+}
+
+// CloudEvent is the CloudEvents v1.0 envelope of an AuditEvent.
+type CloudEvent struct {
+	SpecVersion string `json:"specversion"`
+	// ID is the deduplication ID of the event
+	ID string `json:"id"`
+	// Source is the API path of the watch the event was audited for.
+	// e.g (/apis/audit.my.domain/v1alpha1/namespaces/ns-1/watches/my-watch)
+	Source string `json:"source"`
+	// Type is the reversed API group, the kind and the action in the past tense. e.g (domain.my.audit.deployment.updated)
+	Type string `json:"type"`
+	// Subject is the API path of the audited object. e.g (/apis/apps/v1/namespaces/ns-1/deployments/app)
+	Subject         string     `json:"subject,omitempty"`
+	Time            time.Time  `json:"time"`
+	DataContentType string     `json:"datacontenttype"`
+	Data            AuditEvent `json:"data"`
+}
+
+// NewCloudEvent wraps event in its CloudEvent envelope.
+func NewCloudEvent(event AuditEvent) CloudEvent {
+	group := strings.Split(auditv1alpha1.GroupVersion.Group, ".")
+	slices.Reverse(group)
+
+	source := "/apis/" + auditv1alpha1.GroupVersion.String()
+	if namespace, name, ok := strings.Cut(event.Watch, "/"); ok {
+		source += fmt.Sprintf("/namespaces/%s/watches/%s", namespace, name)
+	}
+
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              event.ID(),
+		Source:          source,
+		Type:            strings.Join(group, ".") + "." + strings.ToLower(event.Kind) + "." + pastTense(event.Action),
+		Subject:         cloudEventSubject(event),
+		Time:            event.Timestamp,
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
+
+// Attributes returns the context attributes of c in their binary mode order, without the header prefix.
+func (c CloudEvent) Attributes() [][2]string {
+	attributes := [][2]string{
+		{"specversion", c.SpecVersion},
+		{"id", c.ID},
+		{"source", c.Source},
+		{"type", c.Type},
+	}
+	if c.Subject != "" {
+		attributes = append(attributes, [2]string{"subject", c.Subject})
+	}
+	return append(attributes, [2]string{"time", c.Time.UTC().Format(time.RFC3339Nano)})
+}
+
+// cloudEventSubject returns the API path of the object of event. Empty for events without an object, e.g checkpoints.
+func cloudEventSubject(event AuditEvent) string {
+	if event.Name == "" {
+		return ""
+	}
+
+	resource, ok := cloudEventsResources[event.Kind]
+	if !ok {
+		resource = strings.ToLower(event.Kind) + "s"
+	}
+
+	var path string
+	if i := strings.LastIndex(resource, "/"); i >= 0 {
+		path, resource = "/"+resource[:i], resource[i+1:]
+	}
+	if event.Namespace != "" {
+		path += "/namespaces/" + event.Namespace
+	}
+	return path + "/" + resource + "/" + event.Name
+}
+
+// outcome matches the actions naming an outcome rather than a verb to inflect, one of their words being in the past
+// tense. e.g (RolledBack, EndpointsDrained)
+var outcome = regexp.MustCompile(`[^e]ed([A-Z]|$)`)
+
+// pastTense returns action lower cased in the past tense. e.g (Update: updated, Drift: drifted). Actions naming an
+// outcome are lower cased as is. e.g (RolledBack: rolledback)
+func pastTense(action string) string {
+	lower := strings.ToLower(action)
+	switch {
+	case outcome.MatchString(action):
+		return lower
+	case strings.HasSuffix(lower, "e"):
+		return lower + "d"
+	}
+	return lower + "ed"
+}
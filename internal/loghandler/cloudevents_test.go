@@ -0,0 +1,63 @@
+package loghandler
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CloudEvent", func() {
+	It("Should derive the type, source and subject from the event", func() {
+		event := makeEvent("app-1", "Update")
+		event.ResourceVersion = "42"
+		event.Watch = "ns-1/my-watch"
+		event.Timestamp = time.Date(2024, 10, 18, 9, 15, 0, 0, time.UTC)
+
+		cloudEvent := NewCloudEvent(event)
+		Expect(cloudEvent.SpecVersion).To(Equal("1.0"))
+		Expect(cloudEvent.ID).To(Equal("uid-app-1:42:Update@ns-1/my-watch"))
+		Expect(cloudEvent.Type).To(Equal("domain.my.audit.deployment.updated"))
+		Expect(cloudEvent.Source).To(Equal("/apis/audit.my.domain/v1alpha1/namespaces/ns-1/watches/my-watch"))
+		Expect(cloudEvent.Subject).To(Equal("/apis/apps/v1/namespaces/ns-1/deployments/app-1"))
+
+		raw, err := json.Marshal(cloudEvent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(raw)).To(HavePrefix(`{"specversion":"1.0","id":"uid-app-1:42:Update@ns-1/my-watch",`))
+		Expect(string(raw)).To(ContainSubstring(`"time":"2024-10-18T09:15:00Z","datacontenttype":"application/json","data":{"kind":"Deployment"`))
+	})
+
+	It("Should build the subject of core, cluster scoped and unknown kinds", func() {
+		Expect(NewCloudEvent(AuditEvent{Kind: "Service", Name: "web", Namespace: "ns-1", Action: "Create"}).Subject).
+			To(Equal("/api/v1/namespaces/ns-1/services/web"))
+		Expect(NewCloudEvent(AuditEvent{Kind: "Namespace", Name: "ns-1", Action: "Delete"}).Subject).
+			To(Equal("/namespaces/ns-1"))
+
+		checkpoint := NewCloudEvent(AuditEvent{Kind: "Chain", Action: ActionCheckpoint, Chain: &ChainLink{ID: "chain", Sequence: 7}})
+		Expect(checkpoint.Subject).To(BeEmpty())
+		Expect(checkpoint.Type).To(Equal("domain.my.audit.chain.checkpointed"))
+		Expect(checkpoint.Source).To(Equal("/apis/audit.my.domain/v1alpha1"))
+	})
+
+	DescribeTable("Should name the type after the action in the past tense",
+		func(action, suffix string) {
+			Expect(NewCloudEvent(AuditEvent{Kind: "Deployment", Name: "app-1", Action: action}).Type).
+				To(Equal("domain.my.audit.deployment." + suffix))
+		},
+		Entry("Create", "Create", "created"),
+		Entry("Update", "Update", "updated"),
+		Entry("Delete", "Delete", "deleted"),
+		Entry("Checkpoint", ActionCheckpoint, "checkpointed"),
+		Entry("Drift", "Drift", "drifted"),
+		Entry("Converge", "Converge", "converged"),
+		Entry("Revert", "Revert", "reverted"),
+		Entry("RolloutSucceeded", "RolloutSucceeded", "rolloutsucceeded"),
+		Entry("RolloutStalled", "RolloutStalled", "rolloutstalled"),
+		Entry("RolledBack", "RolledBack", "rolledback"),
+		Entry("EndpointsDrained", "EndpointsDrained", "endpointsdrained"),
+		Entry("EndpointsRestored", "EndpointsRestored", "endpointsrestored"),
+		Entry("an unknown verb", "Scale", "scaled"),
+		Entry("an unknown outcome", "Scaled", "scaled"),
+	)
+})
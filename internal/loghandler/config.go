@@ -0,0 +1,455 @@
+package loghandler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	SinkTypeConsole    = "console"
+	SinkTypeHTTP       = "http"
+	SinkTypeChat       = "chat"
+	SinkTypeKafka      = "kafka"
+	SinkTypeNATS       = "nats"
+	SinkTypeOpenSearch = "opensearch"
+	SinkTypeLoki       = "loki"
+	SinkTypeOTLP       = "otlp"
+	SinkTypeS3         = "s3"
+	SinkTypeFile       = "file"
+	SinkTypeSyslog     = "syslog"
+)
+
+// Config is the sinks configuration file of the manager.
+type Config struct {
+	Sinks []SinkConfig `json:"sinks"`
+}
+
+// SinkConfig configures a single sink. Only the configuration matching Type is used.
+type SinkConfig struct {
+	// Name identifies the sink
+	Name string `json:"name"`
+	// Type of the sink. e.g (console, http, chat, kafka, nats, opensearch, loki, otlp, s3, file, syslog)
+	Type string `json:"type"`
+
+	HTTP       *HTTPConfig       `json:"http,omitempty"`
+	Chat       *ChatConfig       `json:"chat,omitempty"`
+	Kafka      *KafkaConfig      `json:"kafka,omitempty"`
+	NATS       *NATSConfig       `json:"nats,omitempty"`
+	OpenSearch *OpenSearchConfig `json:"opensearch,omitempty"`
+	Loki       *LokiConfig       `json:"loki,omitempty"`
+	OTLP       *OTLPConfig       `json:"otlp,omitempty"`
+	S3         *S3Config         `json:"s3,omitempty"`
+	File       *FileConfig       `json:"file,omitempty"`
+	Syslog     *SyslogConfig     `json:"syslog,omitempty"`
+
+	// Filter restricts the events sent to the sink
+	Filter *FilterConfig `json:"filter,omitempty"`
+}
+
+// LoadConfig reads the YAML or JSON sinks configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err = yaml.UnmarshalStrict(raw, cfg); err != nil {
+		return nil, fmt.Errorf("invalid sinks config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// NewSinkConfig creates the configuration of the sink name of type sinkType from raw, the JSON configuration of the
+// type. e.g ({"url": "https://audit.example.com/events"} for http)
+func NewSinkConfig(name, sinkType string, raw []byte) (SinkConfig, error) {
+	doc := map[string]json.RawMessage{}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		doc[sinkType] = raw
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return SinkConfig{}, err
+	}
+
+	cfg := SinkConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&cfg); err != nil {
+		return SinkConfig{}, fmt.Errorf("invalid %s sink config: %w", sinkType, err)
+	}
+	cfg.Name, cfg.Type = name, sinkType
+	return cfg, nil
+}
+
+// NewSink creates the sink described by cfg, filtered if cfg has a filter. chain, if not nil, puts the sink behind a
+// hash chain of its own, e.g NewHashChain, past the filter so the sink receives a chain without gaps.
+func NewSink(cfg SinkConfig, chain func(next Provider) (Provider, error)) (Provider, error) {
+	sink, err := newSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if chain != nil {
+		if sink, err = chain(sink); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Filter == nil {
+		return sink, nil
+	}
+	return NewFilter(sink, *cfg.Filter), nil
+}
+
+func newSink(cfg SinkConfig) (Provider, error) {
+	switch cfg.Type {
+	case SinkTypeConsole:
+		return NewConsole(), nil
+
+	case SinkTypeHTTP:
+		if cfg.HTTP == nil {
+			return nil, fmt.Errorf("sink %s: http config is required", cfg.Name)
+		}
+		return NewHTTPSink(*cfg.HTTP)
+
+	case SinkTypeChat:
+		if cfg.Chat == nil {
+			return nil, fmt.Errorf("sink %s: chat config is required", cfg.Name)
+		}
+		return NewChatSink(*cfg.Chat)
+
+	case SinkTypeKafka:
+		if cfg.Kafka == nil {
+			return nil, fmt.Errorf("sink %s: kafka config is required", cfg.Name)
+		}
+		return NewKafkaSink(*cfg.Kafka)
+
+	case SinkTypeNATS:
+		if cfg.NATS == nil {
+			return nil, fmt.Errorf("sink %s: nats config is required", cfg.Name)
+		}
+		return NewNATSSink(*cfg.NATS)
+
+	case SinkTypeOpenSearch:
+		if cfg.OpenSearch == nil {
+			return nil, fmt.Errorf("sink %s: opensearch config is required", cfg.Name)
+		}
+		return NewOpenSearchSink(*cfg.OpenSearch)
+
+	case SinkTypeLoki:
+		if cfg.Loki == nil {
+			return nil, fmt.Errorf("sink %s: loki config is required", cfg.Name)
+		}
+		return NewLokiSink(*cfg.Loki)
+
+	case SinkTypeOTLP:
+		if cfg.OTLP == nil {
+			return nil, fmt.Errorf("sink %s: otlp config is required", cfg.Name)
+		}
+		return NewOTLPSink(*cfg.OTLP)
+
+	case SinkTypeS3:
+		if cfg.S3 == nil {
+			return nil, fmt.Errorf("sink %s: s3 config is required", cfg.Name)
+		}
+		return NewS3Sink(*cfg.S3)
+
+	case SinkTypeFile:
+		if cfg.File == nil {
+			return nil, fmt.Errorf("sink %s: file config is required", cfg.Name)
+		}
+		return NewFileSink(*cfg.File)
+
+	case SinkTypeSyslog:
+		if cfg.Syslog == nil {
+			return nil, fmt.Errorf("sink %s: syslog config is required", cfg.Name)
+		}
+		return NewSyslogSink(*cfg.Syslog)
+
+	default:
+		return nil, fmt.Errorf("sink %s: unsupported type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// Credential keys read from SinkSettings.Credentials.
+const (
+	CredentialUsername        = "username"
+	CredentialPassword        = "password"
+	CredentialToken           = "token"
+	CredentialHMACSecret      = "hmacSecret"
+	CredentialWebhookURL      = "webhookURL"
+	CredentialAccessKeyID     = "accessKeyID"
+	CredentialSecretAccessKey = "secretAccessKey"
+	CredentialSessionToken    = "sessionToken"
+	CredentialCACert          = "caCert"
+	CredentialTLSCert         = "tlsCert"
+	CredentialTLSKey          = "tlsKey"
+	CredentialNATSCredentials = "natsCredentials"
+)
+
+// CredentialKeys are the credentials read by SinkConfig.Apply.
+var CredentialKeys = []string{
+	CredentialUsername, CredentialPassword, CredentialToken, CredentialHMACSecret, CredentialWebhookURL,
+	CredentialAccessKeyID, CredentialSecretAccessKey, CredentialSessionToken, CredentialCACert, CredentialTLSCert,
+	CredentialTLSKey, CredentialNATSCredentials,
+}
+
+// SinkSettings are the settings shared by sink types, e.g from an AuditSink resource. Zero values leave the
+// configuration of the sink as is.
+type SinkSettings struct {
+	// Endpoint is the URL, address, path or comma separated brokers of the sink, depending on its type
+	Endpoint string
+	// Credentials by key. e.g (username, password, token)
+	Credentials   map[string]string
+	BatchSize     int
+	FlushInterval time.Duration
+	QueueSize     int
+}
+
+// Apply sets settings on the configuration of the type of c, created if missing. Settings a type has no use for are
+// ignored.
+func (c *SinkConfig) Apply(settings SinkSettings) error {
+	creds := settings.Credentials
+	batching := func(batchSize *int, flushInterval *metav1.Duration, queueSize *int) {
+		if batchSize != nil && settings.BatchSize > 0 {
+			*batchSize = settings.BatchSize
+		}
+		if flushInterval != nil && settings.FlushInterval > 0 {
+			flushInterval.Duration = settings.FlushInterval
+		}
+		if queueSize != nil && settings.QueueSize > 0 {
+			*queueSize = settings.QueueSize
+		}
+	}
+
+	switch c.Type {
+	case SinkTypeConsole:
+
+	case SinkTypeHTTP:
+		if c.HTTP == nil {
+			c.HTTP = &HTTPConfig{}
+		}
+		setString(&c.HTTP.URL, settings.Endpoint)
+		setString(&c.HTTP.HMACSecret, creds[CredentialHMACSecret])
+		c.HTTP.Headers = withAuthorization(c.HTTP.Headers, "Authorization", creds)
+		batching(&c.HTTP.BatchSize, &c.HTTP.FlushInterval, &c.HTTP.QueueSize)
+
+	case SinkTypeChat:
+		if c.Chat == nil {
+			c.Chat = &ChatConfig{}
+		}
+		setString(&c.Chat.WebhookURL, settings.Endpoint)
+		setString(&c.Chat.WebhookURL, creds[CredentialWebhookURL])
+		batching(nil, nil, &c.Chat.QueueSize)
+
+	case SinkTypeKafka:
+		if c.Kafka == nil {
+			c.Kafka = &KafkaConfig{}
+		}
+		if settings.Endpoint != "" {
+			c.Kafka.Brokers = strings.Split(settings.Endpoint, ",")
+		}
+		if creds[CredentialUsername] != "" {
+			if c.Kafka.SASL == nil {
+				c.Kafka.SASL = &KafkaSASLConfig{Mechanism: "SCRAM-SHA-512"}
+			}
+			c.Kafka.SASL.Username, c.Kafka.SASL.Password = creds[CredentialUsername], creds[CredentialPassword]
+		}
+		batching(nil, nil, &c.Kafka.MaxBufferedRecords)
+
+	case SinkTypeNATS:
+		if c.NATS == nil {
+			c.NATS = &NATSConfig{}
+		}
+		setString(&c.NATS.URL, settings.Endpoint)
+		setString(&c.NATS.Token, creds[CredentialToken])
+		setString(&c.NATS.Username, creds[CredentialUsername])
+		setString(&c.NATS.Password, creds[CredentialPassword])
+		setString(&c.NATS.Credentials, creds[CredentialNATSCredentials])
+		batching(nil, nil, &c.NATS.MaxPending)
+
+	case SinkTypeOpenSearch:
+		if c.OpenSearch == nil {
+			c.OpenSearch = &OpenSearchConfig{}
+		}
+		setString(&c.OpenSearch.URL, settings.Endpoint)
+		setString(&c.OpenSearch.Username, creds[CredentialUsername])
+		setString(&c.OpenSearch.Password, creds[CredentialPassword])
+		c.OpenSearch.Headers = withAuthorization(c.OpenSearch.Headers, "Authorization", map[string]string{CredentialToken: creds[CredentialToken]})
+		batching(&c.OpenSearch.BatchSize, &c.OpenSearch.FlushInterval, &c.OpenSearch.QueueSize)
+
+	case SinkTypeLoki:
+		if c.Loki == nil {
+			c.Loki = &LokiConfig{}
+		}
+		setString(&c.Loki.URL, settings.Endpoint)
+		setString(&c.Loki.Username, creds[CredentialUsername])
+		setString(&c.Loki.Password, creds[CredentialPassword])
+		c.Loki.Headers = withAuthorization(c.Loki.Headers, "Authorization", map[string]string{CredentialToken: creds[CredentialToken]})
+		batching(&c.Loki.BatchSize, &c.Loki.FlushInterval, &c.Loki.QueueSize)
+
+	case SinkTypeOTLP:
+		if c.OTLP == nil {
+			c.OTLP = &OTLPConfig{}
+		}
+		setString(&c.OTLP.Endpoint, settings.Endpoint)
+		// Lower cased as gRPC metadata keys
+		c.OTLP.Headers = withAuthorization(c.OTLP.Headers, "authorization", creds)
+		batching(&c.OTLP.BatchSize, &c.OTLP.FlushInterval, &c.OTLP.QueueSize)
+
+	case SinkTypeS3:
+		if c.S3 == nil {
+			c.S3 = &S3Config{}
+		}
+		setString(&c.S3.Endpoint, settings.Endpoint)
+		setString(&c.S3.AccessKeyID, creds[CredentialAccessKeyID])
+		setString(&c.S3.SecretAccessKey, creds[CredentialSecretAccessKey])
+		setString(&c.S3.SessionToken, creds[CredentialSessionToken])
+		batching(nil, nil, &c.S3.QueueSize)
+
+	case SinkTypeFile:
+		if c.File == nil {
+			c.File = &FileConfig{}
+		}
+		setString(&c.File.Path, settings.Endpoint)
+
+	case SinkTypeSyslog:
+		if c.Syslog == nil {
+			c.Syslog = &SyslogConfig{}
+		}
+		setString(&c.Syslog.Address, settings.Endpoint)
+		batching(nil, nil, &c.Syslog.QueueSize)
+
+	default:
+		return fmt.Errorf("sink %s: unsupported type %q", c.Name, c.Type)
+	}
+
+	if tlsConfig := c.tlsConfig(); tlsConfig != nil &&
+		(creds[CredentialCACert] != "" || creds[CredentialTLSCert] != "" || creds[CredentialTLSKey] != "") {
+		if *tlsConfig == nil {
+			*tlsConfig = &TLSConfig{}
+		}
+		setString(&(*tlsConfig).CA, creds[CredentialCACert])
+		setString(&(*tlsConfig).Cert, creds[CredentialTLSCert])
+		setString(&(*tlsConfig).Key, creds[CredentialTLSKey])
+	}
+	return nil
+}
+
+// Confine restricts c to what a sink declared in-cluster, e.g by an AuditSink, may configure: local files are only
+// written under dir, the paths of c being relative to it, and never read. TLS certificates and NATS credentials have
+// to be given as credentials instead of files. Paths are rejected altogether if dir is empty.
+func (c *SinkConfig) Confine(dir string) error {
+	// The default spool directory is shared by every s3 sink, each would upload the files of the others
+	if c.Type == SinkTypeS3 && c.S3 != nil && c.S3.SpoolDir == "" {
+		c.S3.SpoolDir = "s3-" + url.PathEscape(c.Name)
+	}
+
+	for field, path := range c.paths() {
+		if *path == "" {
+			continue
+		}
+		if dir == "" {
+			return fmt.Errorf("sink %s: %s is not allowed", c.Name, field)
+		}
+		if !filepath.IsLocal(*path) {
+			return fmt.Errorf("sink %s: %s %q must be a relative path within the sinks directory", c.Name, field, *path)
+		}
+		*path = filepath.Join(dir, *path)
+	}
+
+	if tlsConfig := c.tlsConfig(); tlsConfig != nil && *tlsConfig != nil {
+		if t := *tlsConfig; t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" {
+			return fmt.Errorf("sink %s: tls files are not allowed, use the %s, %s and %s credentials", c.Name,
+				CredentialCACert, CredentialTLSCert, CredentialTLSKey)
+		}
+	}
+	if c.NATS != nil && c.NATS.CredentialsFile != "" {
+		return fmt.Errorf("sink %s: credentialsFile is not allowed, use the %s credential", c.Name, CredentialNATSCredentials)
+	}
+	return nil
+}
+
+// paths returns the local files and directories written by the sink of the type of c, by field name.
+func (c *SinkConfig) paths() map[string]*string {
+	switch {
+	case c.Type == SinkTypeHTTP && c.HTTP != nil:
+		return map[string]*string{"deadLetterPath": &c.HTTP.DeadLetterPath}
+	case c.Type == SinkTypeKafka && c.Kafka != nil:
+		return map[string]*string{"deadLetterPath": &c.Kafka.DeadLetterPath}
+	case c.Type == SinkTypeNATS && c.NATS != nil:
+		return map[string]*string{"deadLetterPath": &c.NATS.DeadLetterPath}
+	case c.Type == SinkTypeOpenSearch && c.OpenSearch != nil:
+		return map[string]*string{"deadLetterPath": &c.OpenSearch.DeadLetterPath}
+	case c.Type == SinkTypeLoki && c.Loki != nil:
+		return map[string]*string{"deadLetterPath": &c.Loki.DeadLetterPath}
+	case c.Type == SinkTypeOTLP && c.OTLP != nil:
+		return map[string]*string{"deadLetterPath": &c.OTLP.DeadLetterPath}
+	case c.Type == SinkTypeS3 && c.S3 != nil:
+		return map[string]*string{"spoolDir": &c.S3.SpoolDir}
+	case c.Type == SinkTypeFile && c.File != nil:
+		return map[string]*string{"path": &c.File.Path}
+	case c.Type == SinkTypeSyslog && c.Syslog != nil:
+		return map[string]*string{"spoolPath": &c.Syslog.SpoolPath}
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS configuration field of the type of c. Nil if the type has none or is not configured.
+func (c *SinkConfig) tlsConfig() **TLSConfig {
+	switch {
+	case c.Type == SinkTypeHTTP && c.HTTP != nil:
+		return &c.HTTP.TLS
+	case c.Type == SinkTypeKafka && c.Kafka != nil:
+		return &c.Kafka.TLS
+	case c.Type == SinkTypeNATS && c.NATS != nil:
+		return &c.NATS.TLS
+	case c.Type == SinkTypeOpenSearch && c.OpenSearch != nil:
+		return &c.OpenSearch.TLS
+	case c.Type == SinkTypeLoki && c.Loki != nil:
+		return &c.Loki.TLS
+	case c.Type == SinkTypeOTLP && c.OTLP != nil:
+		return &c.OTLP.TLS
+	case c.Type == SinkTypeS3 && c.S3 != nil:
+		return &c.S3.TLS
+	case c.Type == SinkTypeSyslog && c.Syslog != nil:
+		return &c.Syslog.TLS
+	}
+	return nil
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+// withAuthorization returns a copy of headers with the key header set from the token, or the username and password
+// as basic auth, of creds. headers as is if creds have neither.
+func withAuthorization(headers map[string]string, key string, creds map[string]string) map[string]string {
+	var value string
+	switch {
+	case creds[CredentialToken] != "":
+		value = "Bearer " + creds[CredentialToken]
+	case creds[CredentialUsername] != "":
+		value = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds[CredentialUsername]+":"+creds[CredentialPassword]))
+	default:
+		return headers
+	}
+
+	merged := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
@@ -0,0 +1,106 @@
+package loghandler
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SinkConfig", func() {
+	It("Should decode the configuration of the type", func() {
+		cfg, err := NewSinkConfig("team-a/siem", SinkTypeHTTP, []byte(`{"url": "https://audit.example.com", "batchSize": 10}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Name).To(Equal("team-a/siem"))
+		Expect(cfg.HTTP.URL).To(Equal("https://audit.example.com"))
+		Expect(cfg.HTTP.BatchSize).To(Equal(10))
+
+		_, err = NewSinkConfig("team-a/siem", SinkTypeHTTP, []byte(`{"uri": "https://audit.example.com"}`))
+		Expect(err).To(MatchError(ContainSubstring("invalid http sink config")))
+	})
+
+	It("Should apply the endpoint, credentials and batching of the type", func() {
+		cfg, err := NewSinkConfig("team-a/siem", SinkTypeHTTP, []byte(`{"headers": {"X-Team": "a"}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Apply(SinkSettings{
+			Endpoint:      "https://audit.example.com",
+			Credentials:   map[string]string{CredentialToken: "xyz", CredentialHMACSecret: "s3cr3t"},
+			BatchSize:     20,
+			FlushInterval: time.Second,
+		})).To(Succeed())
+		Expect(cfg.HTTP.URL).To(Equal("https://audit.example.com"))
+		Expect(cfg.HTTP.HMACSecret).To(Equal("s3cr3t"))
+		Expect(cfg.HTTP.Headers).To(Equal(map[string]string{"X-Team": "a", "Authorization": "Bearer xyz"}))
+		Expect(cfg.HTTP.BatchSize).To(Equal(20))
+		Expect(cfg.HTTP.FlushInterval.Duration).To(Equal(time.Second))
+
+		kafka, err := NewSinkConfig("team-a/kafka", SinkTypeKafka, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kafka.Apply(SinkSettings{
+			Endpoint:    "kafka-0:9092,kafka-1:9092",
+			Credentials: map[string]string{CredentialUsername: "watchman", CredentialPassword: "s3cr3t"},
+		})).To(Succeed())
+		Expect(kafka.Kafka.Brokers).To(Equal([]string{"kafka-0:9092", "kafka-1:9092"}))
+		Expect(kafka.Kafka.SASL).To(Equal(&KafkaSASLConfig{Mechanism: "SCRAM-SHA-512", Username: "watchman", Password: "s3cr3t"}))
+	})
+
+	It("Should apply the TLS and NATS credentials", func() {
+		cfg, err := NewSinkConfig("team-a/nats", SinkTypeNATS, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Apply(SinkSettings{Credentials: map[string]string{
+			CredentialCACert: "ca", CredentialTLSCert: "cert", CredentialTLSKey: "key", CredentialNATSCredentials: "creds",
+		}})).To(Succeed())
+		Expect(cfg.NATS.TLS).To(Equal(&TLSConfig{CA: "ca", Cert: "cert", Key: "key"}))
+		Expect(cfg.NATS.Credentials).To(Equal("creds"))
+	})
+
+	DescribeTable("Should confine the local files of the sink",
+		func(sinkType, raw, endpoint, dir, expected string) {
+			cfg, err := NewSinkConfig("team-a/sink", sinkType, []byte(raw))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Apply(SinkSettings{Endpoint: endpoint})).To(Succeed())
+
+			err = cfg.Confine(dir)
+			if expected == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("no local file", SinkTypeHTTP, `{"maxRetries": 1}`, "https://audit.example.com", "", ""),
+		Entry("a relative path", SinkTypeFile, ``, "audit/events.ndjson", "/var/lib/watchman/team-a", ""),
+		Entry("a file path without directory", SinkTypeFile, ``, "events.ndjson", "", "path is not allowed"),
+		Entry("an absolute file path", SinkTypeFile, ``, "/etc/passwd", "/var/lib/watchman/team-a", "must be a relative path"),
+		Entry("a file path escaping the directory", SinkTypeFile, ``, "../team-b/events.ndjson", "/var/lib/watchman/team-a", "must be a relative path"),
+		Entry("an absolute dead letter path", SinkTypeHTTP, `{"deadLetterPath": "/var/run/secrets/x"}`, "https://audit.example.com", "/var/lib/watchman/team-a", "must be a relative path"),
+		Entry("an absolute spool path", SinkTypeSyslog, `{"spoolPath": "/tmp/spool"}`, "syslog:6514", "/var/lib/watchman/team-a", "must be a relative path"),
+		Entry("an s3 spool without directory", SinkTypeS3, `{"bucket": "audit"}`, "s3.example.com", "", "spoolDir is not allowed"),
+		Entry("a TLS CA file", SinkTypeHTTP, `{"tls": {"caFile": "/etc/ssl/private/ca.pem"}}`, "https://audit.example.com", "/var/lib/watchman/team-a", "tls files are not allowed"),
+		Entry("a TLS key file", SinkTypeKafka, `{"tls": {"certFile": "/a.crt", "keyFile": "/a.key"}}`, "kafka:9092", "/var/lib/watchman/team-a", "tls files are not allowed"),
+		Entry("a NATS credentials file", SinkTypeNATS, `{"credentialsFile": "/etc/nats/user.creds"}`, "nats://nats:4222", "/var/lib/watchman/team-a", "credentialsFile is not allowed"),
+	)
+
+	It("Should root the local files of the sink in the directory", func() {
+		cfg, err := NewSinkConfig("team-a/archive", SinkTypeS3, []byte(`{"bucket": "audit", "spoolDir": "spool"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Confine("/var/lib/watchman/team-a")).To(Succeed())
+		Expect(cfg.S3.SpoolDir).To(Equal("/var/lib/watchman/team-a/spool"))
+
+		cfg, err = NewSinkConfig("team-a/archive", SinkTypeS3, []byte(`{"bucket": "audit"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Confine("/var/lib/watchman/team-a")).To(Succeed())
+		Expect(cfg.S3.SpoolDir).To(Equal("/var/lib/watchman/team-a/s3-team-a%2Farchive"))
+	})
+
+	It("Should filter the sink created", func() {
+		sink, err := NewSink(SinkConfig{Name: "console", Type: SinkTypeConsole, Filter: &FilterConfig{Actions: []string{"Delete"}}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink).To(BeAssignableToTypeOf(&Filter{}))
+
+		By("Chaining the sink past its filter")
+		chain := func(next Provider) (Provider, error) { return NewHashChain(next, nil, 0) }
+		sink, err = NewSink(SinkConfig{Name: "console", Type: SinkTypeConsole, Filter: &FilterConfig{Actions: []string{"Delete"}}}, chain)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink.(*Filter).provider).To(BeAssignableToTypeOf(&HashChain{}))
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
 )
 
 type Console struct {
@@ -13,7 +14,12 @@ func NewConsole() *Console {
 	return &Console{}
 }
 
-func (c *Console) Log(resource, action, namespace string, data Data) {
-	message := fmt.Sprintf("Resource::%s, action::%s, namespace::%s, %v", resource, action, namespace, data.fields)
+func (c *Console) Log(event AuditEvent) {
+	changes := make([]string, 0, len(event.Changes))
+	for _, change := range event.Changes {
+		changes = append(changes, fmt.Sprintf("%s %s: %s -> %s", change.Op(), change.Path(), change.OldValue(), change.NewValue()))
+	}
+
+	message := fmt.Sprintf("Resource::%s, kind::%s, action::%s, namespace::%s, changes::[%s]", event.Name, event.Kind, event.Action, event.Namespace, strings.Join(changes, ", "))
 	log.FromContext(context.Background()).Info(message)
 }
@@ -7,6 +7,6 @@ func NewCosmosClient() (*CosmosClient, error) {
 	return &CosmosClient{}, nil
 }
 
-func (c *CosmosClient) Log(resource, action, namespace string, data Data) {
+func (c *CosmosClient) Log(event AuditEvent) {
 
 }
@@ -0,0 +1,105 @@
+package loghandler
+
+import (
+	"encoding/json"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+	"time"
+)
+
+// writeActions are the actions of a write of the object, its resourceVersion identifying the write. Other actions,
+// e.g Drift or EndpointsDrained, follow a change elsewhere and can be emitted again on the same resourceVersion.
+var writeActions = map[string]bool{"Create": true, "Update": true, "Delete": true}
+
+// AuditEvent is a single audited action on a watched resource. It is what every Provider receives.
+type AuditEvent struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	UID             string `json:"uid,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Action          string `json:"action"`
+	// Actor is the field manager that last changed the resource. e.g (kubectl-edit, helm)
+	Actor string `json:"actor,omitempty"`
+	// Watch is the namespace/name of the watch the event was audited for
+	Watch string `json:"watch,omitempty"`
+	// Fields are computed by the enrichments of the watch. e.g (team: payments)
+	Fields map[string]string `json:"fields,omitempty"`
+	// Severity is the highest severity of the rules the event matches. e.g (critical)
+	Severity string `json:"severity,omitempty"`
+	// SeverityRules are the names of the rules the event matches. e.g (privileged-container)
+	SeverityRules []string `json:"severityRules,omitempty"`
+	// Ticket is the change ticket the resource was annotated with. e.g (CHG-1234)
+	Ticket string `json:"ticket,omitempty"`
+	// CausedBy is the ID of the event of the change this event follows up on, e.g the template change of a rollout
+	CausedBy string `json:"causedBy,omitempty"`
+	// Related are the objects related to the resource, e.g the HPA scaling a Deployment
+	Related []RelatedObject `json:"related,omitempty"`
+	// Tags flag the event. e.g (FreezeViolation)
+	Tags      []string  `json:"tags,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Changes   []Change  `json:"changes,omitempty"`
+	Patch     *Patch    `json:"patch,omitempty"`
+	// Chain links the event to the previous event when hash chaining is enabled
+	Chain *ChainLink `json:"chain,omitempty"`
+}
+
+// RelatedObject is an object related to the resource of an event, in its namespace.
+type RelatedObject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Relation is the role of the object for the resource. e.g (Autoscaler)
+	Relation string `json:"relation"`
+}
+
+// Patch holds the standard patch representations of an update from the old to the new object.
+type Patch struct {
+	// JSONPatch is an RFC 6902 JSON Patch
+	JSONPatch json.RawMessage `json:"jsonPatch,omitempty"`
+	// MergePatch is an RFC 7386 JSON Merge Patch
+	MergePatch json.RawMessage `json:"mergePatch,omitempty"`
+	// Truncated lists the patches left out for exceeding the size limit e.g (jsonPatch, mergePatch)
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// NewAuditEvent creates an event for action performed on obj with the changes recorded in data.
+func NewAuditEvent(action string, obj metav1.Object, data *Data) AuditEvent {
+	return AuditEvent{
+		Kind:            data.Kind(),
+		Name:            obj.GetName(),
+		Namespace:       obj.GetNamespace(),
+		UID:             string(obj.GetUID()),
+		ResourceVersion: obj.GetResourceVersion(),
+		Action:          action,
+		Timestamp:       time.Now().UTC(),
+		Changes:         data.Changes(),
+	}
+}
+
+// SetField sets the field name of the event to value.
+func (e *AuditEvent) SetField(name, value string) {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Fields[name] = value
+}
+
+// ID identifies the event for deduplication by sinks, derived from the UID and resourceVersion of the object. The
+// action and watch are part of it as a delete keeps the last resourceVersion and an object matching several watches
+// is audited once per watch. Events of other actions than a write, e.g Drift, also use their timestamp as they can
+// repeat on the same resourceVersion. Chained events without an object, e.g checkpoints, use their chain position.
+func (e AuditEvent) ID() string {
+	if e.UID == "" && e.Chain != nil {
+		return fmt.Sprintf("%s:%d", e.Chain.ID, e.Chain.Sequence)
+	}
+
+	id := fmt.Sprintf("%s:%s:%s", e.UID, e.ResourceVersion, e.Action)
+	if !writeActions[e.Action] {
+		id += ":" + strconv.FormatInt(e.Timestamp.UnixNano(), 10)
+	}
+	if e.Watch != "" {
+		id += "@" + e.Watch
+	}
+	return id
+}
@@ -0,0 +1,43 @@
+package loghandler
+
+import (
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuditEvent", func() {
+	It("Should identify writes by the resourceVersion of the object", func() {
+		first := AuditEvent{UID: "uid-1", ResourceVersion: "42", Action: "Update", Watch: "ns-1/my-watch", Timestamp: time.Now()}
+		second := first
+		second.Timestamp = first.Timestamp.Add(time.Second)
+		Expect(first.ID()).To(Equal("uid-1:42:Update@ns-1/my-watch"))
+		Expect(second.ID()).To(Equal(first.ID()))
+	})
+
+	It("Should tell apart events repeating on the same resourceVersion", func() {
+		drift := AuditEvent{UID: "uid-1", ResourceVersion: "42", Action: "Drift", Watch: "ns-1/my-watch", Timestamp: time.Now()}
+		converge := drift
+		converge.Action, converge.Timestamp = "Converge", drift.Timestamp.Add(time.Second)
+		driftAgain := drift
+		driftAgain.Timestamp = converge.Timestamp.Add(time.Second)
+
+		Expect(drift.ID()).To(HavePrefix("uid-1:42:Drift:"))
+		Expect(drift.ID()).To(HaveSuffix("@ns-1/my-watch"))
+		Expect(driftAgain.ID()).NotTo(Equal(drift.ID()))
+		Expect(converge.ID()).NotTo(Equal(drift.ID()))
+	})
+
+	It("Should keep the ID of events read back from NDJSON files", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "events.ndjson")
+		event := AuditEvent{UID: "uid-1", ResourceVersion: "42", Action: "EndpointsDrained", Timestamp: time.Now().UTC()}
+		Expect(appendNDJSON(path, []AuditEvent{event})).To(Succeed())
+
+		events, err := readNDJSON(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].ID()).To(Equal(event.ID()))
+	})
+})
@@ -0,0 +1,108 @@
+package loghandler
+
+import (
+	"slices"
+	"sync"
+)
+
+// Fanout is a Provider passing every event on to each of its providers. Named sinks can be added and removed while
+// running, e.g from AuditSink resources; they only receive the events of the watches routed to them, which in turn
+// only reach the providers when they match no route.
+type Fanout struct {
+	providers []Provider
+
+	mu     sync.RWMutex
+	sinks  map[string]Provider
+	routes map[string][]Route // by watch
+}
+
+// Route sends the events matching Match to the sinks named. A nil Match matches every event.
+type Route struct {
+	Match *FilterConfig
+	Sinks []string
+}
+
+func NewFanout(providers ...Provider) *Fanout {
+	return &Fanout{providers: providers, sinks: map[string]Provider{}, routes: map[string][]Route{}}
+}
+
+func (f *Fanout) Log(event AuditEvent) {
+	for _, p := range f.targets(event) {
+		p.Log(event)
+	}
+}
+
+// targets returns the providers or sinks event is sent to. They are called outside the lock, so a slow sink neither
+// blocks adding, removing or routing sinks nor the events sent to other sinks.
+func (f *Fanout) targets(event AuditEvent) []Provider {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	routes, routed := f.routes[event.Watch]
+	if !routed {
+		return f.providers
+	}
+
+	// Sinks of several matching routes receive the event once. Events routed to a sink not added yet or already
+	// removed, e.g while an AuditSink is replaced, go to the providers instead of being dropped
+	var sent []string
+	var targets []Provider
+	matched, fallback := false, false
+	for _, route := range routes {
+		if !route.Match.Match(event) {
+			continue
+		}
+		matched = true
+		for _, name := range route.Sinks {
+			if slices.Contains(sent, name) {
+				continue
+			}
+			sent = append(sent, name)
+			sink, ok := f.sinks[name]
+			if !ok {
+				loghandlerlog.Info("Audit sink not found, sending event to the default sinks", "Sink", name, "Watch", event.Watch)
+				fallback = true
+				continue
+			}
+			targets = append(targets, sink)
+		}
+	}
+	if !matched {
+		return f.providers
+	}
+	if fallback {
+		targets = append(targets, f.providers...)
+	}
+	return targets
+}
+
+// SetSink adds the sink name, returning the sink it replaces if any.
+func (f *Fanout) SetSink(name string, sink Provider) Provider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	previous := f.sinks[name]
+	f.sinks[name] = sink
+	return previous
+}
+
+// RemoveSink removes the sink name, returning it if any.
+func (f *Fanout) RemoveSink(name string) Provider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sink := f.sinks[name]
+	delete(f.sinks, name)
+	return sink
+}
+
+// Route sends the events of watch to the sinks of the routes they match, instead of the providers. Events matching
+// none go to the providers, as do all events if routes is empty. Routes to sinks not added yet are kept;
+// events go to the providers until the sink is added.
+func (f *Fanout) Route(watch string, routes []Route) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(routes) == 0 {
+		delete(f.routes, watch)
+		return
+	}
+	f.routes[watch] = slices.Clone(routes)
+}
@@ -0,0 +1,223 @@
+package loghandler
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fanout", func() {
+	var (
+		defaults *recorder
+		siem     *recorder
+		fanout   *Fanout
+	)
+
+	BeforeEach(func() {
+		defaults, siem = &recorder{}, &recorder{}
+		fanout = NewFanout(defaults)
+	})
+
+	watchEvent := func(name, watch string) AuditEvent {
+		event := makeEvent(name, "Update")
+		event.Watch = watch
+		return event
+	}
+
+	It("Should send the events of routed watches to their sinks only", func() {
+		fanout.SetSink("team-a/siem", siem)
+		fanout.Route("team-a/prod", []Route{{Sinks: []string{"team-a/siem"}}})
+
+		fanout.Log(watchEvent("app-1", "team-a/prod"))
+		fanout.Log(watchEvent("app-2", "team-b/prod"))
+
+		Expect(siem.Events()).To(HaveLen(1))
+		Expect(siem.Events()[0].Name).To(Equal("app-1"))
+		Expect(defaults.Events()).To(HaveLen(1))
+		Expect(defaults.Events()[0].Name).To(Equal("app-2"))
+	})
+
+	It("Should send events to sinks added after the route and back to the providers once unrouted", func() {
+		fanout.Route("team-a/prod", []Route{{Sinks: []string{"team-a/siem"}}})
+		fanout.Log(watchEvent("app-1", "team-a/prod"))
+		Expect(defaults.Events()).To(HaveLen(1))
+
+		fanout.SetSink("team-a/siem", siem)
+		fanout.Log(watchEvent("app-2", "team-a/prod"))
+		Expect(siem.Events()).To(HaveLen(1))
+		Expect(defaults.Events()).To(HaveLen(1))
+
+		replacement := &recorder{}
+		Expect(fanout.SetSink("team-a/siem", replacement)).To(Equal(siem))
+		fanout.Log(watchEvent("app-3", "team-a/prod"))
+		Expect(replacement.Events()).To(HaveLen(1))
+		Expect(fanout.RemoveSink("team-a/siem")).To(Equal(replacement))
+
+		fanout.Route("team-a/prod", nil)
+		fanout.Log(watchEvent("app-4", "team-a/prod"))
+		Expect(defaults.Events()).To(HaveLen(2))
+	})
+
+	It("Should send the events routed to a removed sink to the providers alongside the other sinks", func() {
+		archive := &recorder{}
+		fanout.SetSink("team-a/siem", siem)
+		fanout.SetSink("team-a/archive", archive)
+		fanout.Route("team-a/prod", []Route{{Sinks: []string{"team-a/siem", "team-a/archive"}}})
+		fanout.RemoveSink("team-a/siem")
+
+		fanout.Log(watchEvent("app-1", "team-a/prod"))
+
+		Expect(siem.Events()).To(BeEmpty())
+		Expect(archive.Events()).To(HaveLen(1))
+		Expect(defaults.Events()).To(HaveLen(1))
+	})
+
+	It("Should send events to the sinks of every route they match once", func() {
+		chat, archive := &recorder{}, &recorder{}
+		fanout.SetSink("team-a/chat", chat)
+		fanout.SetSink("team-a/archive", archive)
+		fanout.Route("team-a/prod", []Route{
+			{Match: &FilterConfig{ChangedPaths: []string{".spec.replicas"}}, Sinks: []string{"team-a/chat", "team-a/archive"}},
+			{Sinks: []string{"team-a/archive"}},
+		})
+
+		fanout.Log(watchEvent("app-1", "team-a/prod"))
+		image := watchEvent("app-2", "team-a/prod")
+		data := NewData("Deployment")
+		Expect(data.AddChange(".spec.template.spec.containers[0].image", "app:1", "app:2")).To(Succeed())
+		image.Changes = data.Changes()
+		fanout.Log(image)
+
+		Expect(chat.Events()).To(HaveLen(1))
+		Expect(chat.Events()[0].Name).To(Equal("app-1"))
+		Expect(archive.Events()).To(HaveLen(2))
+		Expect(defaults.Events()).To(BeEmpty())
+	})
+
+	It("Should send the events matching no route to the providers", func() {
+		fanout.SetSink("team-a/chat", siem)
+		fanout.Route("team-a/prod", []Route{
+			{Match: &FilterConfig{MinSeverity: SeverityHigh}, Sinks: []string{"team-a/chat"}},
+		})
+
+		risky := watchEvent("app-1", "team-a/prod")
+		risky.Severity = SeverityHigh
+		fanout.Log(risky)
+		fanout.Log(watchEvent("app-2", "team-a/prod"))
+
+		Expect(siem.Events()).To(HaveLen(1))
+		Expect(siem.Events()[0].Name).To(Equal("app-1"))
+		Expect(defaults.Events()).To(HaveLen(1))
+		Expect(defaults.Events()[0].Name).To(Equal("app-2"))
+	})
+
+	It("Should give every sink behind its own hash chain a chain without gaps", func() {
+		chained := func(next Provider) Provider {
+			chain, err := NewHashChain(next, nil, 0)
+			Expect(err).NotTo(HaveOccurred())
+			return chain
+		}
+		fanout = NewFanout(chained(defaults))
+		fanout.SetSink("team-a/siem", chained(siem))
+		fanout.Route("team-a/prod", []Route{{Sinks: []string{"team-a/siem"}}})
+
+		for i := 0; i < 4; i++ {
+			watch := "team-a/prod"
+			if i%2 == 1 {
+				watch = "team-b/prod"
+			}
+			fanout.Log(watchEvent(fmt.Sprintf("app-%d", i), watch))
+		}
+
+		for _, sink := range []*recorder{defaults, siem} {
+			events := sink.Events()
+			Expect(events).To(HaveLen(2))
+			for i, event := range events {
+				Expect(event.Chain.Sequence).To(Equal(uint64(i + 1)))
+			}
+			Expect(events[1].Chain.PrevHash).To(Equal(events[0].Chain.Hash))
+		}
+	})
+
+	It("Should not hold up other sinks and routing while a sink blocks", func() {
+		blocking := &blockingSink{release: make(chan struct{}), logging: make(chan struct{})}
+		defer close(blocking.release)
+		fanout.SetSink("team-a/slow", blocking)
+		fanout.Route("team-a/prod", []Route{{Sinks: []string{"team-a/slow"}}})
+
+		go fanout.Log(watchEvent("app-1", "team-a/prod"))
+		Eventually(blocking.logging).Should(BeClosed())
+
+		done := make(chan struct{})
+		go func() {
+			fanout.SetSink("team-a/siem", siem)
+			fanout.Route("team-a/staging", []Route{{Sinks: []string{"team-a/siem"}}})
+			fanout.Log(watchEvent("app-2", "team-a/staging"))
+			fanout.Log(watchEvent("app-3", "team-b/prod"))
+			close(done)
+		}()
+		Eventually(done).Should(BeClosed())
+		Expect(siem.Events()).To(HaveLen(1))
+		Expect(defaults.Events()).To(HaveLen(1))
+	})
+})
+
+// blockingSink blocks logging until release is closed, closing logging once it starts.
+type blockingSink struct {
+	release chan struct{}
+	logging chan struct{}
+}
+
+func (b *blockingSink) Log(AuditEvent) {
+	close(b.logging)
+	<-b.release
+}
+
+var _ = Describe("Filter", func() {
+	It("Should pass on the events matching every list", func() {
+		sink := &recorder{}
+		filter := NewFilter(sink, FilterConfig{Kinds: []string{"Deployment"}, Actions: []string{"Delete"}})
+
+		filter.Log(makeEvent("app-1", "Update"))
+		filter.Log(makeEvent("app-2", "Delete"))
+		service := makeEvent("svc-1", "Delete")
+		service.Kind = "Service"
+		filter.Log(service)
+
+		Expect(sink.Events()).To(HaveLen(1))
+		Expect(sink.Events()[0].Name).To(Equal("app-2"))
+	})
+
+	It("Should match changed paths and actors", func() {
+		event := makeEvent("app-1", "Update")
+		event.Actor = "helm"
+
+		Expect((&FilterConfig{ChangedPaths: []string{".spec"}}).Match(event)).To(BeTrue())
+		Expect((&FilterConfig{ChangedPaths: []string{".spec.replicas"}, Actors: []string{"helm"}}).Match(event)).To(BeTrue())
+		Expect((&FilterConfig{ChangedPaths: []string{".spec.rep"}}).Match(event)).To(BeFalse())
+		Expect((&FilterConfig{Actors: []string{"kubectl-edit"}}).Match(event)).To(BeFalse())
+	})
+
+	It("Should match events at least as severe", func() {
+		event := makeEvent("app-1", "Update")
+		filter := &FilterConfig{MinSeverity: SeverityHigh}
+		Expect(filter.Match(event)).To(BeFalse())
+
+		event.Severity = SeverityHigh
+		Expect(filter.Match(event)).To(BeTrue())
+		event.Severity = SeverityCritical
+		Expect(filter.Match(event)).To(BeTrue())
+		event.Severity = SeverityMedium
+		Expect(filter.Match(event)).To(BeFalse())
+	})
+
+	It("Should match paths with any index", func() {
+		path := ".spec.template.spec.containers[1].image"
+		Expect(MatchPath(".spec.template.spec.containers[*].image", path)).To(BeTrue())
+		Expect(MatchPath(".spec.template.spec.containers[*]", path)).To(BeTrue())
+		Expect(MatchPath(".spec.template.spec.containers", path)).To(BeTrue())
+		Expect(MatchPath(".spec.template.spec.containers[0].image", path)).To(BeFalse())
+		Expect(MatchPath(".spec.template.spec.containers[*].name", path)).To(BeFalse())
+	})
+})
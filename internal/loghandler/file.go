@@ -0,0 +1,306 @@
+package loghandler
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// backupTimeFormat is the time layout of the rotated file names. e.g (audit-2024-10-18T09-15-00.000.ndjson)
+// Files rotated within the same millisecond are suffixed with a sequence number. e.g (audit-2024-10-18T09-15-00.000-1.ndjson)
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// FileConfig configures a FileSink.
+type FileConfig struct {
+	// Path of the file events are written to, one JSON object per line. e.g (/var/log/watchman/audit.ndjson)
+	Path string `json:"path"`
+	// MaxSize is the size in bytes the file is rotated at. Defaults to 100MiB
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// MaxAge is how long the file is written to before being rotated. Not rotated by age if empty
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+	// MaxBackups is the number of rotated files kept. Defaults to 5
+	MaxBackups int `json:"maxBackups,omitempty"`
+	// Compress gzips rotated files
+	Compress bool `json:"compress,omitempty"`
+	// SyncInterval is how often the file is flushed to disk. 0s syncs after every event. Defaults to 1s
+	SyncInterval *metav1.Duration `json:"syncInterval,omitempty"`
+}
+
+// FileSink is a Provider writing every event as a JSON line to a file, rotated by size and age. Start must be running
+// to sync the file on interval and rotate it by age.
+type FileSink struct {
+	cfg FileConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	dirty  bool
+
+	// compressing tracks the rotated files being compressed, one at a time under compressMu
+	compressing sync.WaitGroup
+	compressMu  sync.Mutex
+}
+
+func NewFileSink(cfg FileConfig) (*FileSink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("file sink path is required")
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 100 << 20
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = 5
+	}
+	if cfg.SyncInterval == nil {
+		cfg.SyncInterval = &metav1.Duration{Duration: time.Second}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o750); err != nil {
+		return nil, err
+	}
+
+	sink := &FileSink{cfg: cfg}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (f *FileSink) Log(event AuditEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		loghandlerlog.Error(err, "Failed to encode audit event", "Sink", "file", "Name", event.Name)
+		trackDelivery(SinkTypeFile, DeliveryFailed, 1)
+		return
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err = f.open(); err != nil {
+			loghandlerlog.Error(err, "Failed to open audit file", "Path", f.cfg.Path)
+			trackDelivery(SinkTypeFile, DeliveryFailed, 1)
+			return
+		}
+	}
+	if f.size > 0 && f.size+int64(len(line)) > f.cfg.MaxSize {
+		f.rotate()
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		loghandlerlog.Error(err, "Failed to write audit file", "Path", f.cfg.Path)
+		trackDelivery(SinkTypeFile, DeliveryFailed, 1)
+		return
+	}
+	f.dirty = true
+	trackDelivery(SinkTypeFile, DeliveryDelivered, 1)
+
+	if f.cfg.SyncInterval.Duration == 0 {
+		f.sync()
+	}
+}
+
+// Start syncs the file on interval and rotates it once older than MaxAge until ctx is done, then syncs and closes it.
+func (f *FileSink) Start(ctx context.Context) error {
+	tick := f.cfg.SyncInterval.Duration
+	if tick <= 0 || (f.cfg.MaxAge.Duration > 0 && f.cfg.MaxAge.Duration < tick) {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.mu.Lock()
+			if f.file != nil && f.cfg.MaxAge.Duration > 0 && f.size > 0 && time.Since(f.opened) >= f.cfg.MaxAge.Duration {
+				f.rotate()
+			}
+			f.sync()
+			f.mu.Unlock()
+		case <-ctx.Done():
+			f.mu.Lock()
+			f.sync()
+			if f.file != nil {
+				if err := f.file.Close(); err != nil {
+					loghandlerlog.Error(err, "Failed to close audit file", "Path", f.cfg.Path)
+				}
+				f.file = nil
+			}
+			f.mu.Unlock()
+			f.compressing.Wait()
+			return nil
+		}
+	}
+}
+
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *FileSink) sync() {
+	if f.file == nil || !f.dirty {
+		return
+	}
+	if err := f.file.Sync(); err != nil {
+		loghandlerlog.Error(err, "Failed to sync audit file", "Path", f.cfg.Path)
+		return
+	}
+	f.dirty = false
+}
+
+// rotate renames the file to a timestamped backup, opens a new file and compresses and prunes the backups in the
+// background. Must be called with mu held.
+func (f *FileSink) rotate() {
+	f.sync()
+	if err := f.file.Close(); err != nil {
+		loghandlerlog.Error(err, "Failed to close audit file", "Path", f.cfg.Path)
+	}
+	f.file = nil
+
+	backup := f.backupPath(time.Now())
+	if err := os.Rename(f.cfg.Path, backup); err != nil {
+		loghandlerlog.Error(err, "Failed to rotate audit file", "Path", f.cfg.Path)
+		backup = ""
+	}
+
+	if err := f.open(); err != nil {
+		loghandlerlog.Error(err, "Failed to open audit file", "Path", f.cfg.Path)
+	}
+
+	f.compressing.Add(1)
+	go func() {
+		defer f.compressing.Done()
+		f.compressMu.Lock()
+		defer f.compressMu.Unlock()
+		if backup != "" && f.cfg.Compress {
+			if err := compressFile(backup); err != nil {
+				loghandlerlog.Error(err, "Failed to compress rotated audit file", "Path", backup)
+			}
+		}
+		f.prune()
+	}()
+}
+
+// backupPath returns the path of a backup rotated at t, not overwriting one rotated within the same millisecond,
+// compressed or not.
+func (f *FileSink) backupPath(t time.Time) string {
+	ext := filepath.Ext(f.cfg.Path)
+	stamp := strings.TrimSuffix(f.cfg.Path, ext) + "-" + t.UTC().Format(backupTimeFormat)
+	backup := stamp + ext
+	for seq := 1; exists(backup) || exists(backup+".gz"); seq++ {
+		backup = fmt.Sprintf("%s-%d%s", stamp, seq, ext)
+	}
+	return backup
+}
+
+// prune removes the oldest backups beyond MaxBackups.
+func (f *FileSink) prune() {
+	ext := filepath.Ext(f.cfg.Path)
+	prefix := filepath.Base(strings.TrimSuffix(f.cfg.Path, ext)) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(f.cfg.Path))
+	if err != nil {
+		loghandlerlog.Error(err, "Failed to list rotated audit files", "Path", f.cfg.Path)
+		return
+	}
+
+	type rotated struct {
+		name  string
+		stamp string
+		seq   int
+	}
+	var backups []rotated
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		seq := 0
+		if i := strings.LastIndexByte(stamp, '-'); i > 0 {
+			if n, err := strconv.Atoi(stamp[i+1:]); err == nil && len(stamp[:i]) == len(backupTimeFormat) {
+				stamp, seq = stamp[:i], n
+			}
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, rotated{name: name, stamp: stamp, seq: seq})
+		}
+	}
+
+	// Stamps sort by rotation time, then sequence numbers within a millisecond
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp < backups[j].stamp
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	for len(backups) > f.cfg.MaxBackups {
+		if err := os.Remove(filepath.Join(filepath.Dir(f.cfg.Path), backups[0].name)); err != nil && !os.IsNotExist(err) {
+			loghandlerlog.Error(err, "Failed to remove rotated audit file", "Path", backups[0].name)
+		}
+		backups = backups[1:]
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compressFile gzips path to path.gz and removes path.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,181 @@
+package loghandler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func readLines(path string) []AuditEvent {
+	file, err := os.Open(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event AuditEvent
+		Expect(json.Unmarshal(scanner.Bytes(), &event)).To(Succeed())
+		events = append(events, event)
+	}
+	Expect(scanner.Err()).NotTo(HaveOccurred())
+	return events
+}
+
+var _ = Describe("FileSink", func() {
+	var (
+		dir    string
+		path   string
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		path = filepath.Join(dir, "audit.ndjson")
+	})
+
+	start := func(sink *FileSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	backups := func() []string {
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, entry := range entries {
+			if entry.Name() != "audit.ndjson" {
+				names = append(names, entry.Name())
+			}
+		}
+		return names
+	}
+
+	It("Should write one JSON object per event", func() {
+		sink, err := NewFileSink(FileConfig{Path: path, SyncInterval: &metav1.Duration{}})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		first := makeEvent("app-1", "Update")
+		first.Actor = "helm"
+		sink.Log(first)
+		sink.Log(makeEvent("app-2", "Delete"))
+
+		// Synced after every event
+		events := readLines(path)
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Name).To(Equal("app-1"))
+		Expect(events[0].Actor).To(Equal("helm"))
+		Expect(events[0].Changes).To(HaveLen(1))
+		Expect(events[1].Action).To(Equal("Delete"))
+		stop()
+	})
+
+	It("Should rotate by size, compress rotated files and keep maxBackups", func() {
+		line, err := json.Marshal(makeEvent("app-0", "Update"))
+		Expect(err).NotTo(HaveOccurred())
+
+		sink, err := NewFileSink(FileConfig{Path: path, MaxSize: int64(len(line)+1) * 2, MaxBackups: 2, Compress: true})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		for i := 0; i < 8; i++ {
+			sink.Log(makeEvent(fmt.Sprintf("app-%d", i), "Update"))
+			// Backups are named after the rotation time to the millisecond
+			time.Sleep(2 * time.Millisecond)
+		}
+		stop()
+
+		names := backups()
+		Expect(names).To(HaveLen(2))
+		for _, name := range names {
+			Expect(name).To(MatchRegexp(`^audit-\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.\d{3}\.ndjson\.gz$`))
+		}
+
+		// The latest backups are kept
+		raw, err := os.ReadFile(filepath.Join(dir, names[1]))
+		Expect(err).NotTo(HaveOccurred())
+		events := gunzipLines(raw)
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Name).To(Equal("app-4"))
+		Expect(events[1].Name).To(Equal("app-5"))
+
+		events = readLines(path)
+		Expect(events).To(HaveLen(2))
+		Expect(events[1].Name).To(Equal("app-7"))
+	})
+
+	It("Should not overwrite a backup rotated within the same millisecond", func() {
+		sink, err := NewFileSink(FileConfig{Path: path, MaxBackups: 10})
+		Expect(err).NotTo(HaveOccurred())
+
+		rotatedAt := time.Date(2024, 10, 18, 9, 15, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			line, err := json.Marshal(makeEvent(fmt.Sprintf("app-%d", i), "Update"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(sink.backupPath(rotatedAt), append(line, '\n'), 0o600)).To(Succeed())
+		}
+		sink.prune()
+
+		Expect(backups()).To(ConsistOf(
+			"audit-2024-10-18T09-15-00.000.ndjson",
+			"audit-2024-10-18T09-15-00.000-1.ndjson",
+			"audit-2024-10-18T09-15-00.000-2.ndjson",
+		))
+		Expect(readLines(filepath.Join(dir, "audit-2024-10-18T09-15-00.000-2.ndjson"))[0].Name).To(Equal("app-2"))
+
+		// The oldest of the same millisecond are pruned first
+		sink.cfg.MaxBackups = 1
+		sink.prune()
+		Expect(backups()).To(ConsistOf("audit-2024-10-18T09-15-00.000-2.ndjson"))
+	})
+
+	It("Should rotate by age", func() {
+		sink, err := NewFileSink(FileConfig{Path: path, MaxAge: metav1.Duration{Duration: 100 * time.Millisecond}})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("app-1", "Update"))
+		Eventually(backups, 5*time.Second).Should(HaveLen(1))
+		Expect(backups()[0]).NotTo(HaveSuffix(".gz"))
+		Expect(readLines(filepath.Join(dir, backups()[0]))).To(HaveLen(1))
+
+		// An empty file is not rotated
+		Consistently(backups, 1500*time.Millisecond).Should(HaveLen(1))
+	})
+
+	It("Should append to an existing file", func() {
+		Expect(os.WriteFile(path, []byte(`{"name":"previous"}`+"\n"), 0o600)).To(Succeed())
+		sink, err := NewFileSink(FileConfig{Path: path})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		sink.Log(makeEvent("app-1", "Update"))
+		stop()
+
+		events := readLines(path)
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Name).To(Equal("previous"))
+		Expect(events[1].Name).To(Equal("app-1"))
+	})
+})
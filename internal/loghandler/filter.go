@@ -0,0 +1,104 @@
+package loghandler
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// FilterConfig matches the events a sink receives. An event matches if it matches every non empty list.
+type FilterConfig struct {
+	// Kinds of the resources matched. e.g (Deployment, Service)
+	Kinds []string `json:"kinds,omitempty"`
+	// Actions matched. e.g (Create, Delete)
+	Actions []string `json:"actions,omitempty"`
+	// Namespaces of the resources matched
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ChangedPaths matches the events changing a field at or under one of the paths, [*] matching any index.
+	// e.g (.spec.replicas, .spec.template.spec.containers[*].image)
+	ChangedPaths []string `json:"changedPaths,omitempty"`
+	// Actors matched, the field managers of the changes. e.g (kubectl-edit, helm)
+	Actors []string `json:"actors,omitempty"`
+	// MinSeverity matches the events at least as severe. e.g (critical)
+	MinSeverity string `json:"minSeverity,omitempty"`
+}
+
+// Match reports whether event passes c. A nil c matches every event.
+func (c *FilterConfig) Match(event AuditEvent) bool {
+	if c == nil {
+		return true
+	}
+	return matchAny(c.Kinds, event.Kind) && matchAny(c.Actions, event.Action) &&
+		matchAny(c.Namespaces, event.Namespace) && matchAny(c.Actors, event.Actor) && c.matchChanges(event.Changes) &&
+		(c.MinSeverity == "" || SeverityRank(event.Severity) >= SeverityRank(c.MinSeverity))
+}
+
+func (c *FilterConfig) matchChanges(changes []Change) bool {
+	if len(c.ChangedPaths) == 0 {
+		return true
+	}
+	for _, change := range changes {
+		for _, pattern := range c.ChangedPaths {
+			if MatchPath(pattern, change.Path()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter is a Provider passing on the events matching its config only.
+type Filter struct {
+	provider Provider
+	cfg      FilterConfig
+}
+
+func NewFilter(provider Provider, cfg FilterConfig) *Filter {
+	return &Filter{provider: provider, cfg: cfg}
+}
+
+func (f *Filter) Log(event AuditEvent) {
+	if f.cfg.Match(event) {
+		f.provider.Log(event)
+	}
+}
+
+// Start runs the filtered provider if it is a manager.Runnable, otherwise waits for ctx to be done.
+func (f *Filter) Start(ctx context.Context) error {
+	if runnable, ok := f.provider.(manager.Runnable); ok {
+		return runnable.Start(ctx)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func matchAny(values []string, value string) bool {
+	return len(values) == 0 || slices.Contains(values, value)
+}
+
+// MatchPath reports whether path is pattern or a field under it, [*] in pattern matching any index or item key.
+// e.g (.spec.template.spec.containers[*] matches .spec.template.spec.containers[name=app].image)
+func MatchPath(pattern, path string) bool {
+	for {
+		i := strings.Index(pattern, "[*]")
+		if i < 0 {
+			break
+		}
+		if !strings.HasPrefix(path, pattern[:i]+"[") {
+			return false
+		}
+		end := strings.IndexByte(path[i:], ']')
+		if end < 0 {
+			return false
+		}
+		path, pattern = path[i+end+1:], pattern[i+3:]
+	}
+
+	if !strings.HasPrefix(path, pattern) {
+		return false
+	}
+	rest := path[len(pattern):]
+	return rest == "" || rest[0] == '.' || rest[0] == '['
+}
@@ -0,0 +1,397 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body as sha256=<hex> when a signing secret is configured.
+const SignatureHeader = "X-Watchman-Signature"
+
+// HTTPConfig configures an HTTPSink.
+type HTTPConfig struct {
+	// URL is the endpoint events are POSTed to
+	URL string `json:"url"`
+	// Headers are added to every request. e.g (Authorization: Bearer xyz)
+	Headers map[string]string `json:"headers,omitempty"`
+	// HMACSecret signs every request body with HMAC-SHA256, sent in the X-Watchman-Signature header
+	HMACSecret string `json:"hmacSecret,omitempty"`
+	// TLS configures server verification and the client certificate for mTLS
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// Template is a Go template rendering the request body from {{ .Events }}. Takes precedence over JSONEnvelope
+	Template string `json:"template,omitempty"`
+	// JSONEnvelope wraps the JSON array of events in an object under this key. e.g (events)
+	JSONEnvelope string `json:"jsonEnvelope,omitempty"`
+	// ContentType of the request body. Defaults to application/json
+	ContentType string `json:"contentType,omitempty"`
+	// CloudEvents sends the events as CloudEvents, a JSON batch in structured mode or a request per event in binary
+	// mode. Exclusive with Template and JSONEnvelope
+	CloudEvents *CloudEventsConfig `json:"cloudEvents,omitempty"`
+
+	// BatchSize is the maximum number of events sent in a single request. Defaults to 50
+	BatchSize int `json:"batchSize,omitempty"`
+	// FlushInterval is the maximum time an event waits for its batch to fill up. Defaults to 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+	// QueueSize is the number of events buffered while waiting to be sent. Defaults to 1000
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout of a single request. Defaults to 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// MaxRetries is the number of times a failed request is retried. Defaults to 5
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the base of the exponential backoff between retries, jitter added. Defaults to 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+	// DeadLetterPath is the file batches are appended to, one event per line, once retries are exhausted
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// TLSConfig configures TLS for sinks connecting to a remote endpoint.
+type TLSConfig struct {
+	// CAFile is the PEM encoded CA bundle the server certificate is verified with. System roots if empty
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the PEM encoded client certificate and key for mTLS
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// CA, Cert and Key are PEM encoded contents used instead of the files, e.g read from the credentials of an AuditSink
+	CA   string `json:"ca,omitempty"`
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// InsecureSkipVerify disables server certificate verification
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// HTTPSink is a Provider that POSTs batches of events to an HTTP endpoint. Events are queued by Log and sent by
+// Start, which must be running. e.g added to the manager.
+type HTTPSink struct {
+	cfg      HTTPConfig
+	client   *http.Client
+	template *template.Template
+	queue    chan AuditEvent
+	mu       sync.Mutex // guards the dead letter file
+}
+
+func NewHTTPSink(cfg HTTPConfig) (*HTTPSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("http sink url is required")
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+	if err := cfg.CloudEvents.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.CloudEvents != nil && (cfg.Template != "" || cfg.JSONEnvelope != "") {
+		return nil, fmt.Errorf("http sink cloudEvents can not be used with template or jsonEnvelope")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+	if cfg.FlushInterval.Duration <= 0 {
+		cfg.FlushInterval.Duration = 5 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	if cfg.MaxRetries == nil {
+		maxRetries := 5
+		cfg.MaxRetries = &maxRetries
+	}
+	if cfg.RetryBackoff.Duration <= 0 {
+		cfg.RetryBackoff.Duration = time.Second
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	sink := &HTTPSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout.Duration, Transport: transport},
+		queue:  make(chan AuditEvent, cfg.QueueSize),
+	}
+
+	if cfg.Template != "" {
+		if sink.template, err = template.New("payload").Funcs(templateFuncs).Parse(cfg.Template); err != nil {
+			return nil, fmt.Errorf("invalid http sink template: %w", err)
+		}
+	}
+
+	return sink, nil
+}
+
+// Log queues event to be sent. The event goes to the dead letter file straight away if the queue is full.
+func (h *HTTPSink) Log(event AuditEvent) {
+	select {
+	case h.queue <- event:
+	default:
+		h.deadLetter([]AuditEvent{event}, fmt.Errorf("queue full"))
+	}
+}
+
+// Start sends queued events in batches until ctx is done, then flushes what is left.
+func (h *HTTPSink) Start(ctx context.Context) error {
+	ticker := time.NewTicker(h.cfg.FlushInterval.Duration)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, h.cfg.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		h.send(ctx, batch)
+		batch = make([]AuditEvent, 0, h.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case event := <-h.queue:
+			batch = append(batch, event)
+			if len(batch) >= h.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-h.queue:
+					batch = append(batch, event)
+				default:
+					flushCtx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout.Duration)
+					flush(flushCtx)
+					cancel()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (h *HTTPSink) send(ctx context.Context, batch []AuditEvent) {
+	// Binary mode CloudEvents carry their attributes as headers, one event per request
+	if h.cfg.CloudEvents.Binary() {
+		for _, event := range batch {
+			h.deliver(ctx, []AuditEvent{event})
+		}
+		return
+	}
+	h.deliver(ctx, batch)
+}
+
+func (h *HTTPSink) deliver(ctx context.Context, batch []AuditEvent) {
+	body, header, err := h.render(batch)
+	if err != nil {
+		h.deadLetter(batch, err)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(ctx, body, header)
+		if err == nil {
+			trackDelivery(SinkTypeHTTP, DeliveryDelivered, len(batch))
+			return
+		}
+
+		if !retry || attempt >= *h.cfg.MaxRetries {
+			h.deadLetter(batch, err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff(h.cfg.RetryBackoff.Duration, attempt)):
+		case <-ctx.Done():
+			h.deadLetter(batch, ctx.Err())
+			return
+		}
+	}
+}
+
+// post sends body with header and reports whether a failed request is worth retrying.
+func (h *HTTPSink) post(ctx context.Context, body []byte, header http.Header) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if h.cfg.HMACSecret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign([]byte(h.cfg.HMACSecret), body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	return retryableStatus(resp.StatusCode), fmt.Errorf("unexpected status %s from %s", resp.Status, h.cfg.URL)
+}
+
+// render returns the request body of batch and its headers.
+func (h *HTTPSink) render(batch []AuditEvent) ([]byte, http.Header, error) {
+	header := http.Header{"Content-Type": {h.cfg.ContentType}}
+
+	switch {
+	case h.cfg.CloudEvents.Binary():
+		cloudEvent := NewCloudEvent(batch[0])
+		header.Set("Content-Type", cloudEvent.DataContentType)
+		for _, attribute := range cloudEvent.Attributes() {
+			header.Set("ce-"+attribute[0], attribute[1])
+		}
+		body, err := json.Marshal(batch[0])
+		return body, header, err
+
+	case h.cfg.CloudEvents != nil:
+		cloudEvents := make([]CloudEvent, 0, len(batch))
+		for _, event := range batch {
+			cloudEvents = append(cloudEvents, NewCloudEvent(event))
+		}
+		header.Set("Content-Type", CloudEventsBatchContentType)
+		body, err := json.Marshal(cloudEvents)
+		return body, header, err
+
+	case h.template != nil:
+		buf := &bytes.Buffer{}
+		if err := h.template.Execute(buf, struct{ Events []AuditEvent }{batch}); err != nil {
+			return nil, nil, err
+		}
+		return buf.Bytes(), header, nil
+
+	case h.cfg.JSONEnvelope != "":
+		body, err := json.Marshal(map[string][]AuditEvent{h.cfg.JSONEnvelope: batch})
+		return body, header, err
+	}
+
+	body, err := json.Marshal(batch)
+	return body, header, err
+}
+
+func (h *HTTPSink) deadLetter(batch []AuditEvent, reason error) {
+	loghandlerlog.Error(reason, "Failed to deliver audit events", "Sink", "http", "URL", h.cfg.URL, "Events", len(batch))
+	trackDelivery(SinkTypeHTTP, DeliveryFailed, len(batch))
+	if h.cfg.DeadLetterPath == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := appendNDJSON(h.cfg.DeadLetterPath, batch); err != nil {
+		loghandlerlog.Error(err, "Failed to write dead letter file", "Path", h.cfg.DeadLetterPath)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Build creates the tls.Config described by c. Nil if c is nil.
+func (c *TLSConfig) Build() (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify} // nolint:gosec
+	switch {
+	case c.CA != "":
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(c.CA)) {
+			return nil, fmt.Errorf("no certificate found in tls ca")
+		}
+	case c.CAFile != "":
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+
+	switch {
+	case c.Cert != "" || c.Key != "":
+		cert, err := tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case c.CertFile != "" || c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+	"join": strings.Join,
+}
+
+// backoff returns the exponential backoff for attempt, randomized between half and the full delay.
+func backoff(base time.Duration, attempt int) time.Duration {
+	if attempt > 10 {
+		attempt = 10
+	}
+	max := base << attempt
+	return max/2 + time.Duration(rand.Int63n(int64(max/2)+1)) // nolint:gosec
+}
+
+func appendNDJSON(path string, events []AuditEvent) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, event := range events {
+		if err = encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package loghandler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("HTTPSink", func() {
+	var (
+		server   *httptest.Server
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		status   atomic.Int32
+		cancel   context.CancelFunc
+		done     chan struct{}
+	)
+
+	BeforeEach(func() {
+		requests, bodies = nil, nil
+		status.Store(http.StatusOK)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			mu.Unlock()
+			w.WriteHeader(int(status.Load()))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	start := func(sink *HTTPSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	received := func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]byte{}, bodies...)
+	}
+
+	It("Should POST batches of events with headers and HMAC signature", func() {
+		sink, err := NewHTTPSink(HTTPConfig{
+			URL:           server.URL,
+			Headers:       map[string]string{"Authorization": "Bearer xyz"},
+			HMACSecret:    "s3cr3t",
+			BatchSize:     2,
+			FlushInterval: metav1.Duration{Duration: time.Hour},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("deploy-1", "Update"))
+		sink.Log(makeEvent("deploy-2", "Update"))
+
+		Eventually(received).Should(HaveLen(1))
+		mu.Lock()
+		req, body := requests[0], bodies[0]
+		mu.Unlock()
+
+		Expect(req.Header.Get("Authorization")).To(Equal("Bearer xyz"))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(req.Header.Get(SignatureHeader)).To(Equal("sha256=" + Sign([]byte("s3cr3t"), body)))
+
+		var events []AuditEvent
+		Expect(json.Unmarshal(body, &events)).To(Succeed())
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Name).To(Equal("deploy-1"))
+	})
+
+	It("Should shape the payload with a Go template", func() {
+		sink, err := NewHTTPSink(HTTPConfig{
+			URL:           server.URL,
+			Template:      `{{ range .Events }}{{ .Action }} {{ .Namespace }}/{{ .Name }};{{ end }}`,
+			ContentType:   "text/plain",
+			FlushInterval: metav1.Duration{Duration: 50 * time.Millisecond},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("deploy-1", "Update"))
+		Eventually(received).Should(HaveLen(1))
+		Expect(string(received()[0])).To(Equal("Update ns-1/deploy-1;"))
+	})
+
+	It("Should POST CloudEvents batches in structured mode", func() {
+		sink, err := NewHTTPSink(HTTPConfig{
+			URL:           server.URL,
+			CloudEvents:   &CloudEventsConfig{},
+			BatchSize:     2,
+			FlushInterval: metav1.Duration{Duration: time.Hour},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("deploy-1", "Update"))
+		sink.Log(makeEvent("deploy-2", "Create"))
+		Eventually(received).Should(HaveLen(1))
+
+		mu.Lock()
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal(CloudEventsBatchContentType))
+		mu.Unlock()
+
+		var cloudEvents []CloudEvent
+		Expect(json.Unmarshal(received()[0], &cloudEvents)).To(Succeed())
+		Expect(cloudEvents).To(HaveLen(2))
+		Expect(cloudEvents[0].Type).To(Equal("domain.my.audit.deployment.updated"))
+		Expect(cloudEvents[1].Type).To(Equal("domain.my.audit.deployment.created"))
+		Expect(cloudEvents[1].Data.Name).To(Equal("deploy-2"))
+	})
+
+	It("Should POST one CloudEvent per request in binary mode", func() {
+		sink, err := NewHTTPSink(HTTPConfig{
+			URL:           server.URL,
+			CloudEvents:   &CloudEventsConfig{Mode: CloudEventsModeBinary},
+			BatchSize:     2,
+			FlushInterval: metav1.Duration{Duration: time.Hour},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		first := makeEvent("deploy-1", "Update")
+		first.Watch = "ns-1/my-watch"
+		sink.Log(first)
+		sink.Log(makeEvent("deploy-2", "Delete"))
+		Eventually(received).Should(HaveLen(2))
+
+		mu.Lock()
+		req := requests[0]
+		mu.Unlock()
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(req.Header.Get("ce-specversion")).To(Equal("1.0"))
+		Expect(req.Header.Get("ce-id")).To(Equal(first.ID()))
+		Expect(req.Header.Get("ce-type")).To(Equal("domain.my.audit.deployment.updated"))
+		Expect(req.Header.Get("ce-source")).To(Equal("/apis/audit.my.domain/v1alpha1/namespaces/ns-1/watches/my-watch"))
+		Expect(req.Header.Get("ce-subject")).To(Equal("/apis/apps/v1/namespaces/ns-1/deployments/deploy-1"))
+
+		var event AuditEvent
+		Expect(json.Unmarshal(received()[0], &event)).To(Succeed())
+		Expect(event.Name).To(Equal("deploy-1"))
+	})
+
+	It("Should reject CloudEvents with a template", func() {
+		_, err := NewHTTPSink(HTTPConfig{URL: server.URL, CloudEvents: &CloudEventsConfig{}, JSONEnvelope: "events"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewHTTPSink(HTTPConfig{URL: server.URL, CloudEvents: &CloudEventsConfig{Mode: "batched"}})
+		Expect(err).To(MatchError(ContainSubstring("unsupported cloudevents mode")))
+	})
+
+	It("Should retry failed requests", func() {
+		status.Store(http.StatusServiceUnavailable)
+		maxRetries := 3
+		sink, err := NewHTTPSink(HTTPConfig{
+			URL:           server.URL,
+			JSONEnvelope:  "events",
+			FlushInterval: metav1.Duration{Duration: 50 * time.Millisecond},
+			MaxRetries:    &maxRetries,
+			RetryBackoff:  metav1.Duration{Duration: 20 * time.Millisecond},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("deploy-1", "Update"))
+		Eventually(received).Should(HaveLen(2))
+		status.Store(http.StatusOK)
+		Eventually(received).Should(HaveLen(3))
+		Consistently(received, 200*time.Millisecond).Should(HaveLen(3))
+		Expect(string(received()[2])).To(HavePrefix(`{"events":[`))
+	})
+
+	It("Should write undeliverable events to the dead letter file", func() {
+		status.Store(http.StatusBadRequest)
+		deadLetterPath := filepath.Join(GinkgoT().TempDir(), "dead-letter.ndjson")
+		sink, err := NewHTTPSink(HTTPConfig{
+			URL:            server.URL,
+			FlushInterval:  metav1.Duration{Duration: 50 * time.Millisecond},
+			DeadLetterPath: deadLetterPath,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		sink.Log(makeEvent("deploy-1", "Update"))
+		Eventually(received).Should(HaveLen(1))
+		stop()
+
+		By("not retrying client errors")
+		Expect(received()).To(HaveLen(1))
+
+		content, err := os.ReadFile(deadLetterPath)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(ContainSubstring(`"name":"deploy-1"`))
+	})
+})
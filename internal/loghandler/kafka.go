@@ -0,0 +1,252 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KafkaConfig configures a KafkaSink.
+type KafkaConfig struct {
+	// Brokers are the seed brokers. e.g (kafka-0.kafka:9092)
+	Brokers []string `json:"brokers"`
+	// Topic is a Go template of the topic an event is produced to. e.g (watchman.{{ .Namespace }})
+	Topic string `json:"topic"`
+	// ClientID of the producer. Defaults to watchman
+	ClientID string `json:"clientID,omitempty"`
+	// Compression codec of the batches. One of none, gzip, snappy, lz4, zstd. Defaults to snappy
+	Compression string `json:"compression,omitempty"`
+	// DisableIdempotence turns the idempotent producer off, e.g for clusters denying IDEMPOTENT_WRITE
+	DisableIdempotence bool `json:"disableIdempotence,omitempty"`
+	// Linger is how long a batch waits for more records before being produced
+	Linger metav1.Duration `json:"linger,omitempty"`
+	// MaxBufferedRecords is the number of records buffered while waiting to be produced, events logged while it is
+	// full go to the dead letter file. Defaults to 10000
+	MaxBufferedRecords int `json:"maxBufferedRecords,omitempty"`
+	// DeliveryTimeout is how long an event is retried before going to the dead letter file. Defaults to 30s
+	DeliveryTimeout metav1.Duration `json:"deliveryTimeout,omitempty"`
+	// FlushTimeout is how long buffered events are given to be produced on shutdown. Defaults to 10s
+	FlushTimeout metav1.Duration `json:"flushTimeout,omitempty"`
+	// CloudEvents produces the events as CloudEvents, with the attributes as ce_ headers in binary mode
+	CloudEvents *CloudEventsConfig `json:"cloudEvents,omitempty"`
+
+	SASL *KafkaSASLConfig `json:"sasl,omitempty"`
+	TLS  *TLSConfig       `json:"tls,omitempty"`
+
+	// DeadLetterPath is the file events failing to be produced are appended to, one event per line
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// KafkaSASLConfig configures SASL authentication to the brokers.
+type KafkaSASLConfig struct {
+	// Mechanism is one of PLAIN, SCRAM-SHA-256, SCRAM-SHA-512
+	Mechanism string `json:"mechanism"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// KafkaSink is a Provider producing every event to Kafka, keyed by the UID of the resource so events on a resource
+// stay ordered. Start must be running; it flushes buffered events once its context is done.
+type KafkaSink struct {
+	cfg    KafkaConfig
+	client *kgo.Client
+	topic  *template.Template
+	mu     sync.Mutex // guards the dead letter file
+}
+
+func NewKafkaSink(cfg KafkaConfig) (*KafkaSink, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka sink brokers are required")
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka sink topic is required")
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "watchman"
+	}
+	if cfg.FlushTimeout.Duration <= 0 {
+		cfg.FlushTimeout.Duration = 10 * time.Second
+	}
+	if cfg.DeliveryTimeout.Duration <= 0 {
+		cfg.DeliveryTimeout.Duration = 30 * time.Second
+	}
+	if err := cfg.CloudEvents.validate(); err != nil {
+		return nil, err
+	}
+
+	topic, err := template.New("topic").Option("missingkey=error").Parse(cfg.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka sink topic: %w", err)
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ClientID(cfg.ClientID),
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+		kgo.RecordDeliveryTimeout(cfg.DeliveryTimeout.Duration),
+	}
+
+	codec, err := kafkaCompression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, kgo.ProducerBatchCompression(codec))
+
+	if cfg.DisableIdempotence {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	} else {
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	if cfg.Linger.Duration > 0 {
+		opts = append(opts, kgo.ProducerLinger(cfg.Linger.Duration))
+	}
+	if cfg.MaxBufferedRecords > 0 {
+		opts = append(opts, kgo.MaxBufferedRecords(cfg.MaxBufferedRecords))
+	}
+
+	if cfg.SASL != nil {
+		mechanism, err := kafkaSASL(cfg.SASL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaSink{cfg: cfg, client: client, topic: topic}, nil
+}
+
+// Log produces event asynchronously without blocking. Events failing to be produced within the delivery timeout, or
+// logged while the buffer is full, go to the dead letter file.
+func (k *KafkaSink) Log(event AuditEvent) {
+	record, err := k.record(event)
+	if err != nil {
+		k.deadLetter(event, err)
+		return
+	}
+
+	k.client.TryProduce(context.Background(), record, func(_ *kgo.Record, err error) {
+		if err != nil {
+			k.deadLetter(event, err)
+			return
+		}
+		trackDelivery(SinkTypeKafka, DeliveryDelivered, 1)
+	})
+}
+
+// Start waits for ctx to be done then flushes buffered events and closes the client.
+func (k *KafkaSink) Start(ctx context.Context) error {
+	<-ctx.Done()
+	defer k.client.Close()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), k.cfg.FlushTimeout.Duration)
+	defer cancel()
+	return k.client.Flush(flushCtx)
+}
+
+func (k *KafkaSink) record(event AuditEvent) (*kgo.Record, error) {
+	topic := &bytes.Buffer{}
+	if err := k.topic.Execute(topic, event); err != nil {
+		return nil, err
+	}
+
+	record := &kgo.Record{
+		Topic: topic.String(),
+		Headers: []kgo.RecordHeader{
+			{Key: "action", Value: []byte(event.Action)},
+			{Key: "kind", Value: []byte(event.Kind)},
+			{Key: "namespace", Value: []byte(event.Namespace)},
+		},
+	}
+
+	var err error
+	switch {
+	case k.cfg.CloudEvents.Binary():
+		cloudEvent := NewCloudEvent(event)
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: "content-type", Value: []byte(cloudEvent.DataContentType)})
+		for _, attribute := range cloudEvent.Attributes() {
+			record.Headers = append(record.Headers, kgo.RecordHeader{Key: "ce_" + attribute[0], Value: []byte(attribute[1])})
+		}
+		record.Value, err = json.Marshal(event)
+	case k.cfg.CloudEvents != nil:
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: "content-type", Value: []byte(CloudEventsContentType)})
+		record.Value, err = json.Marshal(NewCloudEvent(event))
+	default:
+		record.Value, err = json.Marshal(event)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if event.UID != "" {
+		record.Key = []byte(event.UID)
+	}
+	return record, nil
+}
+
+func (k *KafkaSink) deadLetter(event AuditEvent, reason error) {
+	loghandlerlog.Error(reason, "Failed to produce audit event", "Sink", "kafka", "Name", event.Name, "Namespace", event.Namespace)
+	trackDelivery(SinkTypeKafka, DeliveryFailed, 1)
+	if k.cfg.DeadLetterPath == "" {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := appendNDJSON(k.cfg.DeadLetterPath, []AuditEvent{event}); err != nil {
+		loghandlerlog.Error(err, "Failed to write dead letter file", "Path", k.cfg.DeadLetterPath)
+	}
+}
+
+func kafkaCompression(name string) (kgo.CompressionCodec, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return kgo.SnappyCompression(), nil
+	case "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	default:
+		return kgo.CompressionCodec{}, fmt.Errorf("unsupported kafka compression %q", name)
+	}
+}
+
+func kafkaSASL(cfg *KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToUpper(cfg.Mechanism) {
+	case "PLAIN":
+		return plain.Auth{User: cfg.Username, Pass: cfg.Password}.AsMechanism(), nil
+	case "SCRAM-SHA-256":
+		return scram.Auth{User: cfg.Username, Pass: cfg.Password}.AsSha256Mechanism(), nil
+	case "SCRAM-SHA-512":
+		return scram.Auth{User: cfg.Username, Pass: cfg.Password}.AsSha512Mechanism(), nil
+	default:
+		return nil, fmt.Errorf("unsupported kafka sasl mechanism %q", cfg.Mechanism)
+	}
+}
@@ -0,0 +1,165 @@
+package loghandler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KafkaSink", func() {
+	var cluster *kfake.Cluster
+
+	BeforeEach(func() {
+		var err error
+		cluster, err = kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, "watchman.ns-1"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	consume := func(topic string, n int) []*kgo.Record {
+		client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics(topic),
+			kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+		Expect(err).NotTo(HaveOccurred())
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var records []*kgo.Record
+		for len(records) < n {
+			fetches := client.PollFetches(ctx)
+			Expect(fetches.Errors()).To(BeEmpty())
+			records = append(records, fetches.Records()...)
+		}
+		return records
+	}
+
+	It("Should produce events to the templated topic keyed by UID", func() {
+		sink, err := NewKafkaSink(KafkaConfig{
+			Brokers:     cluster.ListenAddrs(),
+			Topic:       "watchman.{{ .Namespace }}",
+			Compression: "zstd",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+
+		sink.Log(makeEvent("app-1", "Update"))
+		sink.Log(makeEvent("app-2", "Update"))
+		sink.Log(makeEvent("app-1", "Delete"))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		records := consume("watchman.ns-1", 3)
+		Expect(records).To(HaveLen(3))
+
+		partitions := map[string]int32{}
+		var actions []string
+		for _, record := range records {
+			var event AuditEvent
+			Expect(json.Unmarshal(record.Value, &event)).To(Succeed())
+			Expect(string(record.Key)).To(Equal(event.UID))
+
+			// Events on the same resource land on the same partition, in order
+			if partition, ok := partitions[event.UID]; ok {
+				Expect(record.Partition).To(Equal(partition))
+			}
+			partitions[event.UID] = record.Partition
+			if event.UID == "uid-app-1" {
+				actions = append(actions, event.Action)
+			}
+		}
+		Expect(actions).To(Equal([]string{"Update", "Delete"}))
+	})
+
+	It("Should produce CloudEvents in binary mode with ce_ headers", func() {
+		sink, err := NewKafkaSink(KafkaConfig{
+			Brokers:     cluster.ListenAddrs(),
+			Topic:       "watchman.{{ .Namespace }}",
+			CloudEvents: &CloudEventsConfig{Mode: CloudEventsModeBinary},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+
+		sink.Log(makeEvent("app-1", "Update"))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		records := consume("watchman.ns-1", 1)
+		headers := map[string]string{}
+		for _, header := range records[0].Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		Expect(headers).To(HaveKeyWithValue("content-type", "application/json"))
+		Expect(headers).To(HaveKeyWithValue("ce_specversion", "1.0"))
+		Expect(headers).To(HaveKeyWithValue("ce_type", "domain.my.audit.deployment.updated"))
+		Expect(headers).To(HaveKeyWithValue("ce_subject", "/apis/apps/v1/namespaces/ns-1/deployments/app-1"))
+
+		var event AuditEvent
+		Expect(json.Unmarshal(records[0].Value, &event)).To(Succeed())
+		Expect(event.Name).To(Equal("app-1"))
+	})
+
+	It("Should dead-letter events without blocking while the brokers are down", func() {
+		deadLetter := filepath.Join(GinkgoT().TempDir(), "dead-letter.ndjson")
+		brokers := cluster.ListenAddrs()
+		cluster.Close()
+
+		sink, err := NewKafkaSink(KafkaConfig{
+			Brokers:            brokers,
+			Topic:              "watchman.{{ .Namespace }}",
+			MaxBufferedRecords: 1,
+			DeliveryTimeout:    metav1.Duration{Duration: time.Second},
+			DeadLetterPath:     deadLetter,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer sink.client.Close()
+
+		logged := make(chan struct{})
+		go func() {
+			for i := 0; i < 5; i++ {
+				sink.Log(makeEvent(fmt.Sprintf("app-%d", i), "Update"))
+			}
+			close(logged)
+		}()
+		Eventually(logged).WithTimeout(time.Second).Should(BeClosed())
+
+		Eventually(func() ([]AuditEvent, error) {
+			return readNDJSON(deadLetter)
+		}).WithTimeout(5 * time.Second).Should(HaveLen(5))
+	})
+
+	It("Should reject an unsupported compression or SASL mechanism", func() {
+		_, err := NewKafkaSink(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "audit", Compression: "brotli"})
+		Expect(err).To(MatchError(ContainSubstring("unsupported kafka compression")))
+
+		_, err = NewKafkaSink(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "audit",
+			SASL: &KafkaSASLConfig{Mechanism: "GSSAPI"}})
+		Expect(err).To(MatchError(ContainSubstring("unsupported kafka sasl mechanism")))
+	})
+})
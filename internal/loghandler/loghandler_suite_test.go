@@ -0,0 +1,47 @@
+package loghandler
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestLogHandler(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "LogHandler Suite")
+}
+
+var _ = BeforeSuite(func() {
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+})
+
+// recorder is a Provider keeping every event it receives.
+type recorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recorder) Log(event AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) Events() []AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]AuditEvent{}, r.events...)
+}
+
+func makeEvent(name, action string) AuditEvent {
+	data := NewData("Deployment")
+	Expect(data.AddChange(".spec.replicas", 1, 3)).To(Succeed())
+	event := AuditEvent{Kind: data.Kind(), Name: name, Namespace: "ns-1", UID: "uid-" + name, Action: action, Changes: data.Changes()}
+	return event
+}
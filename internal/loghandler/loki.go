@@ -0,0 +1,361 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"google.golang.org/protobuf/encoding/protowire"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Event fields that can be used as Loki stream labels.
+const (
+	LokiLabelNamespace = "namespace"
+	LokiLabelKind      = "kind"
+	LokiLabelAction    = "action"
+	LokiLabelWatch     = "watch"
+)
+
+// LokiConfig configures a LokiSink.
+type LokiConfig struct {
+	// URL of the push API. e.g (http://loki-gateway.logging/loki/api/v1/push)
+	URL string `json:"url"`
+	// TenantID is sent as X-Scope-OrgID for multi-tenant Loki
+	TenantID string            `json:"tenantID,omitempty"`
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	TLS      *TLSConfig        `json:"tls,omitempty"`
+
+	// Labels are the event fields used as stream labels, any of namespace, kind, action, watch. Defaults to all of them
+	Labels []string `json:"labels,omitempty"`
+	// StaticLabels are added to every stream. Defaults to job: watchman
+	StaticLabels map[string]string `json:"staticLabels,omitempty"`
+
+	// BatchSize is the maximum number of events sent in a single push. Defaults to 500
+	BatchSize int `json:"batchSize,omitempty"`
+	// FlushInterval is the maximum time an event waits for its batch to fill up. Defaults to 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+	// QueueSize is the number of events buffered while waiting to be sent. Defaults to 5000
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout of a single push. Defaults to 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// MaxRetries is the number of times a failed push is retried. Defaults to 5
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the base of the exponential backoff between retries, jitter added. Defaults to 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+	// DeadLetterPath is the file batches are appended to, one event per line, once retries are exhausted
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// LokiSink is a Provider pushing events to Loki as snappy compressed protobuf. Events of a batch are grouped in
+// streams by their labels; name, uid and actor are sent as structured metadata to keep the stream cardinality low.
+// Start must be running.
+type LokiSink struct {
+	cfg    LokiConfig
+	client *http.Client
+	queue  chan AuditEvent
+	mu     sync.Mutex // guards the dead letter file
+}
+
+// lokiStream is the batch of entries of a single stream.
+type lokiStream struct {
+	labels  string
+	entries []AuditEvent
+}
+
+func NewLokiSink(cfg LokiConfig) (*LokiSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("loki sink url is required")
+	}
+	if len(cfg.Labels) == 0 {
+		cfg.Labels = []string{LokiLabelNamespace, LokiLabelKind, LokiLabelAction, LokiLabelWatch}
+	}
+	for _, label := range cfg.Labels {
+		switch label {
+		case LokiLabelNamespace, LokiLabelKind, LokiLabelAction, LokiLabelWatch:
+		default:
+			return nil, fmt.Errorf("unsupported loki label %q", label)
+		}
+	}
+	if cfg.StaticLabels == nil {
+		cfg.StaticLabels = map[string]string{"job": "watchman"}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval.Duration <= 0 {
+		cfg.FlushInterval.Duration = 5 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 5000
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	if cfg.MaxRetries == nil {
+		maxRetries := 5
+		cfg.MaxRetries = &maxRetries
+	}
+	if cfg.RetryBackoff.Duration <= 0 {
+		cfg.RetryBackoff.Duration = time.Second
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &LokiSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout.Duration, Transport: transport},
+		queue:  make(chan AuditEvent, cfg.QueueSize),
+	}, nil
+}
+
+// Log queues event to be pushed. The event goes to the dead letter file straight away if the queue is full.
+func (l *LokiSink) Log(event AuditEvent) {
+	select {
+	case l.queue <- event:
+	default:
+		l.deadLetter([]AuditEvent{event}, fmt.Errorf("queue full"))
+	}
+}
+
+// Start pushes queued events in batches until ctx is done, then flushes what is left.
+func (l *LokiSink) Start(ctx context.Context) error {
+	ticker := time.NewTicker(l.cfg.FlushInterval.Duration)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, l.cfg.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		l.send(ctx, batch)
+		batch = make([]AuditEvent, 0, l.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case event := <-l.queue:
+			batch = append(batch, event)
+			if len(batch) >= l.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-l.queue:
+					batch = append(batch, event)
+				default:
+					flushCtx, cancel := context.WithTimeout(context.Background(), l.cfg.Timeout.Duration)
+					flush(flushCtx)
+					cancel()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (l *LokiSink) send(ctx context.Context, batch []AuditEvent) {
+	body, err := l.encode(l.streams(batch))
+	if err != nil {
+		l.deadLetter(batch, err)
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := l.push(ctx, body)
+		if err == nil {
+			trackDelivery(SinkTypeLoki, DeliveryDelivered, len(batch))
+			return
+		}
+
+		if !retry || attempt >= *l.cfg.MaxRetries {
+			l.deadLetter(batch, err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff(l.cfg.RetryBackoff.Duration, attempt)):
+		case <-ctx.Done():
+			l.deadLetter(batch, ctx.Err())
+			return
+		}
+	}
+}
+
+// push sends body and reports whether a failed push is worth retrying.
+func (l *LokiSink) push(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range l.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if l.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.cfg.TenantID)
+	}
+	if l.cfg.Username != "" {
+		req.SetBasicAuth(l.cfg.Username, l.cfg.Password)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return retryableStatus(resp.StatusCode), fmt.Errorf("unexpected status %s from %s: %s", resp.Status, l.cfg.URL,
+		strings.TrimSpace(string(message)))
+}
+
+// streams groups batch by stream labels, streams sorted by labels and entries by timestamp.
+func (l *LokiSink) streams(batch []AuditEvent) []lokiStream {
+	index := map[string]int{}
+	var streams []lokiStream
+	for _, event := range batch {
+		labels := l.labels(event)
+		i, ok := index[labels]
+		if !ok {
+			i = len(streams)
+			index[labels] = i
+			streams = append(streams, lokiStream{labels: labels})
+		}
+		streams[i].entries = append(streams[i].entries, event)
+	}
+
+	sort.Slice(streams, func(i, j int) bool { return streams[i].labels < streams[j].labels })
+	for _, stream := range streams {
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].Timestamp.Before(stream.entries[j].Timestamp)
+		})
+	}
+	return streams
+}
+
+// labels renders the stream labels of event in the Prometheus format. e.g ({action="Update", job="watchman"})
+// Labels with an empty value are left out, as Loki does.
+func (l *LokiSink) labels(event AuditEvent) string {
+	values := map[string]string{}
+	for k, v := range l.cfg.StaticLabels {
+		values[k] = v
+	}
+	for _, label := range l.cfg.Labels {
+		switch label {
+		case LokiLabelNamespace:
+			values[label] = event.Namespace
+		case LokiLabelKind:
+			values[label] = event.Kind
+		case LokiLabelAction:
+			values[label] = event.Action
+		case LokiLabelWatch:
+			values[label] = event.Watch
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(values[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// encode renders streams as a snappy compressed logproto.PushRequest.
+func (l *LokiSink) encode(streams []lokiStream) ([]byte, error) {
+	var request []byte
+	for _, stream := range streams {
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.BytesType)
+		s = protowire.AppendString(s, stream.labels)
+
+		for _, event := range stream.entries {
+			line, err := json.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			s = protowire.AppendTag(s, 2, protowire.BytesType)
+			s = protowire.AppendBytes(s, lokiEntry(event, line))
+		}
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, s)
+	}
+	return s2.EncodeSnappy(nil, request), nil
+}
+
+// lokiEntry encodes a logproto.EntryAdapter of event with line as log line.
+func lokiEntry(event AuditEvent, line []byte) []byte {
+	var timestamp []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(event.Timestamp.Unix()))
+	timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(event.Timestamp.Nanosecond()))
+
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, timestamp)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, line)
+
+	for _, metadata := range [][2]string{{"name", event.Name}, {"uid", event.UID}, {"actor", event.Actor}} {
+		if metadata[1] == "" {
+			continue
+		}
+		var pair []byte
+		pair = protowire.AppendTag(pair, 1, protowire.BytesType)
+		pair = protowire.AppendString(pair, metadata[0])
+		pair = protowire.AppendTag(pair, 2, protowire.BytesType)
+		pair = protowire.AppendString(pair, metadata[1])
+
+		entry = protowire.AppendTag(entry, 3, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, pair)
+	}
+	return entry
+}
+
+func (l *LokiSink) deadLetter(batch []AuditEvent, reason error) {
+	loghandlerlog.Error(reason, "Failed to push audit events", "Sink", "loki", "URL", l.cfg.URL, "Events", len(batch))
+	trackDelivery(SinkTypeLoki, DeliveryFailed, len(batch))
+	if l.cfg.DeadLetterPath == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := appendNDJSON(l.cfg.DeadLetterPath, batch); err != nil {
+		loghandlerlog.Error(err, "Failed to write dead letter file", "Path", l.cfg.DeadLetterPath)
+	}
+}
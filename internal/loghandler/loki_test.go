@@ -0,0 +1,212 @@
+package loghandler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pushedStream is a stream of a push request decoded by the Loki stand-in.
+type pushedStream struct {
+	Labels  string
+	Entries []pushedEntry
+}
+
+type pushedEntry struct {
+	Timestamp time.Time
+	Event     AuditEvent
+	Metadata  map[string]string
+}
+
+// fields decodes the length delimited fields of a protobuf message by field number.
+func fields(message []byte) map[protowire.Number][][]byte {
+	decoded := map[protowire.Number][][]byte{}
+	for len(message) > 0 {
+		number, typ, n := protowire.ConsumeTag(message)
+		Expect(n).To(BeNumerically(">", 0))
+		message = message[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(message)
+			Expect(n).To(BeNumerically(">", 0))
+			decoded[number] = append(decoded[number], value)
+			message = message[n:]
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(message)
+			Expect(n).To(BeNumerically(">", 0))
+			decoded[number] = append(decoded[number], protowire.AppendVarint(nil, value))
+			message = message[n:]
+		default:
+			Fail("unexpected wire type")
+		}
+	}
+	return decoded
+}
+
+func varint(raw []byte) int64 {
+	value, _ := protowire.ConsumeVarint(raw)
+	return int64(value)
+}
+
+func decodePush(body []byte) []pushedStream {
+	raw, err := s2.Decode(nil, body)
+	Expect(err).NotTo(HaveOccurred())
+
+	var streams []pushedStream
+	for _, s := range fields(raw)[1] {
+		stream := fields(s)
+		pushed := pushedStream{Labels: string(stream[1][0])}
+		for _, e := range stream[2] {
+			entry := fields(e)
+			timestamp := fields(entry[1][0])
+			pushedEntry := pushedEntry{
+				Timestamp: time.Unix(varint(timestamp[1][0]), varint(timestamp[2][0])).UTC(),
+				Metadata:  map[string]string{},
+			}
+			Expect(json.Unmarshal(entry[2][0], &pushedEntry.Event)).To(Succeed())
+			for _, m := range entry[3] {
+				pair := fields(m)
+				pushedEntry.Metadata[string(pair[1][0])] = string(pair[2][0])
+			}
+			pushed.Entries = append(pushed.Entries, pushedEntry)
+		}
+		streams = append(streams, pushed)
+	}
+	return streams
+}
+
+var _ = Describe("LokiSink", func() {
+	var (
+		server   *httptest.Server
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		failures atomic.Int32
+		cancel   context.CancelFunc
+		done     chan struct{}
+	)
+
+	BeforeEach(func() {
+		requests, bodies = nil, nil
+		failures.Store(0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			mu.Unlock()
+			if failures.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	start := func(sink *LokiSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	received := func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]byte{}, bodies...)
+	}
+
+	It("Should push batches grouped by stream with structured metadata", func() {
+		sink, err := NewLokiSink(LokiConfig{
+			URL:           server.URL,
+			TenantID:      "platform",
+			BatchSize:     3,
+			FlushInterval: metav1.Duration{Duration: time.Hour},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		first := makeEvent("app-1", "Update")
+		first.Actor = "kubectl-edit"
+		first.Watch = "ns-1/prod"
+		first.Timestamp = time.Date(2024, 10, 18, 12, 0, 0, 500, time.UTC)
+		second := makeEvent("app-2", "Update")
+		second.Watch = "ns-1/prod"
+		second.Timestamp = first.Timestamp.Add(time.Second)
+		other := makeEvent("app-3", "Delete")
+		other.Namespace = "ns-2"
+		sink.Log(first)
+		sink.Log(second)
+		sink.Log(other)
+
+		Eventually(received).Should(HaveLen(1))
+		mu.Lock()
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(requests[0].Header.Get("X-Scope-OrgID")).To(Equal("platform"))
+		mu.Unlock()
+
+		streams := decodePush(received()[0])
+		Expect(streams).To(HaveLen(2))
+		Expect(streams[0].Labels).To(Equal(`{action="Delete", job="watchman", kind="Deployment", namespace="ns-2"}`))
+		Expect(streams[1].Labels).To(Equal(`{action="Update", job="watchman", kind="Deployment", namespace="ns-1", watch="ns-1/prod"}`))
+
+		entries := streams[1].Entries
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Timestamp).To(Equal(first.Timestamp))
+		Expect(entries[0].Event.Name).To(Equal("app-1"))
+		Expect(entries[0].Metadata).To(Equal(map[string]string{"name": "app-1", "uid": "uid-app-1", "actor": "kubectl-edit"}))
+		Expect(entries[1].Event.Name).To(Equal("app-2"))
+	})
+
+	It("Should retry pushes rejected with a server error", func() {
+		failures.Store(2)
+		sink, err := NewLokiSink(LokiConfig{
+			URL:          server.URL,
+			Labels:       []string{LokiLabelNamespace},
+			BatchSize:    1,
+			RetryBackoff: metav1.Duration{Duration: 10 * time.Millisecond},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("app-1", "Update"))
+		Eventually(received).Should(HaveLen(3))
+		Consistently(received, 100*time.Millisecond).Should(HaveLen(3))
+
+		streams := decodePush(received()[2])
+		Expect(streams[0].Labels).To(Equal(`{job="watchman", namespace="ns-1"}`))
+	})
+
+	It("Should reject unsupported labels", func() {
+		_, err := NewLokiSink(LokiConfig{URL: server.URL, Labels: []string{"name"}})
+		Expect(err).To(MatchError(ContainSubstring("unsupported loki label")))
+	})
+})
@@ -0,0 +1,27 @@
+package loghandler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Delivery results of the events handed to a sink.
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryDuplicate = "duplicate"
+	DeliveryFailed    = "failed"
+)
+
+var sinkEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "watchman_sink_events_total",
+	Help: "Number of audit events handed to a sink, by sink type and delivery result",
+}, []string{"sink", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(sinkEvents)
+}
+
+// trackDelivery records the delivery result of n events sent by a sink. e.g (nats, delivered, 1)
+func trackDelivery(sink, result string, n int) {
+	sinkEvents.WithLabelValues(sink, result).Add(float64(n))
+}
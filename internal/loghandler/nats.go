@@ -0,0 +1,263 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultNATSSubject is the subject template used when none is configured.
+const DefaultNATSSubject = "watchman.{{ .Namespace }}.{{ .Kind }}.{{ .Action }}"
+
+// natsStallWait is how long a publish waits for room while MaxPending acknowledgements are outstanding. It is kept
+// negligible so Log never blocks the audit path; such publishes are written to the dead letter file instead.
+const natsStallWait = time.Millisecond
+
+// NATSConfig configures a NATSSink.
+type NATSConfig struct {
+	// URL of the NATS servers, comma separated. e.g (nats://nats-0.nats:4222,nats://nats-1.nats:4222)
+	URL string `json:"url"`
+	// Subject is a Go template of the subject an event is published to. Empty tokens are replaced by _.
+	// Defaults to watchman.{{ .Namespace }}.{{ .Kind }}.{{ .Action }}
+	Subject string `json:"subject,omitempty"`
+
+	// CredentialsFile is the NATS user credentials file. e.g (/etc/watchman/nats/user.creds)
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// Credentials is the content of a user credentials file, used instead of CredentialsFile
+	Credentials string     `json:"credentials,omitempty"`
+	Token       string     `json:"token,omitempty"`
+	Username    string     `json:"username,omitempty"`
+	Password    string     `json:"password,omitempty"`
+	TLS         *TLSConfig `json:"tls,omitempty"`
+
+	// MaxPending is the number of publishes waiting for their acknowledgement. Defaults to 256
+	MaxPending int `json:"maxPending,omitempty"`
+	// AckTimeout is how long a publish waits for its acknowledgement. Defaults to 5s
+	AckTimeout metav1.Duration `json:"ackTimeout,omitempty"`
+	// CloudEvents publishes the events as CloudEvents, with the attributes as ce- headers in binary mode
+	CloudEvents *CloudEventsConfig `json:"cloudEvents,omitempty"`
+	// DeadLetterPath is the file events failing to be acknowledged are appended to, one event per line
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// NATSSink is a Provider publishing every event to JetStream. Each message carries the ID of the event so the stream
+// drops duplicates within its duplicate window. Acknowledgements are tracked by
+// Start, which must be running, and never by the caller of Log.
+type NATSSink struct {
+	cfg     NATSConfig
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject *template.Template
+	pending chan natsPublish
+	mu      sync.Mutex // guards the dead letter file
+}
+
+type natsPublish struct {
+	event  AuditEvent
+	future jetstream.PubAckFuture
+}
+
+func NewNATSSink(cfg NATSConfig) (*NATSSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("nats sink url is required")
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = DefaultNATSSubject
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = 256
+	}
+	if cfg.AckTimeout.Duration <= 0 {
+		cfg.AckTimeout.Duration = 5 * time.Second
+	}
+	if err := cfg.CloudEvents.validate(); err != nil {
+		return nil, err
+	}
+
+	subject, err := template.New("subject").Option("missingkey=error").Parse(cfg.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats sink subject: %w", err)
+	}
+
+	opts := []nats.Option{
+		nats.Name("watchman"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	switch {
+	case cfg.Credentials != "":
+		userJWT, err := jwt.ParseDecoratedJWT([]byte(cfg.Credentials))
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats sink credentials: %w", err)
+		}
+		keyPair, err := nkeys.ParseDecoratedNKey([]byte(cfg.Credentials))
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats sink credentials: %w", err)
+		}
+		seed, err := keyPair.Seed()
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats sink credentials: %w", err)
+		}
+		opts = append(opts, nats.UserJWTAndSeed(userJWT, string(seed)))
+	case cfg.CredentialsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	if cfg.Username != "" {
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(conn, jetstream.WithPublishAsyncMaxPending(cfg.MaxPending))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &NATSSink{
+		cfg:     cfg,
+		conn:    conn,
+		js:      js,
+		subject: subject,
+		pending: make(chan natsPublish, cfg.MaxPending),
+	}, nil
+}
+
+// Log publishes event asynchronously and never blocks; its acknowledgement is awaited by Start. While MaxPending
+// acknowledgements are already waiting for Start, it is awaited by a goroutine of its own instead. Events failing to
+// be published or acknowledged within AckTimeout are written to the dead letter file.
+func (n *NATSSink) Log(event AuditEvent) {
+	msg, err := n.message(event)
+	if err != nil {
+		n.deadLetter(event, err)
+		return
+	}
+
+	future, err := n.js.PublishMsgAsync(msg, jetstream.WithMsgID(event.ID()), jetstream.WithStallWait(natsStallWait))
+	if err != nil {
+		n.deadLetter(event, err)
+		return
+	}
+
+	publish := natsPublish{event: event, future: future}
+	select {
+	case n.pending <- publish:
+	default:
+		go n.await(publish)
+	}
+}
+
+// Start awaits the acknowledgement of every published event until ctx is done, then drains the connection.
+func (n *NATSSink) Start(ctx context.Context) error {
+	for {
+		select {
+		case publish := <-n.pending:
+			n.await(publish)
+		case <-ctx.Done():
+			for {
+				select {
+				case publish := <-n.pending:
+					n.await(publish)
+				default:
+					return n.conn.Drain()
+				}
+			}
+		}
+	}
+}
+
+func (n *NATSSink) await(publish natsPublish) {
+	timer := time.NewTimer(n.cfg.AckTimeout.Duration)
+	defer timer.Stop()
+
+	select {
+	case ack := <-publish.future.Ok():
+		if ack.Duplicate {
+			trackDelivery(SinkTypeNATS, DeliveryDuplicate, 1)
+			return
+		}
+		trackDelivery(SinkTypeNATS, DeliveryDelivered, 1)
+	case err := <-publish.future.Err():
+		n.deadLetter(publish.event, err)
+	case <-timer.C:
+		n.deadLetter(publish.event, fmt.Errorf("no acknowledgement within %s", n.cfg.AckTimeout.Duration))
+	}
+}
+
+func (n *NATSSink) message(event AuditEvent) (*nats.Msg, error) {
+	subject := &bytes.Buffer{}
+	if err := n.subject.Execute(subject, event); err != nil {
+		return nil, err
+	}
+
+	msg := nats.NewMsg(natsSubject(subject.String()))
+	msg.Header.Set("Content-Type", "application/json")
+
+	var err error
+	switch {
+	case n.cfg.CloudEvents.Binary():
+		for _, attribute := range NewCloudEvent(event).Attributes() {
+			msg.Header.Set("ce-"+attribute[0], attribute[1])
+		}
+		msg.Data, err = json.Marshal(event)
+	case n.cfg.CloudEvents != nil:
+		msg.Header.Set("Content-Type", CloudEventsContentType)
+		msg.Data, err = json.Marshal(NewCloudEvent(event))
+	default:
+		msg.Data, err = json.Marshal(event)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (n *NATSSink) deadLetter(event AuditEvent, reason error) {
+	loghandlerlog.Error(reason, "Failed to publish audit event", "Sink", "nats", "Name", event.Name, "Namespace", event.Namespace)
+	trackDelivery(SinkTypeNATS, DeliveryFailed, 1)
+	if n.cfg.DeadLetterPath == "" {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := appendNDJSON(n.cfg.DeadLetterPath, []AuditEvent{event}); err != nil {
+		loghandlerlog.Error(err, "Failed to write dead letter file", "Path", n.cfg.DeadLetterPath)
+	}
+}
+
+// natsSubject replaces the empty tokens of subject, e.g the namespace of a cluster scoped resource, by _.
+func natsSubject(subject string) string {
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		if token == "" {
+			tokens[i] = "_"
+		}
+	}
+	return strings.Join(tokens, ".")
+}
@@ -0,0 +1,176 @@
+package loghandler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("NATSSink", func() {
+	var (
+		srv    *server.Server
+		conn   *nats.Conn
+		stream jetstream.Stream
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		srv, err = server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: GinkgoT().TempDir()})
+		Expect(err).NotTo(HaveOccurred())
+		srv.Start()
+		Expect(srv.ReadyForConnections(10 * time.Second)).To(BeTrue())
+
+		conn, err = nats.Connect(srv.ClientURL())
+		Expect(err).NotTo(HaveOccurred())
+		js, err := jetstream.New(conn)
+		Expect(err).NotTo(HaveOccurred())
+		stream, err = js.CreateStream(context.Background(), jetstream.StreamConfig{
+			Name: "WATCHMAN", Subjects: []string{"watchman.>"}, Duplicates: time.Minute,
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		srv.Shutdown()
+	})
+
+	start := func(sink *NATSSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	It("Should publish events to templated subjects and deduplicate them", func() {
+		sink, err := NewNATSSink(NATSConfig{URL: srv.ClientURL()})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		update := makeEvent("app-1", "Update")
+		update.ResourceVersion = "42"
+		sink.Log(update)
+		sink.Log(update) // redelivered, e.g after a requeue
+		remove := makeEvent("app-1", "Delete")
+		remove.ResourceVersion = "42"
+		sink.Log(remove)
+		stop()
+
+		info, err := stream.Info(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.State.Msgs).To(Equal(uint64(2)))
+
+		first, err := stream.GetMsg(context.Background(), 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Subject).To(Equal("watchman.ns-1.Deployment.Update"))
+		Expect(first.Header.Get(jetstream.MsgIDHeader)).To(Equal("uid-app-1:42:Update"))
+
+		var event AuditEvent
+		Expect(json.Unmarshal(first.Data, &event)).To(Succeed())
+		Expect(event.Name).To(Equal("app-1"))
+
+		second, err := stream.GetMsg(context.Background(), 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Subject).To(Equal("watchman.ns-1.Deployment.Delete"))
+	})
+
+	It("Should publish structured CloudEvents", func() {
+		sink, err := NewNATSSink(NATSConfig{URL: srv.ClientURL(), CloudEvents: &CloudEventsConfig{}})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		sink.Log(makeEvent("app-1", "Delete"))
+		stop()
+
+		msg, err := stream.GetMsg(context.Background(), 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msg.Header.Get("Content-Type")).To(Equal(CloudEventsContentType))
+
+		var cloudEvent CloudEvent
+		Expect(json.Unmarshal(msg.Data, &cloudEvent)).To(Succeed())
+		Expect(cloudEvent.Type).To(Equal("domain.my.audit.deployment.deleted"))
+		Expect(cloudEvent.Data.Name).To(Equal("app-1"))
+	})
+
+	It("Should replace empty subject tokens", func() {
+		sink, err := NewNATSSink(NATSConfig{URL: srv.ClientURL()})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		sink.Log(AuditEvent{Kind: "Namespace", Name: "ns-1", UID: "uid-ns-1", Action: "Create"})
+		stop()
+
+		msg, err := stream.GetMsg(context.Background(), 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msg.Subject).To(Equal("watchman._.Namespace.Create"))
+	})
+
+	It("Should write events not acknowledged to the dead letter file", func() {
+		deadLetter := filepath.Join(GinkgoT().TempDir(), "dead-letter.ndjson")
+		sink, err := NewNATSSink(NATSConfig{
+			URL:            srv.ClientURL(),
+			Subject:        "unstreamed.{{ .Name }}",
+			AckTimeout:     metav1.Duration{Duration: time.Second},
+			DeadLetterPath: deadLetter,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		sink.Log(makeEvent("app-1", "Update"))
+		stop()
+
+		raw, err := os.ReadFile(deadLetter)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(raw)).To(ContainSubstring(`"name":"app-1"`))
+	})
+
+	It("Should not block the caller while too many acknowledgements are pending", func() {
+		deadLetter := filepath.Join(GinkgoT().TempDir(), "dead-letter.ndjson")
+		sink, err := NewNATSSink(NATSConfig{
+			URL:            srv.ClientURL(),
+			Subject:        "unstreamed.{{ .Name }}",
+			MaxPending:     1,
+			AckTimeout:     metav1.Duration{Duration: time.Second},
+			DeadLetterPath: deadLetter,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer sink.conn.Close()
+
+		// Start is not running, so the acknowledgement of app-1 stays pending and the others are dead lettered
+		// without Log waiting for them
+		began := time.Now()
+		for _, name := range []string{"app-1", "app-2", "app-3"} {
+			sink.Log(makeEvent(name, "Update"))
+		}
+		Expect(time.Since(began)).To(BeNumerically("<", 500*time.Millisecond))
+
+		Eventually(func(g Gomega) {
+			events, err := readNDJSON(deadLetter)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(events).To(HaveLen(2))
+			g.Expect([]string{events[0].Name, events[1].Name}).To(ConsistOf("app-2", "app-3"))
+		}).Should(Succeed())
+		Expect(sink.pending).To(HaveLen(1))
+	})
+})
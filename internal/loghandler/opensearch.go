@@ -0,0 +1,391 @@
+package loghandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OpenSearchConfig configures an OpenSearchSink.
+type OpenSearchConfig struct {
+	// URL of the cluster. e.g (https://opensearch.logging:9200)
+	URL      string            `json:"url"`
+	Username string            `json:"username,omitempty"`
+	Password string            `json:"password,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	TLS      *TLSConfig        `json:"tls,omitempty"`
+
+	// IndexPrefix of the daily indices events are written to, e.g (watchman-audit-2024.10.18). Defaults to watchman-audit
+	IndexPrefix string `json:"indexPrefix,omitempty"`
+	// IndexDateFormat is the Go time layout of the index date suffix. Defaults to 2006.01.02
+	IndexDateFormat string `json:"indexDateFormat,omitempty"`
+	// SkipIndexTemplate leaves the index template mapping the event fields to be managed outside watchman
+	SkipIndexTemplate bool `json:"skipIndexTemplate,omitempty"`
+
+	// BatchSize is the maximum number of events sent in a single bulk request. Defaults to 500
+	BatchSize int `json:"batchSize,omitempty"`
+	// FlushInterval is the maximum time an event waits for its batch to fill up. Defaults to 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+	// QueueSize is the number of events buffered while waiting to be sent. Defaults to 5000
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout of a single request. Defaults to 30s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// MaxRetries is the number of times rejected events are retried. Defaults to 5
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the base of the exponential backoff between retries, jitter added. Defaults to 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+	// DeadLetterPath is the file events failing to be indexed are appended to, one event per line
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// OpenSearchSink is a Provider writing events to date based OpenSearch indices through the _bulk API. Every event is
+// created with its ID as document ID so retried events are not indexed twice. Events rejected with 429 or a server
+// error, e.g while the cluster pushes back, are retried with backoff; other rejections go to the dead letter file.
+// Start must be running.
+type OpenSearchSink struct {
+	cfg    OpenSearchConfig
+	client *http.Client
+	queue  chan AuditEvent
+	mu     sync.Mutex // guards the dead letter file
+}
+
+func NewOpenSearchSink(cfg OpenSearchConfig) (*OpenSearchSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("opensearch sink url is required")
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	if cfg.IndexPrefix == "" {
+		cfg.IndexPrefix = "watchman-audit"
+	}
+	if cfg.IndexDateFormat == "" {
+		cfg.IndexDateFormat = "2006.01.02"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval.Duration <= 0 {
+		cfg.FlushInterval.Duration = 5 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 5000
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 30 * time.Second
+	}
+	if cfg.MaxRetries == nil {
+		maxRetries := 5
+		cfg.MaxRetries = &maxRetries
+	}
+	if cfg.RetryBackoff.Duration <= 0 {
+		cfg.RetryBackoff.Duration = time.Second
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &OpenSearchSink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout.Duration, Transport: transport},
+		queue:  make(chan AuditEvent, cfg.QueueSize),
+	}, nil
+}
+
+// Log queues event to be indexed. The event goes to the dead letter file straight away if the queue is full.
+func (o *OpenSearchSink) Log(event AuditEvent) {
+	select {
+	case o.queue <- event:
+	default:
+		o.deadLetter([]AuditEvent{event}, fmt.Errorf("queue full"))
+	}
+}
+
+// Start puts the index template then indexes queued events in batches until ctx is done, then flushes what is left.
+func (o *OpenSearchSink) Start(ctx context.Context) error {
+	if !o.cfg.SkipIndexTemplate {
+		if err := o.putIndexTemplate(ctx); err != nil {
+			loghandlerlog.Error(err, "Failed to put index template", "Sink", "opensearch", "URL", o.cfg.URL)
+		}
+	}
+
+	ticker := time.NewTicker(o.cfg.FlushInterval.Duration)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, o.cfg.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		o.send(ctx, batch)
+		batch = make([]AuditEvent, 0, o.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case event := <-o.queue:
+			batch = append(batch, event)
+			if len(batch) >= o.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-o.queue:
+					batch = append(batch, event)
+				default:
+					flushCtx, cancel := context.WithTimeout(context.Background(), o.cfg.Timeout.Duration)
+					flush(flushCtx)
+					cancel()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// send indexes batch, retrying the events rejected for a transient reason.
+func (o *OpenSearchSink) send(ctx context.Context, batch []AuditEvent) {
+	for attempt := 0; ; attempt++ {
+		retry, err := o.bulk(ctx, batch)
+		if len(retry) == 0 {
+			return
+		}
+
+		if attempt >= *o.cfg.MaxRetries {
+			o.deadLetter(retry, err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff(o.cfg.RetryBackoff.Duration, attempt)):
+		case <-ctx.Done():
+			o.deadLetter(retry, ctx.Err())
+			return
+		}
+		batch = retry
+	}
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error,omitempty"`
+}
+
+// bulk sends batch to the _bulk API and returns the events worth retrying along with the reason they failed.
+func (o *OpenSearchSink) bulk(ctx context.Context, batch []AuditEvent) ([]AuditEvent, error) {
+	body := &bytes.Buffer{}
+	sent := make([]AuditEvent, 0, len(batch))
+	for _, event := range batch {
+		doc, err := json.Marshal(event)
+		if err != nil {
+			o.deadLetter([]AuditEvent{event}, err)
+			continue
+		}
+		action, err := json.Marshal(map[string]map[string]string{"create": {"_index": o.index(event), "_id": event.ID()}})
+		if err != nil {
+			o.deadLetter([]AuditEvent{event}, err)
+			continue
+		}
+
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+		sent = append(sent, event)
+	}
+	if len(sent) == 0 {
+		return nil, nil
+	}
+	batch = sent
+
+	resp, err := o.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return batch, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		err = fmt.Errorf("unexpected status %s from %s", resp.Status, o.cfg.URL)
+		if retryableStatus(resp.StatusCode) {
+			return batch, err
+		}
+		o.deadLetter(batch, err)
+		return nil, nil
+	}
+
+	result := bulkResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// The request went through; retrying would only find the events already created.
+		loghandlerlog.Error(err, "Failed to read bulk response", "Sink", "opensearch", "URL", o.cfg.URL)
+		trackDelivery(SinkTypeOpenSearch, DeliveryDelivered, len(batch))
+		return nil, nil
+	}
+	if len(result.Items) != len(batch) {
+		loghandlerlog.Info("Bulk response item count mismatch", "Sink", "opensearch", "Events", len(batch), "Items", len(result.Items))
+		trackDelivery(SinkTypeOpenSearch, DeliveryDelivered, len(batch))
+		return nil, nil
+	}
+
+	var retry []AuditEvent
+	var retryErr error
+	for i, item := range result.Items {
+		// Every item holds the result of its single action. e.g ({"create": {"status": 201}})
+		for _, status := range item {
+			switch {
+			case status.Status >= 200 && status.Status < 300:
+				trackDelivery(SinkTypeOpenSearch, DeliveryDelivered, 1)
+			case status.Status == http.StatusConflict:
+				trackDelivery(SinkTypeOpenSearch, DeliveryDuplicate, 1)
+			case retryableStatus(status.Status):
+				retry = append(retry, batch[i])
+				retryErr = status.err()
+			default:
+				o.deadLetter([]AuditEvent{batch[i]}, status.err())
+			}
+		}
+	}
+	return retry, retryErr
+}
+
+func (s bulkItemResult) err() error {
+	if s.Error == nil {
+		return fmt.Errorf("bulk item status %d", s.Status)
+	}
+	return fmt.Errorf("bulk item status %d: %s: %s", s.Status, s.Error.Type, s.Error.Reason)
+}
+
+func (o *OpenSearchSink) putIndexTemplate(ctx context.Context) error {
+	resp, err := o.do(ctx, http.MethodPut, "/_index_template/"+o.cfg.IndexPrefix, "application/json",
+		strings.NewReader(IndexTemplate(o.cfg.IndexPrefix)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, o.cfg.URL)
+	}
+	return nil
+}
+
+func (o *OpenSearchSink) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.cfg.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range o.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if o.cfg.Username != "" {
+		req.SetBasicAuth(o.cfg.Username, o.cfg.Password)
+	}
+	return o.client.Do(req)
+}
+
+func (o *OpenSearchSink) index(event AuditEvent) string {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return o.cfg.IndexPrefix + "-" + timestamp.UTC().Format(o.cfg.IndexDateFormat)
+}
+
+func (o *OpenSearchSink) deadLetter(batch []AuditEvent, reason error) {
+	loghandlerlog.Error(reason, "Failed to index audit events", "Sink", "opensearch", "URL", o.cfg.URL, "Events", len(batch))
+	trackDelivery(SinkTypeOpenSearch, DeliveryFailed, len(batch))
+	if o.cfg.DeadLetterPath == "" {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := appendNDJSON(o.cfg.DeadLetterPath, batch); err != nil {
+		loghandlerlog.Error(err, "Failed to write dead letter file", "Path", o.cfg.DeadLetterPath)
+	}
+}
+
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+}
+
+// IndexTemplate returns the composable index template mapping the AuditEvent fields for the indices of prefix. Old and
+// new values and patches are kept in the source without being indexed as their type differs from one path to another.
+func IndexTemplate(prefix string) string {
+	return fmt.Sprintf(`{
+  "index_patterns": [%q],
+  "template": {
+    "mappings": {
+      "dynamic": false,
+      "properties": {
+        "kind": {"type": "keyword"},
+        "name": {"type": "keyword"},
+        "namespace": {"type": "keyword"},
+        "uid": {"type": "keyword"},
+        "resourceVersion": {"type": "keyword"},
+        "action": {"type": "keyword"},
+        "actor": {"type": "keyword"},
+        "watch": {"type": "keyword"},
+        "severity": {"type": "keyword"},
+        "severityRules": {"type": "keyword"},
+        "ticket": {"type": "keyword"},
+        "causedBy": {"type": "keyword"},
+        "related": {
+          "type": "nested",
+          "properties": {
+            "kind": {"type": "keyword"},
+            "name": {"type": "keyword"},
+            "relation": {"type": "keyword"}
+          }
+        },
+        "tags": {"type": "keyword"},
+        "timestamp": {"type": "date"},
+        "changes": {
+          "type": "nested",
+          "properties": {
+            "path": {"type": "keyword"},
+            "op": {"type": "keyword"},
+            "oldValue": {"type": "object", "enabled": false},
+            "newValue": {"type": "object", "enabled": false}
+          }
+        },
+        "patch": {"type": "object", "enabled": false},
+        "chain": {
+          "properties": {
+            "id": {"type": "keyword"},
+            "sequence": {"type": "long"},
+            "prevHash": {"type": "keyword"},
+            "hash": {"type": "keyword"},
+            "signature": {"type": "keyword"}
+          }
+        }
+      }
+    }
+  }
+}`, prefix+"-*")
+}
@@ -0,0 +1,202 @@
+package loghandler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// bulkAction is a single action of a _bulk request received by the OpenSearch stand-in.
+type bulkAction struct {
+	Index string
+	ID    string
+	Event AuditEvent
+}
+
+var _ = Describe("OpenSearchSink", func() {
+	var (
+		server    *httptest.Server
+		mu        sync.Mutex
+		templates map[string][]byte
+		requests  [][]bulkAction
+		// respond returns the status of the bulk request and of each of its items
+		respond func(attempt int, actions []bulkAction) (int, []int)
+		cancel  context.CancelFunc
+		done    chan struct{}
+	)
+
+	BeforeEach(func() {
+		templates, requests = map[string][]byte{}, nil
+		respond = func(int, []bulkAction) (int, []int) { return http.StatusOK, nil }
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := &bytes.Buffer{}
+			_, _ = body.ReadFrom(r.Body)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_index_template/") {
+				templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = body.Bytes()
+				return
+			}
+
+			Expect(r.URL.Path).To(Equal("/_bulk"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+
+			var actions []bulkAction
+			scanner := bufio.NewScanner(body)
+			for scanner.Scan() {
+				var action map[string]map[string]string
+				Expect(json.Unmarshal(scanner.Bytes(), &action)).To(Succeed())
+				Expect(scanner.Scan()).To(BeTrue())
+				var event AuditEvent
+				Expect(json.Unmarshal(scanner.Bytes(), &event)).To(Succeed())
+				actions = append(actions, bulkAction{Index: action["create"]["_index"], ID: action["create"]["_id"], Event: event})
+			}
+			requests = append(requests, actions)
+
+			status, items := respond(len(requests), actions)
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+
+			result := bulkResponse{}
+			for i := range actions {
+				item := bulkItemResult{Status: http.StatusCreated}
+				if i < len(items) {
+					item.Status = items[i]
+				}
+				if item.Status >= 300 {
+					result.Errors = true
+					item.Error = &struct {
+						Type   string `json:"type"`
+						Reason string `json:"reason"`
+					}{Type: "rejected", Reason: fmt.Sprintf("status %d", item.Status)}
+				}
+				result.Items = append(result.Items, map[string]bulkItemResult{"create": item})
+			}
+			_ = json.NewEncoder(w).Encode(result)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	start := func(sink *OpenSearchSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	received := func() [][]bulkAction {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]bulkAction{}, requests...)
+	}
+
+	It("Should put the index template and create events in date based indices", func() {
+		sink, err := NewOpenSearchSink(OpenSearchConfig{URL: server.URL, BatchSize: 2, FlushInterval: metav1.Duration{Duration: time.Hour}})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		event := makeEvent("app-1", "Update")
+		event.Timestamp = time.Date(2024, 10, 18, 23, 0, 0, 0, time.UTC)
+		sink.Log(event)
+		sink.Log(makeEvent("app-2", "Update"))
+
+		Eventually(received).Should(HaveLen(1))
+		actions := received()[0]
+		Expect(actions[0].Index).To(Equal("watchman-audit-2024.10.18"))
+		Expect(actions[0].ID).To(Equal(event.ID()))
+		Expect(actions[0].Event.Name).To(Equal("app-1"))
+		Expect(actions[1].Event.Name).To(Equal("app-2"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(templates).To(HaveKey("watchman-audit"))
+		var template map[string]interface{}
+		Expect(json.Unmarshal(templates["watchman-audit"], &template)).To(Succeed())
+		Expect(template["index_patterns"]).To(Equal([]interface{}{"watchman-audit-*"}))
+	})
+
+	It("Should map the severity, tags, ticket and related objects of events", func() {
+		var template struct {
+			Template struct {
+				Mappings struct {
+					Properties map[string]interface{} `json:"properties"`
+				} `json:"mappings"`
+			} `json:"template"`
+		}
+		Expect(json.Unmarshal([]byte(IndexTemplate("watchman-audit")), &template)).To(Succeed())
+		for _, field := range []string{"severity", "severityRules", "tags", "ticket", "causedBy", "related"} {
+			Expect(template.Template.Mappings.Properties).To(HaveKey(field))
+		}
+	})
+
+	It("Should retry rejected items only and dead letter the ones that cannot be indexed", func() {
+		deadLetter := filepath.Join(GinkgoT().TempDir(), "dead-letter.ndjson")
+		respond = func(attempt int, actions []bulkAction) (int, []int) {
+			switch attempt {
+			case 1:
+				return http.StatusTooManyRequests, nil
+			case 2:
+				return http.StatusOK, []int{http.StatusCreated, http.StatusTooManyRequests, http.StatusBadRequest, http.StatusConflict}
+			default:
+				return http.StatusOK, nil
+			}
+		}
+
+		sink, err := NewOpenSearchSink(OpenSearchConfig{
+			URL:            server.URL,
+			BatchSize:      4,
+			FlushInterval:  metav1.Duration{Duration: time.Hour},
+			RetryBackoff:   metav1.Duration{Duration: 10 * time.Millisecond},
+			DeadLetterPath: deadLetter,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		for _, name := range []string{"app-1", "app-2", "app-3", "app-4"} {
+			sink.Log(makeEvent(name, "Update"))
+		}
+
+		Eventually(received).Should(HaveLen(3))
+		Expect(received()[1]).To(HaveLen(4))
+		retried := received()[2]
+		Expect(retried).To(HaveLen(1))
+		Expect(retried[0].Event.Name).To(Equal("app-2"))
+
+		raw, err := os.ReadFile(deadLetter)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Count(string(raw), "\n")).To(Equal(1))
+		Expect(string(raw)).To(ContainSubstring(`"name":"app-3"`))
+	})
+})
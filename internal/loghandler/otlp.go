@@ -0,0 +1,479 @@
+package loghandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OTLP export protocols.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// otlpScope is the instrumentation scope of the log records emitted by watchman.
+const otlpScope = "github.com/vandathron/watchman"
+
+// otlpKindAttributes are the Kubernetes semantic convention resource attributes of the kinds having one.
+var otlpKindAttributes = map[string]string{
+	"Pod":         "k8s.pod",
+	"Deployment":  "k8s.deployment",
+	"ReplicaSet":  "k8s.replicaset",
+	"StatefulSet": "k8s.statefulset",
+	"DaemonSet":   "k8s.daemonset",
+	"Job":         "k8s.job",
+	"CronJob":     "k8s.cronjob",
+	"Node":        "k8s.node",
+	"Namespace":   "k8s.namespace",
+}
+
+// OTLPConfig configures an OTLPSink.
+type OTLPConfig struct {
+	// Protocol is one of grpc, http. Defaults to grpc
+	Protocol string `json:"protocol,omitempty"`
+	// Endpoint is the host:port of the gRPC receiver or the URL of the HTTP logs receiver.
+	// e.g (otel-collector:4317, http://otel-collector:4318/v1/logs)
+	Endpoint string `json:"endpoint"`
+	// Insecure disables TLS for gRPC
+	Insecure bool       `json:"insecure,omitempty"`
+	TLS      *TLSConfig `json:"tls,omitempty"`
+	// Headers are sent with every export, as gRPC metadata or HTTP headers
+	Headers map[string]string `json:"headers,omitempty"`
+	// Compression is one of none, gzip. Defaults to gzip
+	Compression string `json:"compression,omitempty"`
+
+	// ServiceName is the service.name resource attribute. Defaults to watchman
+	ServiceName string `json:"serviceName,omitempty"`
+	// ResourceAttributes are added to every resource. e.g (k8s.cluster.name: prod)
+	ResourceAttributes map[string]string `json:"resourceAttributes,omitempty"`
+
+	// BatchSize is the maximum number of events sent in a single export. Defaults to 512
+	BatchSize int `json:"batchSize,omitempty"`
+	// FlushInterval is the maximum time an event waits for its batch to fill up. Defaults to 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+	// QueueSize is the number of events buffered while waiting to be sent. Defaults to 5000
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout of a single export. Defaults to 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// MaxRetries is the number of times a failed export is retried. Defaults to 5
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the base of the exponential backoff between retries, jitter added. Defaults to 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+	// DeadLetterPath is the file batches are appended to, one event per line, once retries are exhausted
+	DeadLetterPath string `json:"deadLetterPath,omitempty"`
+}
+
+// OTLPSink is a Provider exporting every event as an OTLP LogRecord, e.g to an OpenTelemetry Collector. Events are
+// grouped by resource, described with the Kubernetes semantic convention attributes of the audited object. The record
+// body is the JSON encoded event. Start must be running.
+type OTLPSink struct {
+	cfg        OTLPConfig
+	conn       *grpc.ClientConn
+	grpcClient collogspb.LogsServiceClient
+	httpClient *http.Client
+	queue      chan AuditEvent
+	mu         sync.Mutex // guards the dead letter file
+}
+
+func NewOTLPSink(cfg OTLPConfig) (*OTLPSink, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("otlp sink endpoint is required")
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = OTLPProtocolGRPC
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = "gzip"
+	}
+	if cfg.Compression != "gzip" && cfg.Compression != "none" {
+		return nil, fmt.Errorf("unsupported otlp compression %q", cfg.Compression)
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "watchman"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.FlushInterval.Duration <= 0 {
+		cfg.FlushInterval.Duration = 5 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 5000
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	if cfg.MaxRetries == nil {
+		maxRetries := 5
+		cfg.MaxRetries = &maxRetries
+	}
+	if cfg.RetryBackoff.Duration <= 0 {
+		cfg.RetryBackoff.Duration = time.Second
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &OTLPSink{cfg: cfg, queue: make(chan AuditEvent, cfg.QueueSize)}
+
+	switch cfg.Protocol {
+	case OTLPProtocolGRPC:
+		creds := insecure.NewCredentials()
+		if !cfg.Insecure {
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if cfg.Compression == "gzip" {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)))
+		}
+		if sink.conn, err = grpc.NewClient(cfg.Endpoint, opts...); err != nil {
+			return nil, err
+		}
+		sink.grpcClient = collogspb.NewLogsServiceClient(sink.conn)
+
+	case OTLPProtocolHTTP:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		sink.httpClient = &http.Client{Timeout: cfg.Timeout.Duration, Transport: transport}
+
+	default:
+		return nil, fmt.Errorf("unsupported otlp protocol %q", cfg.Protocol)
+	}
+
+	return sink, nil
+}
+
+// Log queues event to be exported. The event goes to the dead letter file straight away if the queue is full.
+func (o *OTLPSink) Log(event AuditEvent) {
+	select {
+	case o.queue <- event:
+	default:
+		o.deadLetter([]AuditEvent{event}, fmt.Errorf("queue full"))
+	}
+}
+
+// Start exports queued events in batches until ctx is done, then flushes what is left.
+func (o *OTLPSink) Start(ctx context.Context) error {
+	if o.conn != nil {
+		defer o.conn.Close()
+	}
+
+	ticker := time.NewTicker(o.cfg.FlushInterval.Duration)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, o.cfg.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		o.send(ctx, batch)
+		batch = make([]AuditEvent, 0, o.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case event := <-o.queue:
+			batch = append(batch, event)
+			if len(batch) >= o.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-o.queue:
+					batch = append(batch, event)
+				default:
+					flushCtx, cancel := context.WithTimeout(context.Background(), o.cfg.Timeout.Duration)
+					flush(flushCtx)
+					cancel()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+func (o *OTLPSink) send(ctx context.Context, batch []AuditEvent) {
+	request, err := o.request(batch)
+	if err != nil {
+		o.deadLetter(batch, err)
+		return
+	}
+
+	export := o.exportGRPC
+	if o.cfg.Protocol == OTLPProtocolHTTP {
+		export = o.exportHTTP
+	}
+
+	for attempt := 0; ; attempt++ {
+		response, retry, err := export(ctx, request)
+		if err == nil {
+			o.track(len(batch), response.GetPartialSuccess())
+			return
+		}
+
+		if !retry || attempt >= *o.cfg.MaxRetries {
+			o.deadLetter(batch, err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff(o.cfg.RetryBackoff.Duration, attempt)):
+		case <-ctx.Done():
+			o.deadLetter(batch, ctx.Err())
+			return
+		}
+	}
+}
+
+// track records the delivery of an export of n events. The records rejected by a partial success can't be told
+// apart, so they are only logged and counted as failed.
+func (o *OTLPSink) track(n int, partial *collogspb.ExportLogsPartialSuccess) {
+	rejected := int(partial.GetRejectedLogRecords())
+	if rejected > 0 {
+		loghandlerlog.Error(fmt.Errorf("%s", partial.GetErrorMessage()), "Audit events rejected", "Sink", "otlp",
+			"Endpoint", o.cfg.Endpoint, "Events", rejected)
+		trackDelivery(SinkTypeOTLP, DeliveryFailed, rejected)
+	}
+	trackDelivery(SinkTypeOTLP, DeliveryDelivered, n-rejected)
+}
+
+// exportGRPC sends request to the gRPC receiver and reports whether a failed export is worth retrying.
+func (o *OTLPSink) exportGRPC(ctx context.Context, request *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, o.cfg.Timeout.Duration)
+	defer cancel()
+	if len(o.cfg.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.cfg.Headers))
+	}
+
+	response, err := o.grpcClient.Export(ctx, request)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable,
+			codes.DataLoss, codes.ResourceExhausted:
+			return nil, true, err
+		default:
+			return nil, false, err
+		}
+	}
+	return response, false, nil
+}
+
+// exportHTTP sends request as protobuf to the HTTP receiver and reports whether a failed export is worth retrying.
+func (o *OTLPSink) exportHTTP(ctx context.Context, request *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, bool, error) {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if o.cfg.Compression == "gzip" {
+		compressed := &bytes.Buffer{}
+		writer := gzip.NewWriter(compressed)
+		if _, err = writer.Write(body); err != nil {
+			return nil, false, err
+		}
+		if err = writer.Close(); err != nil {
+			return nil, false, err
+		}
+		body = compressed.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if o.cfg.Compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range o.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, retryableStatus(resp.StatusCode), fmt.Errorf("unexpected status %s from %s", resp.Status, o.cfg.Endpoint)
+	}
+
+	response := &collogspb.ExportLogsServiceResponse{}
+	if err = proto.Unmarshal(raw, response); err != nil {
+		// The export went through; only the partial success is lost.
+		loghandlerlog.Error(err, "Failed to read export response", "Sink", "otlp", "Endpoint", o.cfg.Endpoint)
+	}
+	return response, false, nil
+}
+
+func (o *OTLPSink) deadLetter(batch []AuditEvent, reason error) {
+	loghandlerlog.Error(reason, "Failed to export audit events", "Sink", "otlp", "Endpoint", o.cfg.Endpoint, "Events", len(batch))
+	trackDelivery(SinkTypeOTLP, DeliveryFailed, len(batch))
+	if o.cfg.DeadLetterPath == "" {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := appendNDJSON(o.cfg.DeadLetterPath, batch); err != nil {
+		loghandlerlog.Error(err, "Failed to write dead letter file", "Path", o.cfg.DeadLetterPath)
+	}
+}
+
+// request groups batch by resource, resources in order of first appearance.
+func (o *OTLPSink) request(batch []AuditEvent) (*collogspb.ExportLogsServiceRequest, error) {
+	request := &collogspb.ExportLogsServiceRequest{}
+	scopes := map[string]*logspb.ScopeLogs{}
+
+	observed := uint64(time.Now().UnixNano())
+	for _, event := range batch {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := o.resourceAttributes(event)
+		key := resourceKey(attributes)
+		scope, ok := scopes[key]
+		if !ok {
+			scope = &logspb.ScopeLogs{Scope: &commonpb.InstrumentationScope{Name: otlpScope}}
+			scopes[key] = scope
+			request.ResourceLogs = append(request.ResourceLogs, &logspb.ResourceLogs{
+				Resource:  &resourcepb.Resource{Attributes: attributes},
+				ScopeLogs: []*logspb.ScopeLogs{scope},
+			})
+		}
+
+		recordAttributes := map[string]string{
+			"watchman.action":           event.Action,
+			"watchman.kind":             event.Kind,
+			"watchman.name":             event.Name,
+			"watchman.actor":            event.Actor,
+			"watchman.watch":            event.Watch,
+			"watchman.resource_version": event.ResourceVersion,
+		}
+		if event.Severity != "" {
+			recordAttributes["watchman.severity"] = event.Severity
+		}
+		if event.CausedBy != "" {
+			recordAttributes["watchman.caused_by"] = event.CausedBy
+		}
+		if event.Ticket != "" {
+			recordAttributes["watchman.ticket"] = event.Ticket
+		}
+		if len(event.Tags) > 0 {
+			recordAttributes["watchman.tags"] = strings.Join(event.Tags, ",")
+		}
+		for name, value := range event.Fields {
+			recordAttributes["watchman.fields."+name] = value
+		}
+
+		severity, severityText := otlpSeverity(event)
+		record := &logspb.LogRecord{
+			TimeUnixNano:         uint64(event.Timestamp.UnixNano()),
+			ObservedTimeUnixNano: observed,
+			SeverityNumber:       severity,
+			SeverityText:         severityText,
+			Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: string(body)}},
+			Attributes:           stringAttributes(recordAttributes),
+		}
+		if event.Timestamp.IsZero() {
+			record.TimeUnixNano = 0
+		}
+		scope.LogRecords = append(scope.LogRecords, record)
+	}
+	return request, nil
+}
+
+// resourceAttributes describes the object audited by event with the Kubernetes semantic conventions. e.g
+// (k8s.namespace.name, k8s.deployment.name, k8s.deployment.uid)
+func (o *OTLPSink) resourceAttributes(event AuditEvent) []*commonpb.KeyValue {
+	attributes := map[string]string{"service.name": o.cfg.ServiceName}
+	for k, v := range o.cfg.ResourceAttributes {
+		attributes[k] = v
+	}
+
+	attributes["k8s.namespace.name"] = event.Namespace
+	if prefix, ok := otlpKindAttributes[event.Kind]; ok {
+		attributes[prefix+".name"] = event.Name
+		attributes[prefix+".uid"] = event.UID
+	}
+	return stringAttributes(attributes)
+}
+
+// stringAttributes converts attributes to key values sorted by key, leaving out empty values.
+func stringAttributes(attributes map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attributes))
+	for k, v := range attributes {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attributes[k]}},
+		})
+	}
+	return kvs
+}
+
+func resourceKey(attributes []*commonpb.KeyValue) string {
+	pairs := make([]string, 0, len(attributes))
+	for _, kv := range attributes {
+		pairs = append(pairs, kv.Key+"="+kv.Value.GetStringValue())
+	}
+	return strings.Join(pairs, ",")
+}
+
+// otlpSeverity maps the severity of event to a log record severity number and text, info below medium.
+func otlpSeverity(event AuditEvent) (logspb.SeverityNumber, string) {
+	switch event.Severity {
+	case SeverityCritical:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL, "FATAL"
+	case SeverityHigh:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, "ERROR"
+	case SeverityMedium:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "WARN"
+	default:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "INFO"
+	}
+}
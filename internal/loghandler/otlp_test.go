@@ -0,0 +1,176 @@
+package loghandler
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// logsCollector is an OTLP logs receiver keeping every export it receives.
+type logsCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+	mu       sync.Mutex
+	requests []*collogspb.ExportLogsServiceRequest
+	metadata []metadata.MD
+}
+
+func (c *logsCollector) Export(ctx context.Context, request *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, request)
+	c.metadata = append(c.metadata, md)
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func (c *logsCollector) Requests() []*collogspb.ExportLogsServiceRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*collogspb.ExportLogsServiceRequest{}, c.requests...)
+}
+
+func attributeMap(kvs []*commonpb.KeyValue) map[string]string {
+	attributes := map[string]string{}
+	for _, kv := range kvs {
+		attributes[kv.Key] = kv.Value.GetStringValue()
+	}
+	return attributes
+}
+
+var _ = Describe("OTLPSink", func() {
+	var (
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+
+	start := func(sink *OTLPSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	It("Should export events over gRPC with Kubernetes resource attributes", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		collector := &logsCollector{}
+		server := grpc.NewServer()
+		collogspb.RegisterLogsServiceServer(server, collector)
+		go func() { _ = server.Serve(listener) }()
+		defer server.Stop()
+
+		sink, err := NewOTLPSink(OTLPConfig{
+			Endpoint:           listener.Addr().String(),
+			Insecure:           true,
+			Headers:            map[string]string{"authorization": "Bearer xyz"},
+			ResourceAttributes: map[string]string{"k8s.cluster.name": "prod"},
+			BatchSize:          3,
+			FlushInterval:      metav1.Duration{Duration: time.Hour},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		first := makeEvent("app-1", "Update")
+		first.Actor = "helm"
+		first.Timestamp = time.Date(2024, 10, 18, 12, 0, 0, 0, time.UTC)
+		sink.Log(first)
+		sink.Log(makeEvent("app-2", "Update"))
+		sink.Log(makeEvent("app-1", "Delete"))
+
+		Eventually(collector.Requests).Should(HaveLen(1))
+		collector.mu.Lock()
+		Expect(collector.metadata[0].Get("authorization")).To(Equal([]string{"Bearer xyz"}))
+		collector.mu.Unlock()
+
+		resources := collector.Requests()[0].ResourceLogs
+		Expect(resources).To(HaveLen(2))
+		Expect(attributeMap(resources[0].Resource.Attributes)).To(Equal(map[string]string{
+			"service.name":        "watchman",
+			"k8s.cluster.name":    "prod",
+			"k8s.namespace.name":  "ns-1",
+			"k8s.deployment.name": "app-1",
+			"k8s.deployment.uid":  "uid-app-1",
+		}))
+
+		records := resources[0].ScopeLogs[0].LogRecords
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].TimeUnixNano).To(Equal(uint64(first.Timestamp.UnixNano())))
+		Expect(attributeMap(records[0].Attributes)).To(HaveKeyWithValue("watchman.actor", "helm"))
+		Expect(attributeMap(records[1].Attributes)).To(HaveKeyWithValue("watchman.action", "Delete"))
+
+		var event AuditEvent
+		Expect(json.Unmarshal([]byte(records[0].Body.GetStringValue()), &event)).To(Succeed())
+		Expect(event.Name).To(Equal("app-1"))
+	})
+
+	It("Should export gzip compressed protobuf over HTTP", func() {
+		var (
+			mu       sync.Mutex
+			requests []*collogspb.ExportLogsServiceRequest
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+			Expect(r.Header.Get("Content-Encoding")).To(Equal("gzip"))
+
+			reader, err := gzip.NewReader(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			raw, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+
+			request := &collogspb.ExportLogsServiceRequest{}
+			Expect(proto.Unmarshal(raw, request)).To(Succeed())
+			mu.Lock()
+			requests = append(requests, request)
+			mu.Unlock()
+
+			response, _ := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+			_, _ = w.Write(response)
+		}))
+		defer server.Close()
+
+		sink, err := NewOTLPSink(OTLPConfig{Protocol: OTLPProtocolHTTP, Endpoint: server.URL + "/v1/logs", BatchSize: 1})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		sink.Log(makeEvent("app-1", "Update"))
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(requests)
+		}).Should(Equal(1))
+
+		mu.Lock()
+		defer mu.Unlock()
+		resource := requests[0].ResourceLogs[0]
+		Expect(attributeMap(resource.Resource.Attributes)).To(HaveKeyWithValue("k8s.deployment.name", "app-1"))
+		Expect(resource.ScopeLogs[0].Scope.Name).To(Equal(otlpScope))
+	})
+})
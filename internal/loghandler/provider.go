@@ -1,18 +1,126 @@
 package loghandler
 
-import "fmt"
+import (
+	"encoding/json"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var loghandlerlog = logf.Log.WithName("loghandler")
 
 type Provider interface {
-	Log(resource, action, namespace string, data Data)
+	Log(event AuditEvent)
+}
+
+// Operation describes how a field changed between the old and new version of a resource.
+type Operation string
+
+const (
+	OperationAdd     Operation = "add"
+	OperationRemove  Operation = "remove"
+	OperationReplace Operation = "replace"
+)
+
+// Change is a single field change. Values are kept as JSON so sinks can render them as they see fit.
+type Change struct {
+	path     string
+	op       Operation
+	oldValue json.RawMessage
+	newValue json.RawMessage
+}
+
+// Path returns the path of the changed field e.g .spec.replicas
+func (c Change) Path() string {
+	return c.path
+}
+
+// Op returns the kind of change made to the field.
+func (c Change) Op() Operation {
+	return c.op
+}
+
+// OldValue returns the JSON value of the field before the change. It is nil when the field was added.
+func (c Change) OldValue() json.RawMessage {
+	return c.oldValue
+}
+
+// NewValue returns the JSON value of the field after the change. It is nil when the field was removed.
+func (c Change) NewValue() json.RawMessage {
+	return c.newValue
+}
+
+// changeJSON is the JSON representation of a Change.
+type changeJSON struct {
+	Path     string          `json:"path"`
+	Op       Operation       `json:"op"`
+	OldValue json.RawMessage `json:"oldValue,omitempty"`
+	NewValue json.RawMessage `json:"newValue,omitempty"`
+}
+
+func (c Change) MarshalJSON() ([]byte, error) {
+	return json.Marshal(changeJSON{c.path, c.op, c.oldValue, c.newValue})
 }
 
+// UnmarshalJSON decodes a change as encoded by MarshalJSON, e.g read back from a spool or dead letter file.
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var decoded changeJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*c = Change{path: decoded.Path, op: decoded.Op, oldValue: decoded.OldValue, newValue: decoded.NewValue}
+	return nil
+}
+
+// Data holds the kind of the audited resource and the ordered list of changes made to it.
 type Data struct {
-	fields map[string]string
+	kind    string
+	changes []Change
+}
+
+func NewData(kind string) *Data {
+	return &Data{kind: kind}
+}
+
+// Kind returns the kind of the audited resource.
+func (d *Data) Kind() string {
+	return d.kind
 }
 
-func (d *Data) AddField(field, value string) {
-	if d.fields == nil {
-		d.fields = map[string]string{}
+// Changes returns the recorded changes in the order they were added.
+func (d *Data) Changes() []Change {
+	return d.changes
+}
+
+// AddChange records a change at path. A nil old value is recorded as an addition, a nil new value as a removal
+// and anything else as a replacement.
+func (d *Data) AddChange(path string, old, new interface{}) error {
+	change := Change{path: path, op: OperationReplace}
+
+	switch {
+	case old == nil && new == nil:
+		return nil
+	case old == nil:
+		change.op = OperationAdd
+	case new == nil:
+		change.op = OperationRemove
+	}
+
+	if old != nil {
+		raw, err := json.Marshal(old)
+		if err != nil {
+			return err
+		}
+		change.oldValue = raw
+	}
+
+	if new != nil {
+		raw, err := json.Marshal(new)
+		if err != nil {
+			return err
+		}
+		change.newValue = raw
 	}
-	d.fields[field] = fmt.Sprintf("Changed to %s", value)
+
+	d.changes = append(d.changes, change)
+	return nil
 }
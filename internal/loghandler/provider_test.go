@@ -0,0 +1,43 @@
+package loghandler
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Change", func() {
+	It("Should decode the changes it encodes", func() {
+		data := NewData("Deployment")
+		Expect(data.AddChange(".spec.replicas", 1, 3)).To(Succeed())
+		Expect(data.AddChange(".spec.paused", nil, true)).To(Succeed())
+		Expect(data.AddChange(".spec.template.spec.containers[name=app]", map[string]string{"image": "app:1"}, nil)).To(Succeed())
+
+		raw, err := json.Marshal(data.Changes())
+		Expect(err).NotTo(HaveOccurred())
+
+		var changes []Change
+		Expect(json.Unmarshal(raw, &changes)).To(Succeed())
+		Expect(changes).To(Equal(data.Changes()))
+		Expect(changes[0].Path()).To(Equal(".spec.replicas"))
+		Expect(changes[0].Op()).To(Equal(OperationReplace))
+		Expect(string(changes[0].OldValue())).To(Equal("1"))
+		Expect(string(changes[0].NewValue())).To(Equal("3"))
+		Expect(changes[1].OldValue()).To(BeNil())
+		Expect(changes[2].NewValue()).To(BeNil())
+	})
+
+	It("Should read back the changes of events written to NDJSON files", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "events.ndjson")
+		event := makeEvent("app-1", "Update")
+		Expect(appendNDJSON(path, []AuditEvent{event})).To(Succeed())
+
+		events, err := readNDJSON(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Changes).To(Equal(event.Changes))
+		Expect(events[0].ID()).To(Equal(event.ID()))
+	})
+})
@@ -0,0 +1,590 @@
+package loghandler
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/parquet-go/parquet-go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Archive file formats.
+const (
+	ArchiveFormatNDJSON  = "ndjson"
+	ArchiveFormatParquet = "parquet"
+)
+
+// Server side encryption types.
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+// S3Config configures an S3Sink.
+type S3Config struct {
+	// Endpoint is the host[:port] of the S3 API. e.g (s3.eu-west-1.amazonaws.com, minio.minio:9000)
+	Endpoint string `json:"endpoint"`
+	// Region of the bucket. Defaults to us-east-1
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// Prefix of the object keys. e.g (watchman/audit)
+	Prefix string `json:"prefix,omitempty"`
+	// Insecure connects over plain HTTP
+	Insecure bool       `json:"insecure,omitempty"`
+	TLS      *TLSConfig `json:"tls,omitempty"`
+
+	// AccessKeyID and SecretAccessKey are static credentials. The environment and the IAM role, e.g IRSA, are used
+	// when empty
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+
+	// Format of the archive files, one of ndjson, parquet. Defaults to ndjson
+	Format string `json:"format,omitempty"`
+	// Compression of the archive files, one of none, gzip, zstd. Parquet files compress their pages with it.
+	// Defaults to gzip
+	Compression string `json:"compression,omitempty"`
+	// SSE is the server side encryption of the uploaded files
+	SSE *SSEConfig `json:"sse,omitempty"`
+
+	// MaxFileSize is the size in bytes a file is rolled over at. Defaults to 64MiB
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// MaxFileAge is how long a file is written to before it is rolled over. Defaults to 15m
+	MaxFileAge metav1.Duration `json:"maxFileAge,omitempty"`
+	// PartSize of the multipart uploads, files larger than it are uploaded in parts. At least 5MiB, defaults to 16MiB
+	PartSize uint64 `json:"partSize,omitempty"`
+	// SpoolDir is the directory files are written to until uploaded. Files left by a previous run are uploaded on start.
+	// Defaults to a watchman-s3 directory in the temporary directory
+	SpoolDir string `json:"spoolDir,omitempty"`
+	// QueueSize is the number of events buffered while waiting to be written. Defaults to 5000
+	QueueSize int `json:"queueSize,omitempty"`
+	// UploadTimeout of a single file. Defaults to 5m
+	UploadTimeout metav1.Duration `json:"uploadTimeout,omitempty"`
+}
+
+// SSEConfig configures the server side encryption of uploaded files.
+type SSEConfig struct {
+	// Type is one of AES256, aws:kms
+	Type string `json:"type"`
+	// KMSKeyID is the KMS key of aws:kms encryption. The default key of the account is used when empty
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+}
+
+// S3Sink is a Provider archiving events to S3 compatible storage. Events are written to a file per partition, keyed
+// namespace=<namespace>/date=<yyyy-mm-dd>/hour=<hh> after the event time, rolled over by size and age, then uploaded
+// in parts when large. Start must be running.
+type S3Sink struct {
+	cfg    S3Config
+	client *minio.Client
+	sse    encrypt.ServerSide
+	queue  chan AuditEvent
+
+	files   map[string]*archiveFile
+	pending []archiveUpload
+}
+
+// archiveFile is an archive file being written.
+type archiveFile struct {
+	key     string
+	path    string
+	file    *os.File
+	size    *countingWriter
+	encoder archiveEncoder
+	opened  time.Time
+	events  int
+}
+
+// archiveUpload is a rolled over file waiting to be uploaded.
+type archiveUpload struct {
+	key    string
+	path   string
+	events int
+}
+
+// archiveEncoder encodes events into an archive file.
+type archiveEncoder interface {
+	Encode(event AuditEvent) error
+	Close() error
+}
+
+func NewS3Sink(cfg S3Config) (*S3Sink, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("s3 sink endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 sink bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	if cfg.Format == "" {
+		cfg.Format = ArchiveFormatNDJSON
+	}
+	if cfg.Format != ArchiveFormatNDJSON && cfg.Format != ArchiveFormatParquet {
+		return nil, fmt.Errorf("unsupported s3 sink format %q", cfg.Format)
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = "gzip"
+	}
+	if cfg.Compression != "none" && cfg.Compression != "gzip" && cfg.Compression != "zstd" {
+		return nil, fmt.Errorf("unsupported s3 sink compression %q", cfg.Compression)
+	}
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = 64 << 20
+	}
+	if cfg.MaxFileAge.Duration <= 0 {
+		cfg.MaxFileAge.Duration = 15 * time.Minute
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = 16 << 20
+	}
+	if cfg.PartSize < 5<<20 {
+		return nil, fmt.Errorf("s3 sink part size must be at least 5MiB")
+	}
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = filepath.Join(os.TempDir(), "watchman-s3")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 5000
+	}
+	if cfg.UploadTimeout.Duration <= 0 {
+		cfg.UploadTimeout.Duration = 5 * time.Minute
+	}
+
+	sink := &S3Sink{cfg: cfg, queue: make(chan AuditEvent, cfg.QueueSize), files: map[string]*archiveFile{}}
+
+	if cfg.SSE != nil {
+		var err error
+		switch cfg.SSE.Type {
+		case SSEAES256:
+			sink.sse = encrypt.NewSSE()
+		case SSEKMS:
+			if sink.sse, err = encrypt.NewSSEKMS(cfg.SSE.KMSKeyID, nil); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported s3 sink sse type %q", cfg.SSE.Type)
+		}
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.IAM{},
+	})
+	if cfg.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	sink.client, err = minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     creds,
+		Secure:    !cfg.Insecure,
+		Region:    cfg.Region,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(cfg.SpoolDir, 0o700); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Log queues event to be archived. Events are dropped if the queue is full.
+func (s *S3Sink) Log(event AuditEvent) {
+	select {
+	case s.queue <- event:
+	default:
+		loghandlerlog.Error(fmt.Errorf("queue full"), "Dropping audit event", "Sink", "s3", "Name", event.Name, "Namespace", event.Namespace)
+		trackDelivery(SinkTypeS3, DeliveryFailed, 1)
+	}
+}
+
+// Start writes queued events to their partition file, uploading files as they roll over, until ctx is done. It then
+// rolls over and uploads every file. Files failing to upload are retried on the next tick, or on the next start.
+func (s *S3Sink) Start(ctx context.Context) error {
+	s.pending = append(s.pending, s.spooled()...)
+
+	tick := s.cfg.MaxFileAge.Duration / 4
+	if tick > time.Minute {
+		tick = time.Minute
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-s.queue:
+			if s.write(event) {
+				s.upload(ctx)
+			}
+		case <-ticker.C:
+			now := time.Now()
+			for key, file := range s.files {
+				if now.Sub(file.opened) >= s.cfg.MaxFileAge.Duration {
+					s.rollover(key)
+				}
+			}
+			s.upload(ctx)
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-s.queue:
+					s.write(event)
+				default:
+					for key := range s.files {
+						s.rollover(key)
+					}
+					uploadCtx, cancel := context.WithTimeout(context.Background(), s.cfg.UploadTimeout.Duration)
+					s.upload(uploadCtx)
+					cancel()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// write writes event to the file of its partition and reports whether the file was rolled over.
+func (s *S3Sink) write(event AuditEvent) bool {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	partition := s.partition(event.Namespace, timestamp.UTC())
+
+	file, ok := s.files[partition]
+	if !ok {
+		var err error
+		if file, err = s.open(partition); err != nil {
+			loghandlerlog.Error(err, "Failed to open archive file", "Sink", "s3", "Partition", partition)
+			trackDelivery(SinkTypeS3, DeliveryFailed, 1)
+			return false
+		}
+		s.files[partition] = file
+	}
+
+	if err := file.encoder.Encode(event); err != nil {
+		loghandlerlog.Error(err, "Failed to write archive file", "Sink", "s3", "Path", file.path)
+		trackDelivery(SinkTypeS3, DeliveryFailed, 1)
+		return false
+	}
+	file.events++
+
+	if file.size.n < s.cfg.MaxFileSize {
+		return false
+	}
+	s.rollover(partition)
+	return true
+}
+
+// partition returns the key prefix of the partition of an event. e.g (watchman/namespace=default/date=2024-10-18/hour=09)
+func (s *S3Sink) partition(namespace string, timestamp time.Time) string {
+	if namespace == "" {
+		namespace = "_"
+	}
+	partition := fmt.Sprintf("namespace=%s/date=%s/hour=%s", namespace, timestamp.Format("2006-01-02"), timestamp.Format("15"))
+	if s.cfg.Prefix != "" {
+		partition = s.cfg.Prefix + "/" + partition
+	}
+	return partition
+}
+
+func (s *S3Sink) open(partition string) (*archiveFile, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	key := path.Join(partition, fmt.Sprintf("%s-%s.%s", now.Format("20060102T150405Z"), hex.EncodeToString(id), s.extension()))
+	filePath := filepath.Join(s.cfg.SpoolDir, url.PathEscape(key)+".part")
+
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	size := &countingWriter{w: f}
+	file := &archiveFile{key: key, path: filePath, file: f, size: size, opened: now}
+	if file.encoder, err = s.encoder(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// rollover closes the file of partition and queues it for upload.
+func (s *S3Sink) rollover(partition string) {
+	file := s.files[partition]
+	delete(s.files, partition)
+
+	err := file.encoder.Close()
+	if err == nil {
+		err = file.file.Sync()
+	}
+	if closeErr := file.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		loghandlerlog.Error(err, "Failed to close archive file", "Sink", "s3", "Path", file.path)
+		trackDelivery(SinkTypeS3, DeliveryFailed, file.events)
+		return
+	}
+
+	done := strings.TrimSuffix(file.path, ".part")
+	if err = os.Rename(file.path, done); err != nil {
+		loghandlerlog.Error(err, "Failed to close archive file", "Sink", "s3", "Path", file.path)
+		trackDelivery(SinkTypeS3, DeliveryFailed, file.events)
+		return
+	}
+	s.pending = append(s.pending, archiveUpload{key: file.key, path: done, events: file.events})
+}
+
+// upload uploads the pending files, keeping the ones failing for the next attempt.
+func (s *S3Sink) upload(ctx context.Context) {
+	var failed []archiveUpload
+	for _, upload := range s.pending {
+		opts := minio.PutObjectOptions{
+			ContentType:          s.contentType(),
+			PartSize:             s.cfg.PartSize,
+			ServerSideEncryption: s.sse,
+		}
+
+		uploadCtx, cancel := context.WithTimeout(ctx, s.cfg.UploadTimeout.Duration)
+		_, err := s.client.FPutObject(uploadCtx, s.cfg.Bucket, upload.key, upload.path, opts)
+		cancel()
+		if err != nil {
+			loghandlerlog.Error(err, "Failed to upload archive file", "Sink", "s3", "Bucket", s.cfg.Bucket, "Key", upload.key)
+			failed = append(failed, upload)
+			continue
+		}
+
+		trackDelivery(SinkTypeS3, DeliveryDelivered, upload.events)
+		if err = os.Remove(upload.path); err != nil {
+			loghandlerlog.Error(err, "Failed to remove uploaded archive file", "Path", upload.path)
+		}
+	}
+	s.pending = failed
+}
+
+// spooled lists the files a previous run rolled over without uploading them.
+func (s *S3Sink) spooled() []archiveUpload {
+	entries, err := os.ReadDir(s.cfg.SpoolDir)
+	if err != nil {
+		loghandlerlog.Error(err, "Failed to read spool directory", "Sink", "s3", "Path", s.cfg.SpoolDir)
+		return nil
+	}
+
+	var uploads []archiveUpload
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		key, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, archiveUpload{key: key, path: filepath.Join(s.cfg.SpoolDir, entry.Name())})
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].key < uploads[j].key })
+	return uploads
+}
+
+func (s *S3Sink) extension() string {
+	if s.cfg.Format == ArchiveFormatParquet {
+		return "parquet"
+	}
+	switch s.cfg.Compression {
+	case "gzip":
+		return "ndjson.gz"
+	case "zstd":
+		return "ndjson.zst"
+	default:
+		return "ndjson"
+	}
+}
+
+func (s *S3Sink) contentType() string {
+	if s.cfg.Format == ArchiveFormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	switch s.cfg.Compression {
+	case "gzip":
+		return "application/gzip"
+	case "zstd":
+		return "application/zstd"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+func (s *S3Sink) encoder(w io.Writer) (archiveEncoder, error) {
+	if s.cfg.Format == ArchiveFormatParquet {
+		var codec parquet.WriterOption
+		switch s.cfg.Compression {
+		case "gzip":
+			codec = parquet.Compression(&parquet.Gzip)
+		case "zstd":
+			codec = parquet.Compression(&parquet.Zstd)
+		default:
+			codec = parquet.Compression(&parquet.Uncompressed)
+		}
+		return &parquetEncoder{writer: parquet.NewGenericWriter[archiveRow](w, codec)}, nil
+	}
+
+	switch s.cfg.Compression {
+	case "gzip":
+		gz := gzip.NewWriter(w)
+		return &ndjsonEncoder{encoder: json.NewEncoder(gz), closer: gz}, nil
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &ndjsonEncoder{encoder: json.NewEncoder(zw), closer: zw}, nil
+	default:
+		return &ndjsonEncoder{encoder: json.NewEncoder(w)}, nil
+	}
+}
+
+// ndjsonEncoder writes one JSON event per line.
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func (e *ndjsonEncoder) Encode(event AuditEvent) error {
+	return e.encoder.Encode(event)
+}
+
+func (e *ndjsonEncoder) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// parquetRowGroupSize is the number of rows of a parquet row group. Rows are buffered in memory until their row group
+// is written, so it also bounds how far the file size lags behind the events written.
+const parquetRowGroupSize = 1000
+
+// archiveRow is an event as a parquet row. Nested fields are kept as JSON.
+type archiveRow struct {
+	Timestamp       time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Kind            string    `parquet:"kind,dict"`
+	Namespace       string    `parquet:"namespace,dict,optional"`
+	Name            string    `parquet:"name"`
+	UID             string    `parquet:"uid,optional"`
+	ResourceVersion string    `parquet:"resource_version,optional"`
+	Action          string    `parquet:"action,dict"`
+	Actor           string    `parquet:"actor,dict,optional"`
+	Watch           string    `parquet:"watch,dict,optional"`
+	Severity        string    `parquet:"severity,dict,optional"`
+	SeverityRules   []string  `parquet:"severity_rules,list"`
+	Ticket          string    `parquet:"ticket,optional"`
+	CausedBy        string    `parquet:"caused_by,optional"`
+	Related         string    `parquet:"related,optional"`
+	Tags            []string  `parquet:"tags,list"`
+	Fields          string    `parquet:"fields,optional"`
+	Changes         string    `parquet:"changes,optional"`
+	Patch           string    `parquet:"patch,optional"`
+	Chain           string    `parquet:"chain,optional"`
+}
+
+type parquetEncoder struct {
+	writer *parquet.GenericWriter[archiveRow]
+	rows   int
+}
+
+func (e *parquetEncoder) Encode(event AuditEvent) error {
+	row := archiveRow{
+		Timestamp:       event.Timestamp,
+		Kind:            event.Kind,
+		Namespace:       event.Namespace,
+		Name:            event.Name,
+		UID:             event.UID,
+		ResourceVersion: event.ResourceVersion,
+		Action:          event.Action,
+		Actor:           event.Actor,
+		Watch:           event.Watch,
+		Severity:        event.Severity,
+		SeverityRules:   event.SeverityRules,
+		Ticket:          event.Ticket,
+		CausedBy:        event.CausedBy,
+		Tags:            event.Tags,
+	}
+
+	for _, field := range []struct {
+		dst *string
+		src interface{}
+		set bool
+	}{
+		{&row.Fields, event.Fields, len(event.Fields) > 0},
+		{&row.Related, event.Related, len(event.Related) > 0},
+		{&row.Changes, event.Changes, len(event.Changes) > 0},
+		{&row.Patch, event.Patch, event.Patch != nil},
+		{&row.Chain, event.Chain, event.Chain != nil},
+	} {
+		if !field.set {
+			continue
+		}
+		raw, err := json.Marshal(field.src)
+		if err != nil {
+			return err
+		}
+		*field.dst = string(raw)
+	}
+
+	if _, err := e.writer.Write([]archiveRow{row}); err != nil {
+		return err
+	}
+	e.rows++
+	if e.rows%parquetRowGroupSize == 0 {
+		return e.writer.Flush()
+	}
+	return nil
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.writer.Close()
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
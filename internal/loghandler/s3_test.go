@@ -0,0 +1,347 @@
+package loghandler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// s3Object is an object stored by the S3 stand-in.
+type s3Object struct {
+	Body    []byte
+	Headers http.Header
+	Parts   int
+}
+
+// s3StandIn is an S3 API stand-in serving the single and multipart uploads of a bucket.
+type s3StandIn struct {
+	mu      sync.Mutex
+	objects map[string]*s3Object
+	uploads map[string]map[int][]byte
+	headers map[string]http.Header
+	fail    int
+}
+
+func newS3StandIn() *s3StandIn {
+	return &s3StandIn{objects: map[string]*s3Object{}, uploads: map[string]map[int][]byte{}, headers: map[string]http.Header{}}
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail > 0 {
+		s.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, `<Error><Code>SlowDown</Code><Message>Slow down</Message></Error>`)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/audit/")
+	query := r.URL.Query()
+	body, err := readS3Body(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = map[int][]byte{}
+		s.headers[id] = r.Header.Clone()
+		_, _ = fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>audit</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		s.uploads[query.Get("uploadId")][part] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, part))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		id := query.Get("uploadId")
+		parts := s.uploads[id]
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+
+		object := &s3Object{Headers: s.headers[id], Parts: len(parts)}
+		for _, number := range numbers {
+			object.Body = append(object.Body, parts[number]...)
+		}
+		s.objects[key] = object
+		_, _ = fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>audit</Bucket><Key>%s</Key><ETag>"object"</ETag></CompleteMultipartUploadResult>`, key)
+
+	case r.Method == http.MethodPut:
+		s.objects[key] = &s3Object{Body: body, Headers: r.Header.Clone()}
+		w.Header().Set("ETag", `"object"`)
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *s3StandIn) Objects() map[string]*s3Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := map[string]*s3Object{}
+	for k, v := range s.objects {
+		objects[k] = v
+	}
+	return objects
+}
+
+// readS3Body reads the body of a request, decoding the aws-chunked encoding of streaming signed uploads.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	body := &bytes.Buffer{}
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+		if _, err = io.CopyN(body, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err = reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func gunzipLines(raw []byte) []AuditEvent {
+	reader, err := gzip.NewReader(bytes.NewReader(raw))
+	Expect(err).NotTo(HaveOccurred())
+	var events []AuditEvent
+	decoder := json.NewDecoder(reader)
+	for decoder.More() {
+		var event AuditEvent
+		Expect(decoder.Decode(&event)).To(Succeed())
+		events = append(events, event)
+	}
+	return events
+}
+
+var _ = Describe("S3Sink", func() {
+	var (
+		standIn  *s3StandIn
+		server   *httptest.Server
+		spoolDir string
+		cancel   context.CancelFunc
+		done     chan struct{}
+	)
+
+	BeforeEach(func() {
+		standIn = newS3StandIn()
+		server = httptest.NewServer(standIn)
+		spoolDir = GinkgoT().TempDir()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	config := func() S3Config {
+		return S3Config{
+			Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+			Insecure:        true,
+			Bucket:          "audit",
+			Prefix:          "watchman",
+			AccessKeyID:     "access",
+			SecretAccessKey: "secret",
+			SpoolDir:        spoolDir,
+		}
+	}
+
+	start := func(sink *S3Sink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done, 30*time.Second).Should(BeClosed())
+	}
+
+	It("Should archive gzipped NDJSON files partitioned by namespace, date and hour", func() {
+		cfg := config()
+		cfg.SSE = &SSEConfig{Type: SSEAES256}
+		sink, err := NewS3Sink(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		first := makeEvent("app-1", "Update")
+		first.Timestamp = time.Date(2024, 10, 18, 9, 15, 0, 0, time.UTC)
+		second := makeEvent("app-2", "Update")
+		second.Timestamp = time.Date(2024, 10, 18, 9, 45, 0, 0, time.UTC)
+		later := makeEvent("app-1", "Delete")
+		later.Timestamp = time.Date(2024, 10, 18, 10, 5, 0, 0, time.UTC)
+		sink.Log(first)
+		sink.Log(second)
+		sink.Log(later)
+		stop()
+
+		objects := standIn.Objects()
+		Expect(objects).To(HaveLen(2))
+
+		byPartition := map[string]*s3Object{}
+		for key, object := range objects {
+			Expect(key).To(HaveSuffix(".ndjson.gz"))
+			byPartition[filepath.Dir(key)] = object
+		}
+		Expect(byPartition).To(HaveKey("watchman/namespace=ns-1/date=2024-10-18/hour=09"))
+		Expect(byPartition).To(HaveKey("watchman/namespace=ns-1/date=2024-10-18/hour=10"))
+
+		nine := byPartition["watchman/namespace=ns-1/date=2024-10-18/hour=09"]
+		Expect(nine.Headers.Get("X-Amz-Server-Side-Encryption")).To(Equal("AES256"))
+		Expect(nine.Headers.Get("Content-Type")).To(Equal("application/gzip"))
+		events := gunzipLines(nine.Body)
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Name).To(Equal("app-1"))
+		Expect(events[1].Name).To(Equal("app-2"))
+
+		entries, err := os.ReadDir(spoolDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("Should roll files over by size and upload large files in parts", func() {
+		cfg := config()
+		cfg.Compression = "none"
+		cfg.MaxFileSize = 6 << 20
+		cfg.PartSize = 5 << 20
+		sink, err := NewS3Sink(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		value := strings.Repeat("x", 512<<10)
+		for i := 0; i < 14; i++ {
+			event := makeEvent(fmt.Sprintf("app-%d", i), "Update")
+			data := NewData("ConfigMap")
+			Expect(data.AddChange(".data.blob", nil, value)).To(Succeed())
+			event.Changes = data.Changes()
+			sink.Log(event)
+		}
+
+		// The size rollover uploads without waiting for the file to age
+		Eventually(func() int { return len(standIn.Objects()) }, 30*time.Second).Should(Equal(1))
+		stop()
+
+		objects := standIn.Objects()
+		Expect(objects).To(HaveLen(2))
+		var sizes []int
+		for key, object := range objects {
+			Expect(key).To(HaveSuffix(".ndjson"))
+			sizes = append(sizes, len(object.Body))
+			if len(object.Body) > 6<<20 {
+				Expect(object.Parts).To(Equal(2))
+			}
+		}
+		sort.Ints(sizes)
+		Expect(sizes[1]).To(BeNumerically(">", 6<<20))
+	})
+
+	It("Should write parquet files", func() {
+		cfg := config()
+		cfg.Format = ArchiveFormatParquet
+		cfg.Compression = "zstd"
+		sink, err := NewS3Sink(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+
+		event := makeEvent("app-1", "Update")
+		event.Actor = "helm"
+		event.Severity = SeverityCritical
+		event.SeverityRules = []string{"privileged-container"}
+		event.Tags = []string{"FreezeViolation"}
+		event.Ticket = "CHG-1234"
+		event.CausedBy = "uid-0:1:Update"
+		event.Related = []RelatedObject{{Kind: "HorizontalPodAutoscaler", Name: "app-1", Relation: "Autoscaler"}}
+		sink.Log(event)
+		sink.Log(makeEvent("app-2", "Update"))
+		stop()
+
+		objects := standIn.Objects()
+		Expect(objects).To(HaveLen(1))
+		for key, object := range objects {
+			Expect(key).To(HaveSuffix(".parquet"))
+			rows, err := parquet.Read[archiveRow](bytes.NewReader(object.Body), int64(len(object.Body)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(2))
+			Expect(rows[0].Name).To(Equal("app-1"))
+			Expect(rows[0].Actor).To(Equal("helm"))
+			Expect(rows[0].Changes).To(ContainSubstring(".spec.replicas"))
+			Expect(rows[0].Severity).To(Equal(SeverityCritical))
+			Expect(rows[0].SeverityRules).To(Equal([]string{"privileged-container"}))
+			Expect(rows[0].Tags).To(Equal([]string{"FreezeViolation"}))
+			Expect(rows[0].Ticket).To(Equal("CHG-1234"))
+			Expect(rows[0].CausedBy).To(Equal("uid-0:1:Update"))
+			Expect(rows[0].Related).To(ContainSubstring(`"relation":"Autoscaler"`))
+			Expect(rows[1].Severity).To(BeEmpty())
+			Expect(rows[1].Tags).To(BeEmpty())
+		}
+	})
+
+	It("Should keep files failing to upload and upload them on the next start", func() {
+		standIn.fail = 1000
+		cfg := config()
+		cfg.UploadTimeout = metav1.Duration{Duration: time.Second}
+		sink, err := NewS3Sink(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		sink.Log(makeEvent("app-1", "Update"))
+		stop()
+		Expect(standIn.Objects()).To(BeEmpty())
+
+		entries, err := os.ReadDir(spoolDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+
+		standIn.mu.Lock()
+		standIn.fail = 0
+		standIn.mu.Unlock()
+
+		sink, err = NewS3Sink(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		stop()
+		Expect(standIn.Objects()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,19 @@
+package loghandler
+
+import "slices"
+
+// Severities of audit events, from the least to the most severe.
+const (
+	SeverityInfo     = "info"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+var severities = []string{SeverityInfo, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// SeverityRank orders severities from 0 for info up to critical. Unknown severities rank -1.
+func SeverityRank(severity string) int {
+	return slices.Index(severities, severity)
+}
@@ -0,0 +1,550 @@
+package loghandler
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SyslogFormatRFC5424 sends RFC 5424 messages with the event fields as structured data and the JSON event as message
+	SyslogFormatRFC5424 = "rfc5424"
+	// SyslogFormatCEF sends ArcSight CEF lines as the message of RFC 5424 headers
+	SyslogFormatCEF = "cef"
+
+	// SyslogFramingOctetCounting prefixes every message with its length, RFC 6587 section 3.4.1
+	SyslogFramingOctetCounting = "octet-counting"
+	// SyslogFramingNonTransparent terminates every message with a line feed, RFC 6587 section 3.4.2
+	SyslogFramingNonTransparent = "non-transparent"
+)
+
+// cefMaxMessage is the length the msg extension of CEF lines is truncated to.
+const cefMaxMessage = 1023
+
+// SyslogConfig configures a SyslogSink.
+type SyslogConfig struct {
+	// Address of the syslog server. e.g (siem.example.com:6514)
+	Address string `json:"address"`
+	// TLS enables TLS, RFC 5425, and configures server verification and the client certificate
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// Format of the messages. One of rfc5424, cef. Defaults to rfc5424
+	Format string `json:"format,omitempty"`
+	// Framing of the messages on the stream. One of octet-counting, non-transparent. Defaults to octet-counting
+	Framing string `json:"framing,omitempty"`
+
+	// Facility of the messages. Defaults to 13, log audit
+	Facility int `json:"facility,omitempty"`
+	// AppName is the APP-NAME of the messages. Defaults to watchman
+	AppName string `json:"appName,omitempty"`
+	// Hostname is the HOSTNAME of the messages. Defaults to the hostname of the pod
+	Hostname string `json:"hostname,omitempty"`
+	// StructuredDataID is the SD-ID of the rfc5424 structured data element. Defaults to audit@32473
+	StructuredDataID string `json:"structuredDataID,omitempty"`
+	// DeviceVendor, DeviceProduct and DeviceVersion fill the CEF header. Default to vandathron, watchman and 1
+	DeviceVendor  string `json:"deviceVendor,omitempty"`
+	DeviceProduct string `json:"deviceProduct,omitempty"`
+	DeviceVersion string `json:"deviceVersion,omitempty"`
+
+	// QueueSize is the number of events buffered while waiting to be sent. Defaults to 1000
+	QueueSize int `json:"queueSize,omitempty"`
+	// Timeout of connecting and of writing a single message. Defaults to 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// RetryBackoff is the base of the exponential backoff between reconnects, jitter added. Defaults to 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+	// SpoolPath is the file events are queued to, one event per line, while the server is unreachable or the queue is
+	// full. They are sent once reconnected. Events are dropped instead if empty
+	SpoolPath string `json:"spoolPath,omitempty"`
+}
+
+// SyslogSink is a Provider sending events to a syslog server over TCP or TLS. Events are queued by Log and sent by
+// Start, which must be running, reconnecting whenever the connection is lost.
+type SyslogSink struct {
+	cfg       SyslogConfig
+	tlsConfig *tls.Config
+	pid       string
+	queue     chan AuditEvent
+
+	conn   net.Conn
+	closed chan struct{} // closed once the server closes conn
+
+	mu      sync.Mutex // guards the spool file
+	spooled atomic.Bool
+}
+
+func NewSyslogSink(cfg SyslogConfig) (*SyslogSink, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("syslog sink address is required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid syslog sink address: %w", err)
+	}
+
+	switch cfg.Format {
+	case "":
+		cfg.Format = SyslogFormatRFC5424
+	case SyslogFormatRFC5424, SyslogFormatCEF:
+	default:
+		return nil, fmt.Errorf("unsupported syslog format %q", cfg.Format)
+	}
+
+	switch cfg.Framing {
+	case "":
+		cfg.Framing = SyslogFramingOctetCounting
+	case SyslogFramingOctetCounting, SyslogFramingNonTransparent:
+	default:
+		return nil, fmt.Errorf("unsupported syslog framing %q", cfg.Framing)
+	}
+
+	if cfg.Facility <= 0 {
+		cfg.Facility = 13
+	}
+	if cfg.Facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility %d", cfg.Facility)
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "watchman"
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.StructuredDataID == "" {
+		cfg.StructuredDataID = "audit@32473"
+	}
+	if strings.ContainsAny(cfg.StructuredDataID, " =]\"") {
+		return nil, fmt.Errorf("invalid syslog structured data ID %q", cfg.StructuredDataID)
+	}
+	if cfg.DeviceVendor == "" {
+		cfg.DeviceVendor = "vandathron"
+	}
+	if cfg.DeviceProduct == "" {
+		cfg.DeviceProduct = "watchman"
+	}
+	if cfg.DeviceVersion == "" {
+		cfg.DeviceVersion = "1"
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	if cfg.RetryBackoff.Duration <= 0 {
+		cfg.RetryBackoff.Duration = time.Second
+	}
+
+	tlsConfig, err := cfg.TLS.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &SyslogSink{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+		pid:       strconv.Itoa(os.Getpid()),
+		queue:     make(chan AuditEvent, cfg.QueueSize),
+	}
+	if cfg.SpoolPath != "" {
+		if _, err = os.Stat(cfg.SpoolPath); err == nil {
+			sink.spooled.Store(true)
+		}
+	}
+	return sink, nil
+}
+
+// Log queues event to be sent. The event is spooled straight away if the queue is full.
+func (s *SyslogSink) Log(event AuditEvent) {
+	select {
+	case s.queue <- event:
+	default:
+		s.spool([]AuditEvent{event}, fmt.Errorf("queue full"))
+	}
+}
+
+// Start sends queued events until ctx is done, then sends what is left. Events queued while the server is unreachable
+// are spooled and sent, spooled events first, once reconnected.
+func (s *SyslogSink) Start(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for attempt := 0; ; {
+		if s.conn == nil {
+			if err := s.connect(); err != nil {
+				loghandlerlog.Error(err, "Failed to connect to syslog server", "Address", s.cfg.Address)
+				if !s.wait(ctx, backoff(s.cfg.RetryBackoff.Duration, attempt)) {
+					s.shutdown()
+					return nil
+				}
+				attempt++
+				continue
+			}
+			attempt = 0
+			s.replay()
+			continue
+		}
+
+		select {
+		case event := <-s.queue:
+			s.send(event)
+		case <-ticker.C:
+			s.replay()
+		case <-s.closed:
+			s.disconnect(fmt.Errorf("connection closed by server"))
+		case <-ctx.Done():
+			s.shutdown()
+			return nil
+		}
+	}
+}
+
+// wait spools queued events for d while disconnected, unless there is no spool. Returns false if ctx is done first.
+func (s *SyslogSink) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	queue := s.queue
+	if s.cfg.SpoolPath == "" {
+		queue = nil
+	}
+	for {
+		select {
+		case event := <-queue:
+			s.spool([]AuditEvent{event}, fmt.Errorf("not connected"))
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// shutdown sends the queued events, spooling them if disconnected, and closes the connection.
+func (s *SyslogSink) shutdown() {
+	for {
+		select {
+		case event := <-s.queue:
+			if s.conn == nil {
+				s.spool([]AuditEvent{event}, fmt.Errorf("not connected"))
+				continue
+			}
+			s.send(event)
+		default:
+			if s.conn != nil {
+				_ = s.conn.Close()
+				s.conn = nil
+			}
+			return
+		}
+	}
+}
+
+func (s *SyslogSink) connect() error {
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout.Duration, KeepAlive: 30 * time.Second}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.cfg.Address, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Syslog servers never write back, reading only tells when the connection is gone
+	closed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	s.conn, s.closed = conn, closed
+	return nil
+}
+
+func (s *SyslogSink) disconnect(reason error) {
+	loghandlerlog.Error(reason, "Lost connection to syslog server", "Address", s.cfg.Address)
+	_ = s.conn.Close()
+	s.conn, s.closed = nil, nil
+}
+
+// send writes event, disconnecting and spooling it on failure.
+func (s *SyslogSink) send(event AuditEvent) {
+	if err := s.write(event); err != nil {
+		s.disconnect(err)
+		s.spool([]AuditEvent{event}, err)
+		return
+	}
+	trackDelivery(SinkTypeSyslog, DeliveryDelivered, 1)
+}
+
+func (s *SyslogSink) write(event AuditEvent) error {
+	select {
+	case <-s.closed:
+		return fmt.Errorf("connection closed by server")
+	default:
+	}
+
+	msg, err := s.format(event)
+	if err != nil {
+		return err
+	}
+
+	var frame string
+	if s.cfg.Framing == SyslogFramingNonTransparent {
+		frame = msg + "\n"
+	} else {
+		frame = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	if err = s.conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout.Duration)); err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.conn, frame)
+	return err
+}
+
+// spool appends events to the spool file to be sent once reconnected. They are dropped if there is no spool.
+func (s *SyslogSink) spool(events []AuditEvent, reason error) {
+	if s.cfg.SpoolPath == "" {
+		loghandlerlog.Error(reason, "Failed to deliver audit events", "Sink", "syslog", "Address", s.cfg.Address, "Events", len(events))
+		trackDelivery(SinkTypeSyslog, DeliveryFailed, len(events))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := appendNDJSON(s.cfg.SpoolPath, events); err != nil {
+		loghandlerlog.Error(err, "Failed to write spool file", "Path", s.cfg.SpoolPath, "Events", len(events))
+		trackDelivery(SinkTypeSyslog, DeliveryFailed, len(events))
+		return
+	}
+	s.spooled.Store(true)
+}
+
+// replay sends the spooled events. The events left are kept in the spool if the connection is lost midway.
+func (s *SyslogSink) replay() {
+	if !s.spooled.Load() || s.conn == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := readNDJSON(s.cfg.SpoolPath)
+	if errors.Is(err, os.ErrNotExist) {
+		s.spooled.Store(false)
+		return
+	}
+	if err != nil {
+		// A torn last line, e.g written while the pod was killed, is dropped with the file once replayed
+		loghandlerlog.Error(err, "Failed to read spool file", "Path", s.cfg.SpoolPath, "Events", len(events))
+	}
+
+	for i, event := range events {
+		if err = s.write(event); err != nil {
+			s.disconnect(err)
+			trackDelivery(SinkTypeSyslog, DeliveryDelivered, i)
+			if err = writeNDJSON(s.cfg.SpoolPath, events[i:]); err != nil {
+				loghandlerlog.Error(err, "Failed to write spool file", "Path", s.cfg.SpoolPath)
+			}
+			return
+		}
+	}
+	trackDelivery(SinkTypeSyslog, DeliveryDelivered, len(events))
+
+	if err = os.Remove(s.cfg.SpoolPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		loghandlerlog.Error(err, "Failed to remove spool file", "Path", s.cfg.SpoolPath)
+		return
+	}
+	s.spooled.Store(false)
+}
+
+// format renders event as an RFC 5424 message, with the event as structured data or a CEF line as message.
+func (s *SyslogSink) format(event AuditEvent) (string, error) {
+	severity, _ := syslogSeverity(event)
+
+	var sd, msg string
+	if s.cfg.Format == SyslogFormatCEF {
+		sd, msg = "-", s.cef(event)
+	} else {
+		raw, err := json.Marshal(event)
+		if err != nil {
+			return "", err
+		}
+		sd, msg = s.structuredData(event), string(raw)
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s",
+		s.cfg.Facility*8+severity,
+		event.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.cfg.Hostname, 255),
+		syslogHeaderField(s.cfg.AppName, 48),
+		s.pid,
+		syslogHeaderField(event.Action, 32),
+		sd, msg), nil
+}
+
+// structuredData renders the event fields as an RFC 5424 SD-ELEMENT. e.g ([audit@32473 kind="Deployment" name="app"])
+func (s *SyslogSink) structuredData(event AuditEvent) string {
+	params := [][2]string{
+		{"kind", event.Kind},
+		{"name", event.Name},
+		{"namespace", event.Namespace},
+		{"uid", event.UID},
+		{"resourceVersion", event.ResourceVersion},
+		{"action", event.Action},
+		{"actor", event.Actor},
+		{"watch", event.Watch},
+		{"severity", event.Severity},
+		{"changes", strconv.Itoa(len(event.Changes))},
+	}
+
+	sd := &strings.Builder{}
+	sd.WriteString("[" + s.cfg.StructuredDataID)
+	for _, param := range params {
+		if param[1] == "" {
+			continue
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(param[1])
+		fmt.Fprintf(sd, ` %s="%s"`, param[0], value)
+	}
+	sd.WriteString("]")
+	return sd.String()
+}
+
+// cef renders event as an ArcSight CEF line.
+// e.g (CEF:0|vandathron|watchman|1|Update|Update Deployment|5|rt=1729242900000 act=Update cat=Deployment ...)
+func (s *SyslogSink) cef(event AuditEvent) string {
+	_, severity := syslogSeverity(event)
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+
+	changes := make([]string, 0, len(event.Changes))
+	for _, change := range event.Changes {
+		changes = append(changes, fmt.Sprintf("%s %s: %s -> %s", change.Op(), change.Path(), change.OldValue(), change.NewValue()))
+	}
+	msg := strings.Join(changes, "; ")
+	if len(msg) > cefMaxMessage {
+		msg = msg[:cefMaxMessage]
+	}
+
+	extensions := [][2]string{
+		{"rt", strconv.FormatInt(event.Timestamp.UnixMilli(), 10)},
+		{"act", event.Action},
+		{"cat", event.Kind},
+		{"suser", event.Actor},
+		{"externalId", event.ID()},
+		{"dvchost", s.cfg.Hostname},
+		{"cs1Label", "namespace"}, {"cs1", event.Namespace},
+		{"cs2Label", "name"}, {"cs2", event.Name},
+		{"cs3Label", "uid"}, {"cs3", event.UID},
+		{"cs4Label", "watch"}, {"cs4", event.Watch},
+		{"cs5Label", "resourceVersion"}, {"cs5", event.ResourceVersion},
+		{"cn1Label", "changes"}, {"cn1", strconv.Itoa(len(event.Changes))},
+		{"msg", msg},
+	}
+
+	ext := make([]string, 0, len(extensions))
+	escape := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	for _, extension := range extensions {
+		if extension[1] == "" {
+			continue
+		}
+		ext = append(ext, extension[0]+"="+escape.Replace(extension[1]))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		header.Replace(s.cfg.DeviceVendor),
+		header.Replace(s.cfg.DeviceProduct),
+		header.Replace(s.cfg.DeviceVersion),
+		header.Replace(event.Action),
+		header.Replace(strings.TrimSpace(event.Action+" "+event.Kind)),
+		severity,
+		strings.Join(ext, " "))
+}
+
+// syslogSeverity returns the syslog severity, 0 to 7, and the CEF severity, 0 to 10, of event. Events of medium
+// severity and above are ranked by it, others by action.
+func syslogSeverity(event AuditEvent) (int, int) {
+	switch event.Severity {
+	case SeverityCritical:
+		return 2, 10
+	case SeverityHigh:
+		return 3, 8
+	case SeverityMedium:
+		return 4, 6
+	}
+
+	switch event.Action {
+	case "Delete":
+		return 4, 7
+	case "Create":
+		return 5, 3
+	default:
+		return 5, 5
+	}
+}
+
+// syslogHeaderField makes value a valid RFC 5424 header field of printable ASCII, at most max long. "-" if empty.
+func syslogHeaderField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// readNDJSON reads the events of the NDJSON file at path, one per line.
+func readNDJSON(path string) ([]AuditEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for decoder.More() {
+		var event AuditEvent
+		if err = decoder.Decode(&event); err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// writeNDJSON replaces the file at path with events, one per line.
+func writeNDJSON(path string, events []AuditEvent) error {
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := appendNDJSON(tmp, events); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
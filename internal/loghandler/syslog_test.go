@@ -0,0 +1,257 @@
+package loghandler
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// syslogReceiver is a syslog server keeping every message it receives.
+type syslogReceiver struct {
+	listener net.Listener
+	framing  string
+	mu       sync.Mutex
+	messages []string
+	conns    []net.Conn
+}
+
+func newSyslogReceiver(listener net.Listener, framing string) *syslogReceiver {
+	r := &syslogReceiver{listener: listener, framing: framing}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.conns = append(r.conns, conn)
+			r.mu.Unlock()
+			go r.read(conn)
+		}
+	}()
+	return r
+}
+
+func (r *syslogReceiver) read(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		var msg string
+		if r.framing == SyslogFramingNonTransparent {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			msg = strings.TrimSuffix(line, "\n")
+		} else {
+			prefix, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			size, err := strconv.Atoi(strings.TrimSpace(prefix))
+			if err != nil {
+				return
+			}
+			raw := make([]byte, size)
+			if _, err = io.ReadFull(reader, raw); err != nil {
+				return
+			}
+			msg = string(raw)
+		}
+		r.mu.Lock()
+		r.messages = append(r.messages, msg)
+		r.mu.Unlock()
+	}
+}
+
+func (r *syslogReceiver) Messages() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.messages...)
+}
+
+// Drop closes the connections of the receiver, as a restarting server would.
+func (r *syslogReceiver) Drop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.conns = nil
+}
+
+func (r *syslogReceiver) Close() {
+	_ = r.listener.Close()
+	r.Drop()
+}
+
+// rfc5424Pattern matches the header, structured data and message of an RFC 5424 message.
+var rfc5424Pattern = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) (\S+) (\S+) (-|\[.*?[^\\]\]) (.*)$`)
+
+var _ = Describe("SyslogSink", func() {
+	var (
+		cancel context.CancelFunc
+		done   chan struct{}
+	)
+
+	start := func(sink *SyslogSink) {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(sink.Start(ctx)).To(Succeed())
+			close(done)
+		}()
+	}
+
+	stop := func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+	}
+
+	It("Should send RFC 5424 messages with the event as structured data", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		receiver := newSyslogReceiver(listener, SyslogFramingOctetCounting)
+		defer receiver.Close()
+
+		sink, err := NewSyslogSink(SyslogConfig{Address: listener.Addr().String(), Hostname: "watchman-0"})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		event := makeEvent("app-1", "Delete")
+		event.Actor = "kubectl-edit"
+		event.Watch = "ns-1/my-watch"
+		event.Timestamp = time.Date(2024, 10, 18, 9, 15, 0, 0, time.UTC)
+		sink.Log(event)
+		Eventually(receiver.Messages).Should(HaveLen(1))
+
+		match := rfc5424Pattern.FindStringSubmatch(receiver.Messages()[0])
+		Expect(match).NotTo(BeNil())
+		// log audit facility, warning severity
+		Expect(match[1]).To(Equal("108"))
+		Expect(match[2]).To(Equal("2024-10-18T09:15:00.000000Z"))
+		Expect(match[3]).To(Equal("watchman-0"))
+		Expect(match[4]).To(Equal("watchman"))
+		Expect(match[6]).To(Equal("Delete"))
+		Expect(match[7]).To(Equal(`[audit@32473 kind="Deployment" name="app-1" namespace="ns-1" uid="uid-app-1" action="Delete" actor="kubectl-edit" watch="ns-1/my-watch" changes="1"]`))
+
+		var received AuditEvent
+		Expect(json.Unmarshal([]byte(match[8]), &received)).To(Succeed())
+		Expect(received.Name).To(Equal("app-1"))
+		Expect(received.Changes).To(HaveLen(1))
+	})
+
+	It("Should send CEF lines over TLS", func() {
+		server := httptest.NewUnstartedServer(nil)
+		server.StartTLS()
+		certificate, ca := server.TLS.Certificates[0], server.Certificate()
+		server.Close()
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600)).To(Succeed())
+
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+		Expect(err).NotTo(HaveOccurred())
+		receiver := newSyslogReceiver(listener, SyslogFramingNonTransparent)
+		defer receiver.Close()
+
+		sink, err := NewSyslogSink(SyslogConfig{
+			Address: listener.Addr().String(),
+			TLS:     &TLSConfig{CAFile: caFile},
+			Format:  SyslogFormatCEF,
+			Framing: SyslogFramingNonTransparent,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		event := makeEvent("app-1", "Update")
+		event.Actor = "helm=v3"
+		event.Timestamp = time.UnixMilli(1729242900000).UTC()
+		sink.Log(event)
+		Eventually(receiver.Messages).Should(HaveLen(1))
+
+		match := rfc5424Pattern.FindStringSubmatch(receiver.Messages()[0])
+		Expect(match).NotTo(BeNil())
+		Expect(match[7]).To(Equal("-"))
+		Expect(match[8]).To(HavePrefix("CEF:0|vandathron|watchman|1|Update|Update Deployment|5|rt=1729242900000 act=Update cat=Deployment "))
+		Expect(match[8]).To(ContainSubstring(`suser=helm\=v3 externalId=uid-app-1::Update `))
+		Expect(match[8]).To(ContainSubstring("cs1Label=namespace cs1=ns-1 cs2Label=name cs2=app-1 "))
+		Expect(match[8]).To(HaveSuffix("cn1Label=changes cn1=1 msg=replace .spec.replicas: 1 -> 3"))
+	})
+
+	It("Should spool events while the server is unreachable and send them once reconnected", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		spool := filepath.Join(GinkgoT().TempDir(), "spool.ndjson")
+		sink, err := NewSyslogSink(SyslogConfig{
+			Address:      address,
+			Format:       SyslogFormatCEF,
+			RetryBackoff: metav1.Duration{Duration: 50 * time.Millisecond},
+			SpoolPath:    spool,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		start(sink)
+		defer stop()
+
+		for i := 0; i < 3; i++ {
+			sink.Log(makeEvent(fmt.Sprintf("app-%d", i), "Update"))
+		}
+		Eventually(func() ([]AuditEvent, error) { return readNDJSON(spool) }).Should(HaveLen(3))
+
+		listener, err = net.Listen("tcp", address)
+		Expect(err).NotTo(HaveOccurred())
+		receiver := newSyslogReceiver(listener, SyslogFramingOctetCounting)
+		defer receiver.Close()
+
+		Eventually(receiver.Messages, 5*time.Second).Should(HaveLen(3))
+		for i, msg := range receiver.Messages() {
+			Expect(msg).To(ContainSubstring(fmt.Sprintf("cs2=app-%d ", i)))
+			Expect(msg).To(HaveSuffix("cn1Label=changes cn1=1 msg=replace .spec.replicas: 1 -> 3"))
+		}
+		Eventually(spool).ShouldNot(BeAnExistingFile())
+
+		// The sink reconnects once the server drops the connection
+		receiver.Drop()
+		Eventually(func() error {
+			sink.Log(makeEvent("app-3", "Update"))
+			for _, msg := range receiver.Messages() {
+				if strings.Contains(msg, "cs2=app-3 ") {
+					return nil
+				}
+			}
+			return fmt.Errorf("app-3 not received")
+		}, 5*time.Second, 100*time.Millisecond).Should(Succeed())
+	})
+
+	It("Should reject invalid configurations", func() {
+		_, err := NewSyslogSink(SyslogConfig{Address: "siem.example.com"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewSyslogSink(SyslogConfig{Address: "siem.example.com:6514", Format: "leef"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewSyslogSink(SyslogConfig{Address: "siem.example.com:6514", StructuredDataID: "audit id"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,129 @@
+package loghandler
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ChainReport is the result of verifying a stored log of hash chained events.
+type ChainReport struct {
+	// Events is the number of chained events read, checkpoints included
+	Events int
+	// Skipped is the number of lines that are not chained events
+	Skipped int
+	// Chains is the number of distinct chains found
+	Chains int
+	// Problems lists every gap, broken link, altered event or invalid signature found
+	Problems []string
+	// Unsigned lists the chains whose last events are not covered by a signed checkpoint
+	Unsigned []string
+	// Partial lists the chains not starting at sequence 1 in the log, e.g a file rotated by the file sink, whose first
+	// event can not be linked to the events before it. Only tolerated when verifying with allowPartial
+	Partial []string
+}
+
+// OK reports whether no problem was found.
+func (r *ChainReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+type chainState struct {
+	sequence       uint64
+	hash           string
+	lastCheckpoint uint64
+}
+
+// VerifyChain walks a log of JSON encoded events, one per line, and checks every chain in it. A chain not starting at
+// sequence 1 is a problem, its head having been removed, unless allowPartial is set, e.g to verify rotated files; its
+// first event is then trusted to link to the events before it. Checkpoint signatures are only verified when key is not
+// nil.
+func VerifyChain(r io.Reader, key ed25519.PublicKey, allowPartial bool) (*ChainReport, error) {
+	report := &ChainReport{}
+	chains := map[string]*chainState{}
+	var order []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Bytes()
+
+		var event struct {
+			Action string     `json:"action"`
+			Chain  *ChainLink `json:"chain"`
+		}
+		if err := json.Unmarshal(raw, &event); err != nil || event.Chain == nil || event.Chain.ID == "" {
+			report.Skipped++
+			continue
+		}
+		report.Events++
+		link := event.Chain
+
+		state, ok := chains[link.ID]
+		if !ok {
+			// The first event seen is trusted to link to the events before it, if any are allowed
+			state = &chainState{sequence: link.Sequence - 1, hash: link.PrevHash, lastCheckpoint: link.Sequence - 1}
+			chains[link.ID] = state
+			order = append(order, link.ID)
+			switch {
+			case link.Sequence > 1 && allowPartial:
+				report.Partial = append(report.Partial, fmt.Sprintf("chain %s: starts at sequence %d", link.ID, link.Sequence))
+			case link.Sequence > 1:
+				report.problem("line %d: chain %s: starts at sequence %d, events 1 to %d missing", line, link.ID, link.Sequence, link.Sequence-1)
+			}
+		}
+
+		switch {
+		case link.Sequence <= state.sequence:
+			report.problem("line %d: chain %s: sequence %d repeated or out of order after %d", line, link.ID, link.Sequence, state.sequence)
+		case link.Sequence > state.sequence+1:
+			report.problem("line %d: chain %s: gap, events %d to %d missing", line, link.ID, state.sequence+1, link.Sequence-1)
+		case link.PrevHash != state.hash:
+			report.problem("line %d: chain %s: sequence %d does not link to the previous event", line, link.ID, link.Sequence)
+		}
+
+		hash, err := chainHash(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if hash != link.Hash {
+			report.problem("line %d: chain %s: sequence %d was altered, hash does not match its content", line, link.ID, link.Sequence)
+		}
+
+		if event.Action == ActionCheckpoint && key != nil {
+			signature, err := hex.DecodeString(link.Signature)
+			if err != nil || !ed25519.Verify(key, []byte(link.Hash), signature) {
+				report.problem("line %d: chain %s: checkpoint %d has an invalid signature", line, link.ID, link.Sequence)
+			} else {
+				state.lastCheckpoint = link.Sequence
+			}
+		}
+
+		if link.Sequence > state.sequence {
+			state.sequence = link.Sequence
+		}
+		state.hash = link.Hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Chains = len(chains)
+	for _, id := range order {
+		if state := chains[id]; key != nil && state.lastCheckpoint < state.sequence {
+			report.Unsigned = append(report.Unsigned,
+				fmt.Sprintf("chain %s: events %d to %d are not covered by a signed checkpoint", id, state.lastCheckpoint+1, state.sequence))
+		}
+	}
+
+	return report, nil
+}
+
+func (r *ChainReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
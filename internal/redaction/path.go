@@ -0,0 +1,107 @@
+package redaction
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment is a single step of a path. A wildcard matches every key of a map or every item of a list.
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parsePath parses the JSONPath subset supported by policies e.g
+// .spec.template.spec.containers[*].env[*].value, .data.*, .metadata.annotations['example.com/token']
+func parsePath(path string) ([]segment, error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if p == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+
+	var segments []segment
+	for i := 0; i < len(p); {
+		switch p[i] {
+		case '.':
+			end := i + 1
+			for end < len(p) && p[end] != '.' && p[end] != '[' {
+				end++
+			}
+			key := p[i+1 : end]
+			if key == "" {
+				return nil, fmt.Errorf("empty key at offset %d in path %q", i, path)
+			}
+			segments = append(segments, segment{key: key, wildcard: key == "*"})
+			i = end
+
+		case '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated [ at offset %d in path %q", i, path)
+			}
+			inner := p[i+1 : i+end]
+			i += end + 1
+
+			switch {
+			case inner == "*":
+				segments = append(segments, segment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segments = append(segments, segment{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid index %q in path %q", inner, path)
+				}
+				segments = append(segments, segment{index: index, isIndex: true})
+			}
+
+		default:
+			return nil, fmt.Errorf("path %q must start with . or [", path)
+		}
+	}
+
+	return segments, nil
+}
+
+// redactPath replaces every value matched by segments in value with its hash with key.
+func redactPath(value interface{}, segments []segment, key []byte) {
+	if len(segments) == 0 {
+		return
+	}
+	seg, rest := segments[0], segments[1:]
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if seg.isIndex {
+			return
+		}
+		for k, child := range v {
+			if !seg.wildcard && k != seg.key {
+				continue
+			}
+			if len(rest) == 0 {
+				v[k] = Hash(key, child)
+				continue
+			}
+			redactPath(child, rest, key)
+		}
+
+	case []interface{}:
+		if !seg.isIndex && !seg.wildcard {
+			return
+		}
+		for i, child := range v {
+			if !seg.wildcard && i != seg.index {
+				continue
+			}
+			if len(rest) == 0 {
+				v[i] = Hash(key, child)
+				continue
+			}
+			redactPath(child, rest, key)
+		}
+	}
+}
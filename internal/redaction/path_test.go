@@ -0,0 +1,69 @@
+package redaction
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path", func() {
+	DescribeTable("Should parse supported paths",
+		func(path string, expected []segment) {
+			segments, err := parsePath(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(segments).To(Equal(expected))
+		},
+		Entry("keys", ".spec.replicas", []segment{{key: "spec"}, {key: "replicas"}}),
+		Entry("root", "$.data", []segment{{key: "data"}}),
+		Entry("wildcard key", ".data.*", []segment{{key: "data"}, {key: "*", wildcard: true}}),
+		Entry("wildcard index", ".containers[*].args", []segment{{key: "containers"}, {wildcard: true}, {key: "args"}}),
+		Entry("index", ".containers[1]", []segment{{key: "containers"}, {index: 1, isIndex: true}}),
+		Entry("quoted key", ".metadata.annotations['example.com/token']",
+			[]segment{{key: "metadata"}, {key: "annotations"}, {key: "example.com/token"}}),
+		Entry("double quoted key", `.data["a.b"]`, []segment{{key: "data"}, {key: "a.b"}}),
+	)
+
+	DescribeTable("Should reject invalid paths",
+		func(path string) {
+			_, err := parsePath(path)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("root only", "$"),
+		Entry("missing dot", "spec.replicas"),
+		Entry("empty key", ".spec..replicas"),
+		Entry("unterminated bracket", ".containers[0"),
+		Entry("negative index", ".containers[-1]"),
+		Entry("invalid index", ".containers[a]"),
+	)
+
+	It("Should redact every matched value", func() {
+		key := []byte("key")
+		obj := map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "app", "args": []interface{}{"--token=a"}},
+					map[string]interface{}{"name": "proxy", "args": []interface{}{"--token=b"}},
+				},
+			},
+		}
+
+		segments, err := parsePath(".spec.containers[*].args[0]")
+		Expect(err).NotTo(HaveOccurred())
+		redactPath(obj, segments, key)
+
+		containers := obj["spec"].(map[string]interface{})["containers"].([]interface{})
+		Expect(containers[0]).To(HaveKeyWithValue("args", []interface{}{Hash(key, "--token=a")}))
+		Expect(containers[1]).To(HaveKeyWithValue("args", []interface{}{Hash(key, "--token=b")}))
+		Expect(containers[0]).To(HaveKeyWithValue("name", "app"))
+	})
+
+	It("Should ignore segments not matching the value", func() {
+		obj := map[string]interface{}{"spec": map[string]interface{}{"replicas": 3}}
+		for _, path := range []string{".spec[0]", ".spec.replicas.value", ".status.replicas"} {
+			segments, err := parsePath(path)
+			Expect(err).NotTo(HaveOccurred())
+			redactPath(obj, segments, []byte("key"))
+		}
+		Expect(obj).To(Equal(map[string]interface{}{"spec": map[string]interface{}{"replicas": 3}}))
+	})
+})
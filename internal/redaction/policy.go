@@ -0,0 +1,205 @@
+// Package redaction replaces sensitive values of audited resources with hashes before they reach any sink.
+// Hashes are keyed HMACs, stable for a key so a change to a redacted value is still detectable, without letting anyone
+// lacking the key recover low entropy values by hashing guesses.
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// HashPrefix is prepended to every redacted value.
+const HashPrefix = "redacted:hmac-sha256:"
+
+// MinKeySize is the minimum size in bytes of a hash key.
+const MinKeySize = 32
+
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// DefaultKeyPatterns match keys and env var names containing a credential name, such as DB_PASSWORD, DBPASSWORD,
+// GITHUB_TOKEN2, api-key or SECRETKEY.
+var DefaultKeyPatterns = []string{
+	`(?i)(password|passwd|secret|token|api[_-]?key|credential|private[_-]?key)`,
+}
+
+// referenceKey matches the keys of fields naming a Secret or one of its keys rather than holding a credential, e.g
+// secretName, which key patterns leave readable.
+var referenceKey = regexp.MustCompile(`(Name|Ref)$`)
+
+// builtIn are always redacted regardless of the configured policy.
+var builtIn = map[string][][]segment{
+	"": mustParsePaths(fmt.Sprintf(".metadata.annotations['%s']", lastAppliedConfigAnnotation)),
+
+	"Secret": mustParsePaths(".data.*", ".stringData.*"),
+}
+
+// ephemeralKey hashes the values of policies without a key. It is generated on start, so their hashes differ across
+// restarts.
+var ephemeralKey = func() []byte {
+	key := make([]byte, MinKeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generating redaction key: %v", err))
+	}
+	return key
+}()
+
+// Policy is a set of redaction rules. The zero value and nil only apply the built-in rules, hashing with a key
+// generated on start.
+type Policy struct {
+	paths       [][]segment
+	keyPatterns []*regexp.Regexp
+	key         []byte
+}
+
+// NewPolicy creates a policy redacting values at paths and values of keys matching any of keyPatterns.
+func NewPolicy(paths, keyPatterns []string) (*Policy, error) {
+	p := &Policy{}
+
+	for _, path := range paths {
+		segments, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		p.paths = append(p.paths, segments)
+	}
+
+	for _, pattern := range keyPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key pattern %q: %w", pattern, err)
+		}
+		p.keyPatterns = append(p.keyPatterns, re)
+	}
+
+	return p, nil
+}
+
+// WithKey returns a copy of p hashing values with key, e.g read from a Secret. Keys shorter than MinKeySize are
+// rejected.
+func (p *Policy) WithKey(key []byte) (*Policy, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("redaction key must be at least %d bytes, got %d", MinKeySize, len(key))
+	}
+
+	keyed := p.Merge(nil)
+	keyed.key = key
+	return keyed, nil
+}
+
+// Merge returns a policy applying the rules of both p and other, hashing with the key of p, or of other if p has
+// none.
+func (p *Policy) Merge(other *Policy) *Policy {
+	merged := &Policy{}
+	for _, policy := range []*Policy{p, other} {
+		if policy == nil {
+			continue
+		}
+		merged.paths = append(merged.paths, policy.paths...)
+		merged.keyPatterns = append(merged.keyPatterns, policy.keyPatterns...)
+		if merged.key == nil {
+			merged.key = policy.key
+		}
+	}
+	return merged
+}
+
+// Redact replaces sensitive values in obj, the unstructured content of a resource of the given kind, with their hash.
+func (p *Policy) Redact(kind string, obj map[string]interface{}) {
+	key := p.hashKey()
+	for _, k := range []string{"", kind} {
+		for _, segments := range builtIn[k] {
+			redactPath(obj, segments, key)
+		}
+	}
+
+	if p == nil {
+		return
+	}
+
+	for _, segments := range p.paths {
+		redactPath(obj, segments, key)
+	}
+
+	if len(p.keyPatterns) > 0 {
+		p.redactKeys(obj, key)
+	}
+}
+
+// Keyed reports whether p hashes with a configured key rather than the one generated on start, its hashes then being
+// stable across restarts.
+func (p *Policy) Keyed() bool {
+	return p != nil && p.key != nil
+}
+
+func (p *Policy) hashKey() []byte {
+	if p == nil || p.key == nil {
+		return ephemeralKey
+	}
+	return p.key
+}
+
+// redactKeys redacts the string values of map keys matching a key pattern as well as the value of name/value pairs
+// such as container env vars whose name matches. Objects and lists are never redacted as a whole, so references to
+// Secrets such as secret volumes, envFrom secretRef, valueFrom secretKeyRef and imagePullSecrets stay readable, as do
+// flags such as automountServiceAccountToken.
+func (p *Policy) redactKeys(value interface{}, key []byte) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok && p.matchesKey(name) {
+			if val, ok := v["value"]; ok {
+				v["value"] = Hash(key, val)
+			}
+		}
+
+		for k, child := range v {
+			if _, leaf := child.(string); leaf && p.matchesKey(k) && !referenceKey.MatchString(k) {
+				v[k] = Hash(key, child)
+				continue
+			}
+			p.redactKeys(child, key)
+		}
+
+	case []interface{}:
+		for _, child := range v {
+			p.redactKeys(child, key)
+		}
+	}
+}
+
+func (p *Policy) matchesKey(key string) bool {
+	for _, re := range p.keyPatterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash returns the redacted form of value, its HMAC-SHA256 with key.
+func Hash(key []byte, value interface{}) string {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		raw = []byte(fmt.Sprintf("%v", value))
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return HashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func mustParsePaths(paths ...string) [][]segment {
+	parsed := make([][]segment, 0, len(paths))
+	for _, path := range paths {
+		segments, err := parsePath(path)
+		if err != nil {
+			panic(err)
+		}
+		parsed = append(parsed, segments)
+	}
+	return parsed
+}
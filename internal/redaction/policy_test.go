@@ -0,0 +1,148 @@
+package redaction
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	key := bytes.Repeat([]byte("k"), MinKeySize)
+
+	newPolicy := func(paths, keyPatterns []string) *Policy {
+		policy, err := NewPolicy(paths, keyPatterns)
+		Expect(err).NotTo(HaveOccurred())
+		policy, err = policy.WithKey(key)
+		Expect(err).NotTo(HaveOccurred())
+		return policy
+	}
+
+	It("Should hash values with HMAC-SHA256", func() {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(`"hunter2"`))
+
+		Expect(Hash(key, "hunter2")).To(Equal(HashPrefix + hex.EncodeToString(mac.Sum(nil))))
+		Expect(Hash(key, "hunter2")).To(Equal(Hash(key, "hunter2")))
+		Expect(Hash(key, "hunter2")).NotTo(Equal(Hash([]byte("other"), "hunter2")))
+		Expect(Hash(key, "hunter2")).NotTo(Equal(Hash(key, "hunter3")))
+	})
+
+	It("Should only report policies with a configured key as keyed", func() {
+		var none *Policy
+		Expect(none.Keyed()).To(BeFalse())
+		Expect(newPolicy(nil, nil).Keyed()).To(BeTrue())
+		Expect((&Policy{}).Merge(newPolicy(nil, nil)).Keyed()).To(BeTrue())
+	})
+
+	It("Should reject short keys", func() {
+		_, err := (&Policy{}).WithKey([]byte("short"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reject invalid paths and key patterns", func() {
+		_, err := NewPolicy([]string{"spec"}, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewPolicy(nil, []string{"("})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should always redact the built-in paths", func() {
+		obj := map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]interface{}{lastAppliedConfigAnnotation: "{}", "team": "a"}},
+			"data":     map[string]interface{}{"password": "c2VjcmV0"},
+		}
+
+		var policy *Policy
+		policy.Redact("Secret", obj)
+
+		annotations := obj["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+		Expect(annotations[lastAppliedConfigAnnotation]).To(HavePrefix(HashPrefix))
+		Expect(annotations["team"]).To(Equal("a"))
+		Expect(obj["data"].(map[string]interface{})["password"]).To(Equal(Hash(ephemeralKey, "c2VjcmV0")))
+	})
+
+	It("Should only redact Secret data for Secrets", func() {
+		obj := map[string]interface{}{"data": map[string]interface{}{"config": "value"}}
+		newPolicy(nil, nil).Redact("ConfigMap", obj)
+		Expect(obj["data"]).To(HaveKeyWithValue("config", "value"))
+	})
+
+	DescribeTable("Should redact the values of keys matching the default patterns",
+		func(name string, redacted bool) {
+			obj := map[string]interface{}{
+				"spec": map[string]interface{}{
+					name:  "value",
+					"env": []interface{}{map[string]interface{}{"name": name, "value": "value"}},
+				},
+			}
+			newPolicy(nil, DefaultKeyPatterns).Redact("Deployment", obj)
+
+			spec := obj["spec"].(map[string]interface{})
+			env := spec["env"].([]interface{})[0].(map[string]interface{})
+			Expect(strings.HasPrefix(spec[name].(string), HashPrefix)).To(Equal(redacted))
+			Expect(strings.HasPrefix(env["value"].(string), HashPrefix)).To(Equal(redacted))
+		},
+		Entry("password", "DB_PASSWORD", true),
+		Entry("token", "token", true),
+		Entry("api key", "api-key", true),
+		Entry("private key", "tls.private_key", true),
+		Entry("credentials", "AWS_CREDENTIALS", true),
+		Entry("password without separator", "DBPASSWORD", true),
+		Entry("password with a suffix", "PASSWORD1", true),
+		Entry("token with a suffix", "GITHUB_TOKEN2", true),
+		Entry("secret without separator", "SECRETKEY", true),
+		Entry("camel case", "clientSecret", true),
+		Entry("unrelated", "replicas", false),
+	)
+
+	It("Should keep the references to Secrets readable", func() {
+		podSpec := func() map[string]interface{} {
+			return map[string]interface{}{
+				"automountServiceAccountToken": false,
+				"imagePullSecrets":             []interface{}{map[string]interface{}{"name": "registry-credentials"}},
+				"volumes": []interface{}{map[string]interface{}{
+					"name":   "tls",
+					"secret": map[string]interface{}{"secretName": "api-tls"},
+				}},
+				"containers": []interface{}{map[string]interface{}{
+					"name":    "api",
+					"envFrom": []interface{}{map[string]interface{}{"secretRef": map[string]interface{}{"name": "api-env"}}},
+					"env": []interface{}{map[string]interface{}{
+						"name":      "DB_PASSWORD",
+						"valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "db", "key": "password"}},
+					}},
+				}},
+			}
+		}
+
+		obj := map[string]interface{}{"spec": podSpec()}
+		newPolicy(nil, DefaultKeyPatterns).Redact("Pod", obj)
+		Expect(obj["spec"]).To(Equal(podSpec()))
+	})
+
+	It("Should redact configured paths", func() {
+		obj := map[string]interface{}{"spec": map[string]interface{}{"args": []interface{}{"--a", "--b"}}}
+		newPolicy([]string{".spec.args[1]"}, nil).Redact("Deployment", obj)
+		Expect(obj["spec"]).To(HaveKeyWithValue("args", []interface{}{"--a", Hash(key, "--b")}))
+	})
+
+	It("Should merge the rules and keep the key of either policy", func() {
+		operator := newPolicy([]string{".spec.a"}, nil)
+		watch, err := NewPolicy([]string{".spec.b"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, merged := range []*Policy{operator.Merge(watch), watch.Merge(operator)} {
+			obj := map[string]interface{}{"spec": map[string]interface{}{"a": "1", "b": "2", "c": "3"}}
+			merged.Redact("Deployment", obj)
+			Expect(obj["spec"]).To(Equal(map[string]interface{}{"a": Hash(key, "1"), "b": Hash(key, "2"), "c": "3"}))
+		}
+
+		var none *Policy
+		Expect(none.Merge(nil)).To(Equal(&Policy{}))
+	})
+})
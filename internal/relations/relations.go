@@ -0,0 +1,217 @@
+// Package relations resolves the objects related to an audited resource: its owners, the Services selecting its pods,
+// the HPAs scaling it, the PDBs covering it and the Ingresses routing to it, and the other way around.
+package relations
+
+import (
+	"context"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/utils"
+)
+
+// Kinds of related objects besides the supported kinds.
+const (
+	KindHorizontalPodAutoscaler = "HorizontalPodAutoscaler"
+	KindPodDisruptionBudget     = "PodDisruptionBudget"
+	KindIngress                 = "Ingress"
+)
+
+// Kinds are the kinds of objects audited as related objects.
+var Kinds = []string{utils.SupportedKindService, KindHorizontalPodAutoscaler, KindPodDisruptionBudget, KindIngress}
+
+// Relations, the role of a related object for the resource.
+const (
+	// RelationOwner owns the resource
+	RelationOwner = "Owner"
+	// RelationService selects the pods of the Deployment
+	RelationService = "Service"
+	// RelationAutoscaler scales the Deployment
+	RelationAutoscaler = "Autoscaler"
+	// RelationDisruptionBudget limits the disruptions of the pods of the Deployment
+	RelationDisruptionBudget = "DisruptionBudget"
+	// RelationIngress routes to the Service, or to the Deployment through a Service
+	RelationIngress = "Ingress"
+	// RelationBackend is the Deployment whose pods the Service or PDB select, or the Service the Ingress routes to
+	RelationBackend = "Backend"
+	// RelationScaleTarget is the Deployment the HPA scales
+	RelationScaleTarget = "ScaleTarget"
+)
+
+// Objects are the objects of a namespace relations are resolved among.
+type Objects struct {
+	Deployments       []appsv1.Deployment
+	Services          []corev1.Service
+	Autoscalers       []autoscalingv2.HorizontalPodAutoscaler
+	DisruptionBudgets []policyv1.PodDisruptionBudget
+	Ingresses         []networkingv1.Ingress
+}
+
+// Of returns the objects among in related to obj, a Deployment, Service, HorizontalPodAutoscaler,
+// PodDisruptionBudget or Ingress of the same namespace.
+func Of(obj client.Object, in Objects) []loghandler.RelatedObject {
+	var related []loghandler.RelatedObject
+	add := func(kind, name, relation string) {
+		object := loghandler.RelatedObject{Kind: kind, Name: name, Relation: relation}
+		if !slices.Contains(related, object) {
+			related = append(related, object)
+		}
+	}
+
+	for _, owner := range obj.GetOwnerReferences() {
+		add(owner.Kind, owner.Name, RelationOwner)
+	}
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		podLabels := labels.Set(o.Spec.Template.Labels)
+		for _, svc := range in.Services {
+			if !selects(svc.Spec.Selector, podLabels) {
+				continue
+			}
+			add(utils.SupportedKindService, svc.Name, RelationService)
+			for _, ingress := range in.Ingresses {
+				if routesTo(ingress, svc.Name) {
+					add(KindIngress, ingress.Name, RelationIngress)
+				}
+			}
+		}
+		for _, hpa := range in.Autoscalers {
+			if scales(hpa, o.Name) {
+				add(KindHorizontalPodAutoscaler, hpa.Name, RelationAutoscaler)
+			}
+		}
+		for _, pdb := range in.DisruptionBudgets {
+			if covers(pdb, podLabels) {
+				add(KindPodDisruptionBudget, pdb.Name, RelationDisruptionBudget)
+			}
+		}
+
+	case *corev1.Service:
+		for _, deployment := range in.Deployments {
+			if selects(o.Spec.Selector, labels.Set(deployment.Spec.Template.Labels)) {
+				add(utils.SupportedKindDeployment, deployment.Name, RelationBackend)
+			}
+		}
+		for _, ingress := range in.Ingresses {
+			if routesTo(ingress, o.Name) {
+				add(KindIngress, ingress.Name, RelationIngress)
+			}
+		}
+
+	case *autoscalingv2.HorizontalPodAutoscaler:
+		for _, deployment := range in.Deployments {
+			if scales(*o, deployment.Name) {
+				add(utils.SupportedKindDeployment, deployment.Name, RelationScaleTarget)
+			}
+		}
+
+	case *policyv1.PodDisruptionBudget:
+		for _, deployment := range in.Deployments {
+			if covers(*o, labels.Set(deployment.Spec.Template.Labels)) {
+				add(utils.SupportedKindDeployment, deployment.Name, RelationBackend)
+			}
+		}
+
+	case *networkingv1.Ingress:
+		for _, svc := range in.Services {
+			if routesTo(*o, svc.Name) {
+				add(utils.SupportedKindService, svc.Name, RelationBackend)
+			}
+		}
+	}
+
+	return related
+}
+
+// Resolver lists the objects of the namespace of a resource to resolve its relations.
+type Resolver struct {
+	Reader client.Reader
+}
+
+// Resolve returns the objects related to obj.
+func (r *Resolver) Resolve(ctx context.Context, obj client.Object) ([]loghandler.RelatedObject, error) {
+	var in Objects
+	namespace := client.InNamespace(obj.GetNamespace())
+
+	deployments := &appsv1.DeploymentList{}
+	services := &corev1.ServiceList{}
+	autoscalers := &autoscalingv2.HorizontalPodAutoscalerList{}
+	disruptionBudgets := &policyv1.PodDisruptionBudgetList{}
+	ingresses := &networkingv1.IngressList{}
+
+	// Only the kinds a relation of obj may be of are listed
+	var lists []client.ObjectList
+	switch obj.(type) {
+	case *appsv1.Deployment:
+		lists = []client.ObjectList{services, autoscalers, disruptionBudgets, ingresses}
+	case *corev1.Service:
+		lists = []client.ObjectList{deployments, ingresses}
+	case *autoscalingv2.HorizontalPodAutoscaler, *policyv1.PodDisruptionBudget:
+		lists = []client.ObjectList{deployments}
+	case *networkingv1.Ingress:
+		lists = []client.ObjectList{services}
+	}
+	for _, list := range lists {
+		if err := r.Reader.List(ctx, list, namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	in.Deployments, in.Services, in.Autoscalers = deployments.Items, services.Items, autoscalers.Items
+	in.DisruptionBudgets, in.Ingresses = disruptionBudgets.Items, ingresses.Items
+	return Of(obj, in), nil
+}
+
+// selects reports whether a Service selector selects pods labelled podLabels. An empty selector selects none.
+func selects(selector map[string]string, podLabels labels.Set) bool {
+	return len(selector) > 0 && labels.SelectorFromSet(selector).Matches(podLabels)
+}
+
+// covers reports whether pdb selects pods labelled podLabels.
+func covers(pdb policyv1.PodDisruptionBudget, podLabels labels.Set) bool {
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil || pdb.Spec.Selector == nil {
+		return false
+	}
+	return selector.Matches(podLabels)
+}
+
+// scales reports whether hpa targets the Deployment name.
+func scales(hpa autoscalingv2.HorizontalPodAutoscaler, name string) bool {
+	target := hpa.Spec.ScaleTargetRef
+	gv, err := schema.ParseGroupVersion(target.APIVersion)
+	return err == nil && gv.Group == appsv1.GroupName && target.Kind == utils.SupportedKindDeployment && target.Name == name
+}
+
+// routesTo reports whether ingress has a backend of the Service name.
+func routesTo(ingress networkingv1.Ingress, name string) bool {
+	isService := func(backend *networkingv1.IngressBackend) bool {
+		return backend != nil && backend.Service != nil && backend.Service.Name == name
+	}
+
+	if isService(ingress.Spec.DefaultBackend) {
+		return true
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if isService(&path.Backend) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package relations
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+var _ = Describe("Relations", func() {
+	var in Objects
+	var deployment *appsv1.Deployment
+
+	service := func(name string, selector map[string]string) corev1.Service {
+		return corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.ServiceSpec{Selector: selector}}
+	}
+	ingress := func(name, service string) networkingv1.Ingress {
+		return networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{Name: service},
+					}}},
+				}},
+			}}},
+		}
+	}
+	hpa := func(name, apiVersion, kind, target string) autoscalingv2.HorizontalPodAutoscaler {
+		return autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: apiVersion, Kind: kind, Name: target},
+			},
+		}
+	}
+	pdb := func(name string, selector *metav1.LabelSelector) policyv1.PodDisruptionBudget {
+		return policyv1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: policyv1.PodDisruptionBudgetSpec{Selector: selector}}
+	}
+
+	BeforeEach(func() {
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Application", Name: "shop"}},
+			},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web", "tier": "frontend"}},
+			}},
+		}
+		in = Objects{
+			Deployments: []appsv1.Deployment{*deployment},
+			Services: []corev1.Service{
+				service("web", map[string]string{"app": "web"}),
+				service("db", map[string]string{"app": "db"}),
+				service("external", nil),
+			},
+			Autoscalers: []autoscalingv2.HorizontalPodAutoscaler{
+				hpa("web", "apps/v1", "Deployment", "web"),
+				hpa("other", "apps/v1", "StatefulSet", "web"),
+			},
+			DisruptionBudgets: []policyv1.PodDisruptionBudget{
+				pdb("web", &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}}),
+				pdb("db", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}),
+			},
+			Ingresses: []networkingv1.Ingress{ingress("shop", "web"), ingress("admin", "db")},
+		}
+	})
+
+	It("Should resolve the objects related to a Deployment", func() {
+		Expect(Of(deployment, in)).To(ConsistOf(
+			loghandler.RelatedObject{Kind: "Application", Name: "shop", Relation: RelationOwner},
+			loghandler.RelatedObject{Kind: "Service", Name: "web", Relation: RelationService},
+			loghandler.RelatedObject{Kind: KindIngress, Name: "shop", Relation: RelationIngress},
+			loghandler.RelatedObject{Kind: KindHorizontalPodAutoscaler, Name: "web", Relation: RelationAutoscaler},
+			loghandler.RelatedObject{Kind: KindPodDisruptionBudget, Name: "web", Relation: RelationDisruptionBudget},
+		))
+	})
+
+	It("Should resolve the objects related to a Service", func() {
+		svc := in.Services[0]
+		Expect(Of(&svc, in)).To(ConsistOf(
+			loghandler.RelatedObject{Kind: "Deployment", Name: "web", Relation: RelationBackend},
+			loghandler.RelatedObject{Kind: KindIngress, Name: "shop", Relation: RelationIngress},
+		))
+
+		svc = in.Services[2]
+		Expect(Of(&svc, in)).To(BeEmpty())
+	})
+
+	It("Should resolve the resources an HPA, PDB or Ingress relates to", func() {
+		Expect(Of(&in.Autoscalers[0], in)).To(ConsistOf(loghandler.RelatedObject{Kind: "Deployment", Name: "web", Relation: RelationScaleTarget}))
+		Expect(Of(&in.Autoscalers[1], in)).To(BeEmpty())
+		Expect(Of(&in.DisruptionBudgets[0], in)).To(ConsistOf(loghandler.RelatedObject{Kind: "Deployment", Name: "web", Relation: RelationBackend}))
+		Expect(Of(&in.Ingresses[1], in)).To(ConsistOf(loghandler.RelatedObject{Kind: "Service", Name: "db", Relation: RelationBackend}))
+	})
+})
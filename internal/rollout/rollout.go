@@ -0,0 +1,79 @@
+// Package rollout follows the rollout of a Deployment template change through the status of the Deployment and its
+// ReplicaSets, deciding how it ended.
+package rollout
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Actions of the events ending a rollout.
+const (
+	// ActionSucceeded is emitted once every replica runs the new template
+	ActionSucceeded = "RolloutSucceeded"
+	// ActionStalled is emitted once the rollout exceeds its progress deadline
+	ActionStalled = "RolloutStalled"
+	// ActionRolledBack is emitted once every replica runs a template the Deployment already ran before
+	ActionRolledBack = "RolledBack"
+)
+
+// Annotations the deployment controller sets on Deployments and ReplicaSets.
+const (
+	RevisionAnnotation        = "deployment.kubernetes.io/revision"
+	RevisionHistoryAnnotation = "deployment.kubernetes.io/revision-history"
+)
+
+// progressDeadlineExceeded is the reason of the Progressing condition of a stalled Deployment.
+const progressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+// TemplateChanged reports whether the update of old to new starts a rollout.
+func TemplateChanged(old, new *appsv1.Deployment) bool {
+	return !equality.Semantic.DeepEqual(old.Spec.Template, new.Spec.Template)
+}
+
+// Outcome returns ActionSucceeded or ActionStalled once the rollout of deployment at generation ended, empty while it
+// is in progress. A rollout ending on a reused ReplicaSet is told apart with RolledBack.
+func Outcome(deployment *appsv1.Deployment, generation int64) string {
+	if deployment.Status.ObservedGeneration < generation {
+		return ""
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse && condition.Reason == progressDeadlineExceeded {
+			return ActionStalled
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	if status.UpdatedReplicas < replicas || status.Replicas > status.UpdatedReplicas || status.AvailableReplicas < status.UpdatedReplicas {
+		return ""
+	}
+	return ActionSucceeded
+}
+
+// NewReplicaSet returns the ReplicaSet of deployment running its current revision among replicaSets, nil if it is not
+// created yet.
+func NewReplicaSet(deployment *appsv1.Deployment, replicaSets []appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	revision := deployment.Annotations[RevisionAnnotation]
+	if revision == "" {
+		return nil
+	}
+	for i, rs := range replicaSets {
+		if metav1.IsControlledBy(&rs, deployment) && rs.Annotations[RevisionAnnotation] == revision {
+			return &replicaSets[i]
+		}
+	}
+	return nil
+}
+
+// RolledBack reports whether rs ran an earlier revision of its Deployment, i.e the rollout went back to it rather than
+// creating a ReplicaSet.
+func RolledBack(rs *appsv1.ReplicaSet) bool {
+	return rs != nil && rs.Annotations[RevisionHistoryAnnotation] != ""
+}
@@ -0,0 +1,14 @@
+package rollout
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRollout(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Rollout Suite")
+}
@@ -0,0 +1,83 @@
+package rollout
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("Rollout", func() {
+	var deployment *appsv1.Deployment
+
+	BeforeEach(func() {
+		deployment = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "app", Namespace: "default", UID: "uid-1", Generation: 2,
+				Annotations: map[string]string{RevisionAnnotation: "3"},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To[int32](3),
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:2"}}}},
+			},
+			Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 2, AvailableReplicas: 3},
+		}
+	})
+
+	It("Should detect template changes only", func() {
+		updated := deployment.DeepCopy()
+		updated.Spec.Replicas = ptr.To[int32](5)
+		Expect(TemplateChanged(deployment, updated)).To(BeFalse())
+
+		updated.Spec.Template.Spec.Containers[0].Image = "app:3"
+		Expect(TemplateChanged(deployment, updated)).To(BeTrue())
+	})
+
+	It("Should be in progress until every replica is updated and available", func() {
+		Expect(Outcome(deployment, 2)).To(BeEmpty())
+
+		deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+		Expect(Outcome(deployment, 2)).To(Equal(ActionSucceeded))
+	})
+
+	It("Should be in progress until the generation of the change is observed", func() {
+		deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+		Expect(Outcome(deployment, 2)).To(BeEmpty())
+	})
+
+	It("Should stall once the progress deadline is exceeded", func() {
+		deployment.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded",
+		}}
+		Expect(Outcome(deployment, 2)).To(Equal(ActionStalled))
+	})
+
+	It("Should find the new replica set and tell rollbacks apart", func() {
+		owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "uid-1", Controller: ptr.To(true)}
+		replicaSet := func(name, revision, history string) appsv1.ReplicaSet {
+			annotations := map[string]string{RevisionAnnotation: revision}
+			if history != "" {
+				annotations[RevisionHistoryAnnotation] = history
+			}
+			return appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "default", Annotations: annotations, OwnerReferences: []metav1.OwnerReference{owner},
+			}}
+		}
+
+		replicaSets := []appsv1.ReplicaSet{replicaSet("app-a", "2", ""), replicaSet("app-b", "3", "")}
+		rs := NewReplicaSet(deployment, replicaSets)
+		Expect(rs).NotTo(BeNil())
+		Expect(rs.Name).To(Equal("app-b"))
+		Expect(RolledBack(rs)).To(BeFalse())
+
+		replicaSets = []appsv1.ReplicaSet{replicaSet("app-a", "3", "1"), replicaSet("app-b", "2", "")}
+		rs = NewReplicaSet(deployment, replicaSets)
+		Expect(rs.Name).To(Equal("app-a"))
+		Expect(RolledBack(rs)).To(BeTrue())
+
+		replicaSets[0].OwnerReferences = nil
+		Expect(NewReplicaSet(deployment, replicaSets)).To(BeNil())
+	})
+})
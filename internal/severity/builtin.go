@@ -0,0 +1,127 @@
+package severity
+
+import (
+	"strings"
+
+	"github.com/vandathron/watchman/internal/expression"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"github.com/vandathron/watchman/internal/utils"
+)
+
+// Names of the built-in rules.
+const (
+	RuleImageLatest         = "image-latest"
+	RulePrivilegedContainer = "privileged-container"
+	RuleLoadBalancer        = "service-load-balancer"
+	RuleScaledToZero        = "scaled-to-zero"
+	RuleDeleted             = "deleted"
+)
+
+// BuiltIn are the rules every classifier applies.
+var BuiltIn = []Rule{
+	{Name: RuleImageLatest, Severity: loghandler.SeverityHigh, match: infallible(imageLatest)},
+	{Name: RulePrivilegedContainer, Severity: loghandler.SeverityCritical, match: infallible(privilegedContainer)},
+	{Name: RuleLoadBalancer, Severity: loghandler.SeverityHigh, match: infallible(loadBalancer)},
+	{Name: RuleScaledToZero, Severity: loghandler.SeverityHigh, match: infallible(scaledToZero)},
+	{Name: RuleDeleted, Severity: loghandler.SeverityMedium, match: infallible(deleted)},
+}
+
+func infallible(match func(in expression.Input) bool) func(in expression.Input) (bool, error) {
+	return func(in expression.Input) (bool, error) {
+		return match(in), nil
+	}
+}
+
+// imageLatest matches a container newly running an image tagged latest or without a tag or digest.
+func imageLatest(in expression.Input) bool {
+	old := containers(in.Old)
+	for name, container := range containers(in.New) {
+		image, _ := container["image"].(string)
+		if !isLatest(image) {
+			continue
+		}
+		if previous, ok := old[name]; !ok || previous["image"] != image {
+			return true
+		}
+	}
+	return false
+}
+
+// privilegedContainer matches a container becoming privileged.
+func privilegedContainer(in expression.Input) bool {
+	old := containers(in.Old)
+	for name, container := range containers(in.New) {
+		if isPrivileged(container) && !isPrivileged(old[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBalancer matches a Service becoming of type LoadBalancer.
+func loadBalancer(in expression.Input) bool {
+	if in.Kind != utils.SupportedKindService {
+		return false
+	}
+	return field(in.New, "spec", "type") == "LoadBalancer" && field(in.Old, "spec", "type") != "LoadBalancer"
+}
+
+// scaledToZero matches an update setting replicas to zero.
+func scaledToZero(in expression.Input) bool {
+	if in.Old == nil || in.New == nil {
+		return false
+	}
+	replicas, ok := field(in.New, "spec", "replicas").(int64)
+	if !ok || replicas != 0 {
+		return false
+	}
+	previous, ok := field(in.Old, "spec", "replicas").(int64)
+	return !ok || previous != 0 // unset replicas default to 1
+}
+
+func deleted(in expression.Input) bool {
+	return in.Action == utils.WatchActionTypeDelete
+}
+
+// containers returns the containers and init containers of the pod template of obj by name.
+func containers(obj map[string]interface{}) map[string]map[string]interface{} {
+	byName := map[string]map[string]interface{}{}
+	for _, key := range []string{"initContainers", "containers"} {
+		list, _ := field(obj, "spec", "template", "spec", key).([]interface{})
+		for _, item := range list {
+			if container, ok := item.(map[string]interface{}); ok {
+				name, _ := container["name"].(string)
+				byName[name] = container
+			}
+		}
+	}
+	return byName
+}
+
+func isPrivileged(container map[string]interface{}) bool {
+	privileged, _ := field(container, "securityContext", "privileged").(bool)
+	return privileged
+}
+
+// isLatest reports whether image resolves to the latest tag. e.g (nginx, nginx:latest, registry:5000/nginx)
+func isLatest(image string) bool {
+	if image == "" || strings.Contains(image, "@") {
+		return false
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	return i < 0 || name[i+1:] == "latest"
+}
+
+// field returns the value at path in obj, nil if any part of it is missing.
+func field(obj map[string]interface{}, path ...string) interface{} {
+	var value interface{} = obj
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
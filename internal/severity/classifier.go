@@ -0,0 +1,68 @@
+// Package severity classifies audit events by how risky the change they record is, with built-in rules and the CEL
+// rules of watches.
+package severity
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vandathron/watchman/internal/expression"
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+// Rule classifies the events it matches with Severity.
+type Rule struct {
+	Name     string
+	Severity string
+	match    func(in expression.Input) (bool, error)
+}
+
+// NewRule creates a rule matching the events satisfying condition, a CEL expression.
+func NewRule(name, severity, condition string) (Rule, error) {
+	if name == "" {
+		return Rule{}, fmt.Errorf("rule name can not be empty")
+	}
+	if loghandler.SeverityRank(severity) < 0 {
+		return Rule{}, fmt.Errorf("rule %s: unknown severity %q", name, severity)
+	}
+
+	c, err := expression.NewCondition(condition)
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %s: %w", name, err)
+	}
+	return Rule{Name: name, Severity: severity, match: c.Match}, nil
+}
+
+// Classifier assigns events the highest severity of the rules they match, info if none.
+type Classifier struct {
+	rules []Rule
+}
+
+// NewClassifier creates a classifier applying the built-in rules along with rules.
+func NewClassifier(rules ...Rule) *Classifier {
+	return &Classifier{rules: append(append([]Rule{}, BuiltIn...), rules...)}
+}
+
+// Classify returns the severity of the event of in and the names of the rules it matches. Rules failing to evaluate
+// are considered not matching and reported in err.
+func (c *Classifier) Classify(in expression.Input) (severity string, rules []string, err error) {
+	severity = loghandler.SeverityInfo
+
+	var errs []error
+	for _, rule := range c.rules {
+		matched, matchErr := rule.match(in)
+		if matchErr != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, matchErr))
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		rules = append(rules, rule.Name)
+		if loghandler.SeverityRank(rule.Severity) > loghandler.SeverityRank(severity) {
+			severity = rule.Severity
+		}
+	}
+	return severity, rules, errors.Join(errs...)
+}
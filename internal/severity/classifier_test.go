@@ -0,0 +1,97 @@
+package severity
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vandathron/watchman/internal/expression"
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+var _ = Describe("Classifier", func() {
+	deployment := func(replicas int64, containers ...map[string]interface{}) map[string]interface{} {
+		list := make([]interface{}, 0, len(containers))
+		for _, container := range containers {
+			list = append(list, container)
+		}
+		return map[string]interface{}{"spec": map[string]interface{}{
+			"replicas": replicas,
+			"template": map[string]interface{}{"spec": map[string]interface{}{"containers": list}},
+		}}
+	}
+	container := func(image string, privileged bool) map[string]interface{} {
+		return map[string]interface{}{
+			"name":            "app",
+			"image":           image,
+			"securityContext": map[string]interface{}{"privileged": privileged},
+		}
+	}
+
+	classifier := NewClassifier()
+
+	It("Should classify events matching no rule as info", func() {
+		severity, rules, err := classifier.Classify(expression.Input{
+			Kind:   "Deployment",
+			Action: "Update",
+			Old:    deployment(3, container("app:1", false)),
+			New:    deployment(2, container("app:2", false)),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(severity).To(Equal(loghandler.SeverityInfo))
+		Expect(rules).To(BeEmpty())
+	})
+
+	It("Should apply the highest severity of the built-in rules matched", func() {
+		severity, rules, err := classifier.Classify(expression.Input{
+			Kind:   "Deployment",
+			Action: "Update",
+			Old:    deployment(3, container("app:1", false)),
+			New:    deployment(0, container("app:latest", true)),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(severity).To(Equal(loghandler.SeverityCritical))
+		Expect(rules).To(ConsistOf(RuleImageLatest, RulePrivilegedContainer, RuleScaledToZero))
+	})
+
+	It("Should match images without a tag and leave pinned ones", func() {
+		Expect(isLatest("nginx")).To(BeTrue())
+		Expect(isLatest("registry:5000/nginx")).To(BeTrue())
+		Expect(isLatest("nginx:latest")).To(BeTrue())
+		Expect(isLatest("registry:5000/nginx:1.27")).To(BeFalse())
+		Expect(isLatest("nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31")).To(BeFalse())
+	})
+
+	It("Should match Services becoming load balancers", func() {
+		service := func(serviceType string) map[string]interface{} {
+			return map[string]interface{}{"spec": map[string]interface{}{"type": serviceType}}
+		}
+
+		severity, rules, err := classifier.Classify(expression.Input{Kind: "Service", Action: "Update", Old: service("ClusterIP"), New: service("LoadBalancer")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(severity).To(Equal(loghandler.SeverityHigh))
+		Expect(rules).To(Equal([]string{RuleLoadBalancer}))
+
+		severity, _, err = classifier.Classify(expression.Input{Kind: "Service", Action: "Update", Old: service("LoadBalancer"), New: service("LoadBalancer")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(severity).To(Equal(loghandler.SeverityInfo))
+	})
+
+	It("Should apply custom rules along with the built-in ones", func() {
+		rule, err := NewRule("halved", loghandler.SeverityLow, "new.spec.replicas <= old.spec.replicas / 2")
+		Expect(err).NotTo(HaveOccurred())
+		failing, err := NewRule("team", loghandler.SeverityCritical, "new.metadata.labels.team == 'payments'")
+		Expect(err).NotTo(HaveOccurred())
+
+		severity, rules, err := NewClassifier(rule, failing).Classify(expression.Input{
+			Kind:   "Deployment",
+			Action: "Update",
+			Old:    deployment(4, container("app:1", false)),
+			New:    deployment(2, container("app:1", false)),
+		})
+		Expect(err).To(MatchError(ContainSubstring("rule team")))
+		Expect(severity).To(Equal(loghandler.SeverityLow))
+		Expect(rules).To(Equal([]string{"halved"}))
+
+		_, err = NewRule("unknown", "urgent", "true")
+		Expect(err).To(HaveOccurred())
+	})
+})
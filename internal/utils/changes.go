@@ -1,48 +1,179 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/vandathron/watchman/internal/loghandler"
 	"reflect"
+	"sort"
 )
 
-// RecordChanges compares non(struct, func, chan) fields of old and new. Records changes
-// by adding the difference to data.
-func RecordChanges(old, new interface{}, prefix string, data *loghandler.Data) error {
-	oldValue := reflect.ValueOf(old)
-	newValue := reflect.ValueOf(new)
+// mergeKeys are the keys identifying the items of named lists, in order of preference, e.g (containers, env, ports).
+var mergeKeys = []string{"name", "mountPath", "containerPort", "port"}
 
-	if oldValue.Type() != newValue.Type() {
+// RecordChanges compares old and new by their JSON representation. Records changes
+// by adding every added, removed or replaced leaf field to data. Paths are rooted at prefix e.g .spec
+// Items of named lists are matched by their merge key e.g (.spec.template.spec.containers[name=app].image), other lists by
+// index. A field set to null is recorded as the JSON value null rather than as a missing field.
+func RecordChanges(old, new interface{}, prefix string, data *loghandler.Data) error {
+	if reflect.TypeOf(old) != reflect.TypeOf(new) {
 		return fmt.Errorf("old and new types not the same")
 	}
 
-	if oldValue.Kind() != reflect.Struct {
-		return fmt.Errorf("must be a struct")
+	oldValue, err := toJSONValue(old)
+	if err != nil {
+		return err
 	}
 
-	for i := 0; i < oldValue.NumField(); i++ {
-		oldField := oldValue.Field(i)
-		if oldField.Kind() == reflect.Struct || oldField.Kind() == reflect.Func || oldField.Kind() == reflect.Chan {
-			continue
+	newValue, err := toJSONValue(new)
+	if err != nil {
+		return err
+	}
+
+	return recordValueChanges(oldValue, newValue, prefix, data)
+}
+
+func toJSONValue(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err = json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func recordValueChanges(old, new interface{}, path string, data *loghandler.Data) error {
+	if reflect.DeepEqual(old, new) {
+		return nil
+	}
+
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
 		}
+		sort.Strings(keys)
 
-		structField := oldValue.Type().Field(i)
-		if structField.IsExported() {
-			continue
+		for _, k := range keys {
+			oldItem, oldOk := oldMap[k]
+			newItem, newOk := newMap[k]
+			if err := recordValueChanges(present(oldItem, oldOk), present(newItem, newOk), fmt.Sprintf("%s.%s", path, k), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := new.([]interface{})
+	if oldIsList && newIsList {
+		if key := mergeKey(oldList, newList); key != "" {
+			return recordKeyedListChanges(oldList, newList, key, path, data)
 		}
-		newField := newValue.Field(i)
 
-		if structField.Type.Kind() == reflect.Ptr {
-			if !reflect.DeepEqual(oldField.Elem().Interface(), newField.Elem().Interface()) {
-				data.AddField(fmt.Sprintf("%s%s", prefix, ""), fmt.Sprintf("%v", newField.Elem().Interface()))
+		for i := 0; i < len(oldList) || i < len(newList); i++ {
+			var oldItem, newItem interface{}
+			if i < len(oldList) {
+				oldItem = oldList[i]
+			}
+			if i < len(newList) {
+				newItem = newList[i]
+			}
+
+			if err := recordValueChanges(oldItem, newItem, fmt.Sprintf("%s[%d]", path, i), data); err != nil {
+				return err
 			}
-			continue
 		}
+		return nil
+	}
+
+	return data.AddChange(path, old, new)
+}
 
-		if !reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
-			data.AddField(fmt.Sprintf("%s%s", prefix, ""), fmt.Sprintf("%v", newField.Interface()))
+// present returns value, with a field set to null as the JSON value null so it is not taken for a missing field.
+func present(value interface{}, ok bool) interface{} {
+	if ok && value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
+
+// mergeKey returns the first of mergeKeys set, with a unique scalar value, on every item of both lists. Empty if none
+// is, the lists being compared by index.
+func mergeKey(lists ...[]interface{}) string {
+	for _, key := range mergeKeys {
+		if keyedBy(key, lists...) {
+			return key
 		}
 	}
+	return ""
+}
+
+func keyedBy(key string, lists ...[]interface{}) bool {
+	for _, list := range lists {
+		seen := map[string]bool{}
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			value, ok := itemKey(m, key)
+			if !ok || seen[value] {
+				return false
+			}
+			seen[value] = true
+		}
+	}
+	return true
+}
 
+func itemKey(item map[string]interface{}, key string) (string, bool) {
+	switch value := item[key].(type) {
+	case string:
+		return value, true
+	case float64:
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
+
+// recordKeyedListChanges records the changes of the items of two lists matched by key, in the order of new then of the
+// removed items of old. Reordering items is not a change.
+func recordKeyedListChanges(old, new []interface{}, key, path string, data *loghandler.Data) error {
+	oldItems := make(map[string]interface{}, len(old))
+	for _, item := range old {
+		value, _ := itemKey(item.(map[string]interface{}), key)
+		oldItems[value] = item
+	}
+
+	newKeys := make(map[string]bool, len(new))
+	for _, item := range new {
+		value, _ := itemKey(item.(map[string]interface{}), key)
+		newKeys[value] = true
+		if err := recordValueChanges(oldItems[value], item, fmt.Sprintf("%s[%s=%s]", path, key, value), data); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range old {
+		value, _ := itemKey(item.(map[string]interface{}), key)
+		if newKeys[value] {
+			continue
+		}
+		if err := recordValueChanges(item, nil, fmt.Sprintf("%s[%s=%s]", path, key, value), data); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/vandathron/watchman/internal/loghandler"
+)
+
+var _ = Describe("RecordChanges", func() {
+	type change struct {
+		Path, Op, Old, New string
+	}
+
+	record := func(old, new interface{}) []change {
+		data := loghandler.NewData(SupportedKindDeployment)
+		Expect(RecordChanges(old, new, ".spec", data)).To(Succeed())
+
+		changes := []change{}
+		for _, c := range data.Changes() {
+			changes = append(changes, change{c.Path(), string(c.Op()), string(c.OldValue()), string(c.NewValue())})
+		}
+		return changes
+	}
+
+	container := func(name, image string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "image": image}
+	}
+	containers := func(items ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"containers": items}
+	}
+
+	DescribeTable("Should record the changed leaves",
+		func(old, new interface{}, expected []change) {
+			Expect(record(old, new)).To(Equal(expected))
+		},
+		Entry("no change",
+			map[string]interface{}{"replicas": 1.0}, map[string]interface{}{"replicas": 1.0},
+			[]change{}),
+		Entry("replaced field",
+			map[string]interface{}{"replicas": 1.0}, map[string]interface{}{"replicas": 3.0},
+			[]change{{".spec.replicas", "replace", "1", "3"}}),
+		Entry("added and removed fields",
+			map[string]interface{}{"paused": true}, map[string]interface{}{"replicas": 3.0},
+			[]change{{".spec.paused", "remove", "true", ""}, {".spec.replicas", "add", "", "3"}}),
+		Entry("field set to null",
+			map[string]interface{}{"replicas": nil}, map[string]interface{}{"replicas": 3.0},
+			[]change{{".spec.replicas", "replace", "null", "3"}}),
+		Entry("field unset to null",
+			map[string]interface{}{"replicas": 3.0}, map[string]interface{}{"replicas": nil},
+			[]change{{".spec.replicas", "replace", "3", "null"}}),
+		Entry("unnamed list by index",
+			map[string]interface{}{"args": []interface{}{"a", "b"}}, map[string]interface{}{"args": []interface{}{"a", "c", "d"}},
+			[]change{{".spec.args[1]", "replace", `"b"`, `"c"`}, {".spec.args[2]", "add", "", `"d"`}}),
+		Entry("item inserted in a named list",
+			containers(container("app", "app:1"), container("proxy", "proxy:1")),
+			containers(container("init", "init:1"), container("app", "app:1"), container("proxy", "proxy:2")),
+			[]change{
+				{".spec.containers[name=init]", "add", "", `{"image":"init:1","name":"init"}`},
+				{".spec.containers[name=proxy].image", "replace", `"proxy:1"`, `"proxy:2"`},
+			}),
+		Entry("item removed from a named list",
+			containers(container("app", "app:1"), container("proxy", "proxy:1")),
+			containers(container("proxy", "proxy:1")),
+			[]change{{".spec.containers[name=app]", "remove", `{"image":"app:1","name":"app"}`, ""}}),
+		Entry("named list reordered",
+			containers(container("app", "app:1"), container("proxy", "proxy:1")),
+			containers(container("proxy", "proxy:1"), container("app", "app:1")),
+			[]change{}),
+		Entry("env vars by name",
+			map[string]interface{}{"env": []interface{}{map[string]interface{}{"name": "A", "value": "1"}}},
+			map[string]interface{}{"env": []interface{}{map[string]interface{}{"name": "B", "value": "2"}, map[string]interface{}{"name": "A", "value": "3"}}},
+			[]change{
+				{".spec.env[name=B]", "add", "", `{"name":"B","value":"2"}`},
+				{".spec.env[name=A].value", "replace", `"1"`, `"3"`},
+			}),
+		Entry("unnamed ports by number",
+			map[string]interface{}{"ports": []interface{}{map[string]interface{}{"containerPort": 80.0, "protocol": "TCP"}}},
+			map[string]interface{}{"ports": []interface{}{map[string]interface{}{"containerPort": 8080.0, "protocol": "TCP"}, map[string]interface{}{"containerPort": 80.0, "protocol": "UDP"}}},
+			[]change{
+				{".spec.ports[containerPort=8080]", "add", "", `{"containerPort":8080,"protocol":"TCP"}`},
+				{".spec.ports[containerPort=80].protocol", "replace", `"TCP"`, `"UDP"`},
+			}),
+		Entry("list with duplicate names by index",
+			containers(container("app", "app:1"), container("app", "app:2")),
+			containers(container("app", "app:1"), container("app", "app:3")),
+			[]change{{".spec.containers[1].image", "replace", `"app:2"`, `"app:3"`}}),
+	)
+
+	It("Should match keyed paths with [*] patterns", func() {
+		changes := record(containers(container("app", "app:1")), containers(container("app", "app:2")))
+		Expect(loghandler.MatchPath(".spec.containers[*].image", changes[0].Path)).To(BeTrue())
+	})
+
+	It("Should fail on different types", func() {
+		Expect(RecordChanges(1, "1", ".spec", loghandler.NewData(SupportedKindDeployment))).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"encoding/json"
+	jsonmergepatch "github.com/evanphx/json-patch/v5"
+	"github.com/vandathron/watchman/internal/loghandler"
+	"gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DefaultPatchSizeLimit is the maximum size in bytes of each patch attached to an audit event.
+const DefaultPatchSizeLimit = 32 * 1024
+
+// ComputePatches computes the JSON Patch and JSON Merge Patch from old to new. Status, server managed metadata and
+// watchman's own annotations are left out. A patch larger than limit bytes is dropped and marked as truncated,
+// a limit <= 0 means no limit.
+func ComputePatches(old, new runtime.Object, limit int) (*loghandler.Patch, error) {
+	oldJSON, err := patchableJSON(old)
+	if err != nil {
+		return nil, err
+	}
+
+	newJSON, err := patchableJSON(new)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := jsonpatch.CreatePatch(oldJSON, newJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonPatch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	mergePatch, err := jsonmergepatch.CreateMergePatch(oldJSON, newJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := &loghandler.Patch{JSONPatch: jsonPatch, MergePatch: mergePatch}
+	if limit > 0 && len(patch.JSONPatch) > limit {
+		patch.JSONPatch = nil
+		patch.Truncated = append(patch.Truncated, "jsonPatch")
+	}
+	if limit > 0 && len(patch.MergePatch) > limit {
+		patch.MergePatch = nil
+		patch.Truncated = append(patch.Truncated, "mergePatch")
+	}
+
+	return patch, nil
+}
+
+func patchableJSON(obj runtime.Object) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(content)}
+	delete(u.Object, "status")
+	u.SetManagedFields(nil)
+	u.SetResourceVersion("")
+	u.SetGeneration(0)
+
+	if annotations := u.GetAnnotations(); annotations != nil {
+		delete(annotations, WatchActionTypeAnnotationKey)
+		delete(annotations, WatchUpdateStateKey)
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		u.SetAnnotations(annotations)
+	}
+
+	return u.MarshalJSON()
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("ComputePatches", func() {
+	var old, new *appsv1.Deployment
+
+	BeforeEach(func() {
+		old = &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", ResourceVersion: "1", Generation: 1},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: ptr.To[int32](1),
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:1"}}}},
+			},
+		}
+		new = old.DeepCopy()
+	})
+
+	It("Should compute the JSON Patch and JSON Merge Patch", func() {
+		new.Spec.Replicas = ptr.To[int32](3)
+
+		patch, err := ComputePatches(old, new, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch.JSONPatch).To(MatchJSON(`[{"op":"replace","path":"/spec/replicas","value":3}]`))
+		Expect(patch.MergePatch).To(MatchJSON(`{"spec":{"replicas":3}}`))
+		Expect(patch.Truncated).To(BeEmpty())
+	})
+
+	It("Should leave out status, server managed metadata and watchman annotations", func() {
+		new.ResourceVersion, new.Generation = "2", 2
+		new.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+		new.Status.Replicas = 3
+		new.Annotations = map[string]string{WatchActionTypeAnnotationKey: WatchActionTypeUpdate, WatchUpdateStateKey: "{}"}
+
+		patch, err := ComputePatches(old, new, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch.JSONPatch).To(MatchJSON(`[]`))
+		Expect(patch.MergePatch).To(MatchJSON(`{}`))
+	})
+
+	It("Should drop the patches over the size limit", func() {
+		new.Spec.Replicas = ptr.To[int32](3)
+
+		patch, err := ComputePatches(old, new, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(patch.MergePatch)).To(BeNumerically("<", len(patch.JSONPatch)))
+		limit := len(patch.MergePatch)
+
+		patch, err = ComputePatches(old, new, limit)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch.JSONPatch).To(BeNil())
+		Expect(patch.MergePatch).NotTo(BeNil())
+		Expect(patch.Truncated).To(Equal([]string{"jsonPatch"}))
+
+		patch, err = ComputePatches(old, new, limit-1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch.JSONPatch).To(BeNil())
+		Expect(patch.MergePatch).To(BeNil())
+		Expect(patch.Truncated).To(Equal([]string{"jsonPatch", "mergePatch"}))
+	})
+
+	It("Should produce a JSON Patch applying to the old object", func() {
+		new.Spec.Template.Spec.Containers = append(new.Spec.Template.Spec.Containers, corev1.Container{Name: "proxy", Image: "proxy:1"})
+
+		patch, err := ComputePatches(old, new, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		var ops []map[string]interface{}
+		Expect(json.Unmarshal(patch.JSONPatch, &ops)).To(Succeed())
+		Expect(ops).To(ConsistOf(HaveKeyWithValue("path", "/spec/template/spec/containers/1")))
+	})
+})
@@ -15,3 +15,11 @@ func SupportsAllKinds(kinds ...string) bool {
 	}
 	return true
 }
+
+var resourceKinds = map[string]string{"deployments": SupportedKindDeployment, "services": SupportedKindService}
+
+// KindOfResource returns the kind of the objects of resource e.g (Deployment for deployments), or "" if unsupported.
+// Requests to a subresource such as deployments/scale are thereby attributed to the kind of their parent.
+func KindOfResource(resource string) string {
+	return resourceKinds[resource]
+}
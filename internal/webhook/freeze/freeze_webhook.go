@@ -0,0 +1,132 @@
+// Package freeze rejects changes to the resources selected by watches during their Enforce freeze windows.
+package freeze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/freeze"
+	"github.com/vandathron/watchman/internal/utils"
+	"github.com/vandathron/watchman/internal/webhook/identity"
+)
+
+// Path the webhook is served at.
+const Path = "/validate-freeze"
+
+var freezelog = logf.Log.WithName("freeze-webhook")
+
+// SetupFreezeWebhookWithManager registers the freeze webhook in the manager.
+func SetupFreezeWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(Path, &webhook.Admission{Handler: &FreezeValidator{
+		Client: mgr.GetClient(),
+		Self:   &identity.Resolver{Client: mgr.GetClient()},
+	}})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-freeze,mutating=false,failurePolicy=ignore,sideEffects=None,groups=apps,resources=deployments;deployments/scale,verbs=create;update;delete,versions=v1,name=vfreeze-deployments.kb.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-freeze,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=services,verbs=create;update;delete,versions=v1,name=vfreeze-services.kb.io,admissionReviewVersions=v1
+
+// GarbageCollector is the user deleting the dependents of deleted owners, always allowed to.
+const GarbageCollector = "system:serviceaccount:kube-system:generic-garbage-collector"
+
+// FreezeValidator rejects changes made during an active Enforce window of a watch selecting the resource, unless the
+// requesting user or one of their groups is exempt. Metadata only updates, e.g removing a finalizer or the revision
+// annotation of a Deployment set by its controller, the deletions of the garbage collector and the changes of the
+// operator itself, e.g annotating resources or reverting drift, are always allowed.
+type FreezeValidator struct {
+	Client client.Client
+	Self   *identity.Resolver
+}
+
+var _ admission.Handler = &FreezeValidator{}
+
+// Handle implements admission.Handler.
+func (v *FreezeValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	switch req.Operation {
+	case admissionv1.Update:
+		var obj, old struct {
+			Spec map[string]interface{} `json:"spec"`
+		}
+		if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if equality.Semantic.DeepEqual(obj.Spec, old.Spec) {
+			return admission.Allowed("")
+		}
+	case admissionv1.Delete:
+		if req.UserInfo.Username == GarbageCollector {
+			return admission.Allowed("")
+		}
+	}
+
+	self, err := v.Self.IsSelf(ctx, req.UserInfo.Username)
+	if err != nil {
+		freezelog.Error(err, "Failed to resolve operator identity")
+	}
+	if self {
+		return admission.Allowed("")
+	}
+
+	watchList := &auditv1alpha1.WatchList{}
+	if err := v.Client.List(ctx, watchList); err != nil {
+		freezelog.Error(err, "Failed to list watches")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	// The requests to deployments/scale, e.g kubectl scale or an HPA, are of kind Scale but freeze their Deployment
+	kind := utils.KindOfResource(req.Resource.Resource)
+	if kind == "" {
+		kind = req.Kind.Kind
+	}
+	violations := Violations(watchList.Items, kind, req.Namespace, req.UserInfo, time.Now())
+	if len(violations) > 0 {
+		return admission.Denied(fmt.Sprintf("%s %s/%s is frozen: %s", kind, req.Namespace, req.Name, strings.Join(violations, "; ")))
+	}
+	return admission.Allowed("")
+}
+
+// Violations describes the Enforce windows of watches active at now that freeze kind in namespace for user.
+func Violations(watches []auditv1alpha1.Watch, kind, namespace string, user authenticationv1.UserInfo, now time.Time) []string {
+	var violations []string
+	for _, watch := range watches {
+		if !watch.Selects(namespace, kind) {
+			continue
+		}
+
+		// Invalid windows are rejected by the Watch webhook, those failing to compile are skipped
+		windows, err := freeze.NewWindows(watch.Spec.FreezeWindows)
+		if err != nil {
+			freezelog.Error(err, "Invalid freeze windows", "Watch", watch.Name, "Namespace", watch.Namespace)
+			continue
+		}
+
+		for _, window := range windows {
+			if window.Mode != auditv1alpha1.FreezeModeEnforce || !window.Selects(kind) || window.Exempts(user.Username, user.Groups) {
+				continue
+			}
+			if active, until := window.Active(now); active {
+				violations = append(violations, fmt.Sprintf("freeze window %s of watch %s/%s is active until %s",
+					window.Name, watch.Namespace, watch.Name, until.UTC().Format(time.RFC3339)))
+			}
+		}
+	}
+	return violations
+}
@@ -0,0 +1,121 @@
+package freeze
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/webhook/identity"
+)
+
+var _ = Describe("Freeze Webhook", func() {
+	now := time.Date(2026, 12, 24, 12, 0, 0, 0, time.UTC)
+	alice := authenticationv1.UserInfo{Username: "alice", Groups: []string{"dev"}}
+
+	var watch auditv1alpha1.Watch
+
+	BeforeEach(func() {
+		watch = auditv1alpha1.Watch{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "audit"},
+			Spec: auditv1alpha1.WatchSpec{
+				Selectors: []auditv1alpha1.WatchSelector{{Namespace: "payments", Kinds: []string{"Deployment", "Service"}}},
+				FreezeWindows: []auditv1alpha1.FreezeWindow{{
+					Name:         "end-of-year",
+					Start:        "2026-12-20T00:00:00Z",
+					End:          "2027-01-04T00:00:00Z",
+					Mode:         auditv1alpha1.FreezeModeEnforce,
+					Kinds:        []string{"Deployment"},
+					ExemptGroups: []string{"sre"},
+				}},
+			},
+		}
+	})
+
+	It("Should reject changes to frozen kinds during an Enforce window", func() {
+		violations := Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, now)
+		Expect(violations).To(ConsistOf("freeze window end-of-year of watch audit/prod is active until 2027-01-04T00:00:00Z"))
+	})
+
+	It("Should allow changes outside the window, kinds and namespaces it freezes", func() {
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, now.AddDate(0, 1, 0))).To(BeEmpty())
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Service", "payments", alice, now)).To(BeEmpty())
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "orders", alice, now)).To(BeEmpty())
+	})
+
+	It("Should allow changes of exempt users and groups", func() {
+		sre := authenticationv1.UserInfo{Username: "bob", Groups: []string{"sre"}}
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", sre, now)).To(BeEmpty())
+	})
+
+	It("Should allow changes during an Audit window", func() {
+		watch.Spec.FreezeWindows[0].Mode = auditv1alpha1.FreezeModeAudit
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, now)).To(BeEmpty())
+	})
+
+	Context("Handling requests during an Enforce window", func() {
+		var validator *FreezeValidator
+
+		request := func(operation admissionv1.Operation, user string, old, obj string) admission.Request {
+			return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: operation,
+				Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Namespace: "payments",
+				Name:      "api",
+				UserInfo:  authenticationv1.UserInfo{Username: user},
+				Object:    runtime.RawExtension{Raw: []byte(obj)},
+				OldObject: runtime.RawExtension{Raw: []byte(old)},
+			}}
+		}
+
+		BeforeEach(func() {
+			now := time.Now()
+			watch.Spec.FreezeWindows[0].Start = now.Add(-time.Hour).UTC().Format(time.RFC3339)
+			watch.Spec.FreezeWindows[0].End = now.Add(time.Hour).UTC().Format(time.RFC3339)
+
+			scheme := runtime.NewScheme()
+			Expect(auditv1alpha1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&watch).Build()
+			validator = &FreezeValidator{Client: c, Self: &identity.Resolver{Client: c, TokenPath: "/nonexistent"}}
+		})
+
+		It("Should reject spec updates", func() {
+			response := validator.Handle(context.Background(), request(admissionv1.Update, "alice",
+				`{"spec": {"replicas": 1}}`, `{"spec": {"replicas": 2}}`))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("is frozen"))
+		})
+
+		It("Should reject scaling a Deployment through its scale subresource", func() {
+			req := request(admissionv1.Update, "system:serviceaccount:kube-system:horizontal-pod-autoscaler",
+				`{"spec": {"replicas": 1}}`, `{"spec": {"replicas": 3}}`)
+			req.Kind = metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"}
+			req.Resource = metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+			req.SubResource = "scale"
+
+			response := validator.Handle(context.Background(), req)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("Deployment payments/api is frozen"))
+		})
+
+		It("Should allow metadata only updates", func() {
+			response := validator.Handle(context.Background(), request(admissionv1.Update, "alice",
+				`{"metadata": {"finalizers": ["example.com/cleanup"]}, "spec": {"replicas": 1}}`,
+				`{"metadata": {"annotations": {"deployment.kubernetes.io/revision": "2"}}, "spec": {"replicas": 1}}`))
+			Expect(response.Allowed).To(BeTrue())
+		})
+
+		It("Should allow deletions by the garbage collector only", func() {
+			Expect(validator.Handle(context.Background(), request(admissionv1.Delete, "alice", `{}`, ``)).Result.Message).To(ContainSubstring("is frozen"))
+			Expect(validator.Handle(context.Background(), request(admissionv1.Delete, GarbageCollector, `{}`, ``)).Allowed).To(BeTrue())
+		})
+	})
+})
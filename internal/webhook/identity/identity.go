@@ -0,0 +1,91 @@
+// Package identity resolves the user the operator is authenticated as, so the webhooks guarding the resources it
+// watches never reject its own changes, e.g annotating resources or reverting drift.
+package identity
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// serviceAccountTokenPath is the token of the service account mounted in the pod of the operator.
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Resolver asks the API server who the operator is on first use and remembers it. The subject of the service account
+// token of the operator is used instead if the API server can not tell, e.g without the SelfSubjectReview API.
+type Resolver struct {
+	Client client.Client
+
+	// TokenPath is the service account token of the operator. Defaults to the token mounted in its pod
+	TokenPath string
+
+	mu       sync.Mutex
+	username string
+}
+
+// Username returns the username of the operator.
+func (r *Resolver) Username(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.username == "" {
+		review := &authenticationv1.SelfSubjectReview{}
+		if err := r.Client.Create(ctx, review); err != nil {
+			username, tokenErr := r.tokenSubject()
+			if tokenErr != nil {
+				return "", err
+			}
+			review.Status.UserInfo.Username = username
+		}
+		r.username = review.Status.UserInfo.Username
+	}
+	return r.username, nil
+}
+
+// tokenSubject returns the subject of the service account token of the operator, its username. The token is not
+// verified, being the operator's own.
+func (r *Resolver) tokenSubject() (string, error) {
+	path := r.TokenPath
+	if path == "" {
+		path = serviceAccountTokenPath
+	}
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid service account token %s", path)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return "", err
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("service account token %s has no subject", path)
+	}
+	return claims.Subject, nil
+}
+
+// IsSelf reports whether username is the operator. It is not if the operator can not be resolved.
+func (r *Resolver) IsSelf(ctx context.Context, username string) (bool, error) {
+	self, err := r.Username(ctx)
+	if err != nil {
+		return false, err
+	}
+	return username == self, nil
+}
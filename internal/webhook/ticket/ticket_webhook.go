@@ -0,0 +1,150 @@
+// Package ticket rejects the updates of resources selected by watches requiring a change ticket unless they carry one.
+package ticket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/utils"
+	"github.com/vandathron/watchman/internal/webhook/identity"
+)
+
+// Path the webhook is served at.
+const Path = "/validate-ticket"
+
+var ticketlog = logf.Log.WithName("ticket-webhook")
+
+// SetupTicketWebhookWithManager registers the change ticket webhook in the manager.
+func SetupTicketWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(Path, &webhook.Admission{Handler: &TicketValidator{
+		Client: mgr.GetClient(),
+		Self:   &identity.Resolver{Client: mgr.GetClient()},
+	}})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-ticket,mutating=false,failurePolicy=ignore,sideEffects=None,groups=apps,resources=deployments;deployments/scale,verbs=update,versions=v1,name=vticket-deployments.kb.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-ticket,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=services,verbs=update,versions=v1,name=vticket-services.kb.io,admissionReviewVersions=v1
+
+// TicketValidator rejects the spec updates of resources selected by a watch requiring a change ticket unless they are
+// annotated with a ticket matching its pattern. Metadata only updates, e.g the revision annotation of a Deployment set
+// by its controller, and the updates of exempt users and of the operator itself are allowed. The ticket is that of the
+// resource once updated, so it keeps covering later updates until replaced.
+type TicketValidator struct {
+	Client client.Client
+	Self   *identity.Resolver
+}
+
+var _ admission.Handler = &TicketValidator{}
+
+// Handle implements admission.Handler.
+func (v *TicketValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var obj, old struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec map[string]interface{} `json:"spec"`
+	}
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if equality.Semantic.DeepEqual(obj.Spec, old.Spec) {
+		return admission.Allowed("")
+	}
+
+	self, err := v.Self.IsSelf(ctx, req.UserInfo.Username)
+	if err != nil {
+		ticketlog.Error(err, "Failed to resolve operator identity")
+	}
+	if self {
+		return admission.Allowed("")
+	}
+
+	watchList := &auditv1alpha1.WatchList{}
+	if err := v.Client.List(ctx, watchList); err != nil {
+		ticketlog.Error(err, "Failed to list watches")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	kind := utils.KindOfResource(req.Resource.Resource)
+	if kind == "" {
+		kind = req.Kind.Kind
+	}
+
+	// The requests to deployments/scale, e.g kubectl scale or an HPA, are of kind Scale which carries no annotations;
+	// they are checked against the ticket of their Deployment
+	annotations := obj.Metadata.Annotations
+	if req.SubResource != "" {
+		deployment := &appsv1.Deployment{}
+		if err := v.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: req.Name}, deployment); err != nil {
+			ticketlog.Error(err, "Failed to fetch deployment", "Name", req.Name, "Namespace", req.Namespace)
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		annotations = deployment.Annotations
+	}
+
+	violations := Violations(watchList.Items, kind, req.Namespace, req.UserInfo, annotations)
+	if len(violations) > 0 {
+		return admission.Denied(fmt.Sprintf("%s %s/%s requires a change ticket: %s", kind, req.Namespace, req.Name, strings.Join(violations, "; ")))
+	}
+	return admission.Allowed("")
+}
+
+// Violations describes the change tickets of watches selecting kind in namespace that annotations lack for user.
+func Violations(watches []auditv1alpha1.Watch, kind, namespace string, user authenticationv1.UserInfo, annotations map[string]string) []string {
+	var violations []string
+	for _, watch := range watches {
+		t := watch.Spec.ChangeTicket
+		if t == nil || !watch.Selects(namespace, kind) || (len(t.Kinds) > 0 && !slices.Contains(t.Kinds, kind)) || exempt(t, user) {
+			continue
+		}
+
+		ticket, ok := annotations[t.Annotation]
+		switch {
+		case !ok || ticket == "":
+			violations = append(violations, fmt.Sprintf("annotation %s is required by watch %s/%s", t.Annotation, watch.Namespace, watch.Name))
+		case t.Pattern != "":
+			// Invalid patterns are rejected by the Watch webhook, those failing to compile are skipped
+			pattern, err := regexp.Compile(t.Pattern)
+			if err != nil {
+				ticketlog.Error(err, "Invalid change ticket pattern", "Watch", watch.Name, "Namespace", watch.Namespace)
+				continue
+			}
+			if !pattern.MatchString(ticket) {
+				violations = append(violations, fmt.Sprintf("ticket %q does not match %s required by watch %s/%s", ticket, t.Pattern, watch.Namespace, watch.Name))
+			}
+		}
+	}
+	return violations
+}
+
+func exempt(t *auditv1alpha1.ChangeTicket, user authenticationv1.UserInfo) bool {
+	if slices.Contains(t.ExemptUsers, user.Username) {
+		return true
+	}
+	for _, group := range user.Groups {
+		if slices.Contains(t.ExemptGroups, group) {
+			return true
+		}
+	}
+	return false
+}
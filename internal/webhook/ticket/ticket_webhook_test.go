@@ -0,0 +1,97 @@
+package ticket
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
+	"github.com/vandathron/watchman/internal/webhook/identity"
+)
+
+var _ = Describe("Ticket Webhook", func() {
+	const annotation = "change.my.domain/ticket"
+	alice := authenticationv1.UserInfo{Username: "alice", Groups: []string{"dev"}}
+
+	var watch auditv1alpha1.Watch
+
+	BeforeEach(func() {
+		watch = auditv1alpha1.Watch{
+			ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "audit"},
+			Spec: auditv1alpha1.WatchSpec{
+				Selectors: []auditv1alpha1.WatchSelector{{Namespace: "payments", Kinds: []string{"Deployment", "Service"}}},
+				ChangeTicket: &auditv1alpha1.ChangeTicket{
+					Annotation:   annotation,
+					Pattern:      "^CHG-[0-9]+$",
+					Kinds:        []string{"Deployment"},
+					ExemptGroups: []string{"sre"},
+				},
+			},
+		}
+	})
+
+	It("Should reject updates without a ticket", func() {
+		violations := Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, nil)
+		Expect(violations).To(ConsistOf("annotation change.my.domain/ticket is required by watch audit/prod"))
+	})
+
+	It("Should reject updates with a ticket not matching the pattern", func() {
+		violations := Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, map[string]string{annotation: "JIRA-1"})
+		Expect(violations).To(ConsistOf(`ticket "JIRA-1" does not match ^CHG-[0-9]+$ required by watch audit/prod`))
+	})
+
+	It("Should allow updates with a matching ticket", func() {
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, map[string]string{annotation: "CHG-42"})).To(BeEmpty())
+
+		watch.Spec.ChangeTicket.Pattern = ""
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", alice, map[string]string{annotation: "JIRA-1"})).To(BeEmpty())
+	})
+
+	It("Should allow updates of kinds and namespaces not requiring a ticket, and of exempt users", func() {
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Service", "payments", alice, nil)).To(BeEmpty())
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "orders", alice, nil)).To(BeEmpty())
+
+		sre := authenticationv1.UserInfo{Username: "bob", Groups: []string{"sre"}}
+		Expect(Violations([]auditv1alpha1.Watch{watch}, "Deployment", "payments", sre, nil)).To(BeEmpty())
+	})
+
+	Context("Handling requests to the scale subresource", func() {
+		scale := func(deployment *appsv1.Deployment) admission.Response {
+			scheme := runtime.NewScheme()
+			Expect(auditv1alpha1.AddToScheme(scheme)).To(Succeed())
+			Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&watch, deployment).Build()
+			validator := &TicketValidator{Client: c, Self: &identity.Resolver{Client: c, TokenPath: "/nonexistent"}}
+
+			return validator.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation:   admissionv1.Update,
+				Kind:        metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"},
+				Resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+				SubResource: "scale",
+				Namespace:   "payments",
+				Name:        "api",
+				UserInfo:    alice,
+				Object:      runtime.RawExtension{Raw: []byte(`{"spec": {"replicas": 3}}`)},
+				OldObject:   runtime.RawExtension{Raw: []byte(`{"spec": {"replicas": 1}}`)},
+			}})
+		}
+
+		It("Should check the ticket of the Deployment scaled", func() {
+			deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments"}}
+			response := scale(deployment)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("Deployment payments/api requires a change ticket"))
+
+			deployment.Annotations = map[string]string{annotation: "CHG-42"}
+			Expect(scale(deployment).Allowed).To(BeTrue())
+		})
+	})
+})
@@ -3,7 +3,15 @@ package v1alpha1
 import (
 	"context"
 	"fmt"
+	"github.com/vandathron/watchman/internal/expression"
+	"github.com/vandathron/watchman/internal/freeze"
+	"github.com/vandathron/watchman/internal/redaction"
+	"github.com/vandathron/watchman/internal/relations"
+	"github.com/vandathron/watchman/internal/severity"
 	"github.com/vandathron/watchman/internal/utils"
+	"regexp"
+	"slices"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -76,17 +84,7 @@ func (v *WatchCustomValidator) ValidateCreate(ctx context.Context, obj runtime.O
 	}
 	watchlog.Info("Validation for Watch upon creation", "name", watch.GetName())
 
-	if len(watch.Spec.Selectors) == 0 {
-		return nil, fmt.Errorf("selector can not be empty. Should contain at least a namespace")
-	}
-
-	for _, selector := range watch.Spec.Selectors {
-		if !utils.SupportsAllKinds(selector.Kinds...) {
-			return nil, fmt.Errorf("unsupported kind(s) in namespace %s", selector.Namespace)
-		}
-	}
-
-	return nil, nil
+	return nil, validateWatch(watch)
 }
 
 // ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type Watch.
@@ -97,19 +95,115 @@ func (v *WatchCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newOb
 	}
 	watchlog.Info("Validation for Watch upon update", "name", watch.GetName())
 
+	return nil, validateWatch(watch)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type Watch.
+func (v *WatchCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateWatch(watch *auditv1alpha1.Watch) error {
 	if len(watch.Spec.Selectors) == 0 {
-		return nil, fmt.Errorf("selector can not be empty. Should contain at least a namespace")
+		return fmt.Errorf("selector can not be empty. Should contain at least a namespace")
 	}
 
 	for _, selector := range watch.Spec.Selectors {
 		if !utils.SupportsAllKinds(selector.Kinds...) {
-			return nil, fmt.Errorf("unsupported kind(s) in namespace %s", selector.Namespace)
+			return fmt.Errorf("unsupported kind(s) in namespace %s", selector.Namespace)
 		}
 	}
-	return nil, nil
+
+	if r := watch.Spec.Redaction; r != nil {
+		if _, err := redaction.NewPolicy(r.Paths, r.KeyPatterns); err != nil {
+			return fmt.Errorf("invalid redaction policy: %w", err)
+		}
+	}
+
+	if _, err := expression.NewProgram(watch.Spec.Condition, watch.Spec.Enrichments); err != nil {
+		return fmt.Errorf("invalid expressions: %w", err)
+	}
+
+	names := map[string]bool{}
+	for _, rule := range watch.Spec.SeverityRules {
+		if _, err := severity.NewRule(rule.Name, rule.Severity, rule.Condition); err != nil {
+			return fmt.Errorf("invalid severity rule: %w", err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate severity rule %s", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	if b := watch.Spec.Baseline; b != nil {
+		if b.ConfigMapName == "" && len(b.Fields) == 0 {
+			return fmt.Errorf("invalid baseline: fields are required without a configMapName")
+		}
+		for _, field := range b.Fields {
+			if !strings.HasPrefix(field, ".") {
+				return fmt.Errorf("invalid baseline: field %q should start with a dot e.g .spec.replicas", field)
+			}
+		}
+	}
+
+	windows := map[string]bool{}
+	for _, window := range watch.Spec.FreezeWindows {
+		if _, err := freeze.NewWindow(window); err != nil {
+			return fmt.Errorf("invalid freeze window: %w", err)
+		}
+		if !utils.SupportsAllKinds(window.Kinds...) {
+			return fmt.Errorf("unsupported kind(s) in freeze window %s", window.Name)
+		}
+		if windows[window.Name] {
+			return fmt.Errorf("duplicate freeze window %s", window.Name)
+		}
+		windows[window.Name] = true
+	}
+
+	if t := watch.Spec.ChangeTicket; t != nil {
+		if _, err := regexp.Compile(t.Pattern); err != nil {
+			return fmt.Errorf("invalid change ticket pattern: %w", err)
+		}
+		if !utils.SupportsAllKinds(t.Kinds...) {
+			return fmt.Errorf("unsupported kind(s) in change ticket")
+		}
+	}
+
+	if related := watch.Spec.Related; related != nil {
+		for _, kind := range related.Kinds {
+			if !slices.Contains(relations.Kinds, kind) {
+				return fmt.Errorf("unsupported related kind %s, expected one of %s", kind, strings.Join(relations.Kinds, ", "))
+			}
+		}
+	}
+
+	for i, route := range watch.Spec.Routes {
+		if err := validateRoute(route); err != nil {
+			return fmt.Errorf("invalid route %d: %w", i, err)
+		}
+	}
+
+	return nil
 }
 
-// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type Watch.
-func (v *WatchCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
-	return nil, nil
+func validateRoute(route auditv1alpha1.WatchRoute) error {
+	if len(route.Sinks) == 0 {
+		return fmt.Errorf("sinks can not be empty")
+	}
+	if route.Match == nil {
+		return nil
+	}
+
+	// Routes match the events of related objects as well as those of the resources selected
+	for _, kind := range route.Match.Kinds {
+		if !utils.SupportsAllKinds(kind) && !slices.Contains(relations.Kinds, kind) {
+			return fmt.Errorf("unsupported kind %s in match", kind)
+		}
+	}
+	for _, path := range route.Match.ChangedPaths {
+		if !strings.HasPrefix(path, ".") {
+			return fmt.Errorf("changed path %q should start with a dot e.g .spec.replicas", path)
+		}
+	}
+	return nil
 }
@@ -3,9 +3,12 @@ package v1alpha1
 import (
 	"errors"
 	"fmt"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/vandathron/watchman/internal/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/strings/slices"
 
 	auditv1alpha1 "github.com/vandathron/watchman/api/v1alpha1"
@@ -93,6 +96,151 @@ var _ = Describe("Watch Webhook", func() {
 			})
 		})
 
+		When("creating Watch resource with an invalid redaction policy", func() {
+			It("Should fail validation", func() {
+				By("Providing a key pattern that is not a valid regular expression")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.Redaction = &auditv1alpha1.RedactionPolicy{KeyPatterns: []string{"(?i)password("}}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("invalid redaction policy")))
+			})
+		})
+
+		When("creating Watch resource with an invalid route", func() {
+			It("Should fail validation", func() {
+				By("Providing a route without sinks and a changed path without a leading dot")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.Routes = []auditv1alpha1.WatchRoute{{Sinks: []string{"archive"}}, {}}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("invalid route 1")))
+
+				watch.Spec.Routes[1] = auditv1alpha1.WatchRoute{
+					Match: &auditv1alpha1.EventFilter{ChangedPaths: []string{"spec.replicas"}},
+					Sinks: []string{"chat"},
+				}
+				_, err = validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("should start with a dot")))
+			})
+		})
+
+		When("creating Watch resource with a route matching related objects", func() {
+			It("Should pass validation", func() {
+				By("Providing a route matching the Ingresses related to the selected Deployments")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.Related = &auditv1alpha1.RelatedAudit{}
+				watch.Spec.Routes = []auditv1alpha1.WatchRoute{{
+					Match: &auditv1alpha1.EventFilter{Kinds: []string{"Ingress"}},
+					Sinks: []string{"network"},
+				}}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).NotTo(HaveOccurred())
+
+				watch.Spec.Routes[0].Match.Kinds = []string{"ConfigMap"}
+				_, err = validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("unsupported kind ConfigMap in match")))
+			})
+		})
+
+		When("creating Watch resource with invalid expressions", func() {
+			It("Should fail validation", func() {
+				By("Providing a condition that does not return a bool")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.Condition = "actor + '-'"
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("invalid condition")))
+
+				By("Providing an enrichment that does not compile")
+				watch.Spec.Condition = "new.spec.replicas < old.spec.replicas"
+				watch.Spec.Enrichments = map[string]string{"team": "new.metadata.labels["}
+				_, err = validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("invalid enrichment team")))
+			})
+		})
+
+		When("creating Watch resource with an invalid severity rule", func() {
+			It("Should fail validation", func() {
+				By("Providing a rule with an unknown severity")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.SeverityRules = []auditv1alpha1.SeverityRule{{Name: "scale-down", Severity: "urgent", Condition: "new.spec.replicas < old.spec.replicas"}}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("unknown severity")))
+
+				By("Providing two rules with the same name")
+				watch.Spec.SeverityRules[0].Severity = "high"
+				watch.Spec.SeverityRules = append(watch.Spec.SeverityRules, watch.Spec.SeverityRules[0])
+				_, err = validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("duplicate severity rule scale-down")))
+			})
+		})
+
+		When("creating Watch resource with a baseline pinning nothing", func() {
+			It("Should fail validation", func() {
+				By("Providing a baseline without fields nor ConfigMap")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.Baseline = &auditv1alpha1.Baseline{Mode: auditv1alpha1.BaselineModeRevert}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("fields are required")))
+
+				watch.Spec.Baseline.ConfigMapName = "prod-manifests"
+				_, err = validator.ValidateCreate(ctx, watch)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		When("creating Watch resource with an invalid freeze window", func() {
+			It("Should fail validation", func() {
+				By("Providing a recurring window without a duration")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.FreezeWindows = []auditv1alpha1.FreezeWindow{{Name: "weekend", Schedule: "0 18 * * 5", TimeZone: "Europe/Berlin"}}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("needs a positive duration")))
+
+				By("Providing two windows with the same name")
+				watch.Spec.FreezeWindows[0].Duration = &metav1.Duration{Duration: 62 * time.Hour}
+				watch.Spec.FreezeWindows = append(watch.Spec.FreezeWindows, watch.Spec.FreezeWindows[0])
+				_, err = validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("duplicate freeze window weekend")))
+			})
+		})
+
+		When("creating Watch resource with an invalid change ticket pattern", func() {
+			It("Should fail validation", func() {
+				By("Providing a pattern that does not compile")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.ChangeTicket = &auditv1alpha1.ChangeTicket{Annotation: "change.my.domain/ticket", Pattern: "^CHG-[0-9+$"}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("invalid change ticket pattern")))
+			})
+		})
+
+		When("creating Watch resource auditing an unsupported related kind", func() {
+			It("Should fail validation", func() {
+				By("Providing a kind that is never related")
+				watch.Spec.Selectors = append(watch.Spec.Selectors, auditv1alpha1.WatchSelector{Namespace: "ns-1", Kinds: []string{"Deployment"}})
+				watch.Spec.Related = &auditv1alpha1.RelatedAudit{Kinds: []string{"HorizontalPodAutoscaler", "ConfigMap"}}
+
+				By("calling ValidateCreate method to validate object")
+				_, err := validator.ValidateCreate(ctx, watch)
+				Expect(err).To(MatchError(ContainSubstring("unsupported related kind ConfigMap")))
+			})
+		})
+
 		When("deleting watch resource", func() {
 			It("Should do nothing", func() {
 				By("calling ValidateDelete method to validate object")